@@ -1,6 +1,7 @@
 package gobackup
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,22 +11,24 @@ import (
 	"strings"
 
 	"github.com/jeamon/gobackup/pkg/app"
+	"github.com/jeamon/gobackup/pkg/config"
 )
 
 // Execute is the entry point of the application. It processes the command-line arguments
-// and calls the associated routine (version or help or monitor or logs filtering) if valid.
+// and calls the associated routine (version or help or monitor or logs filtering or restore
+// or verification or scheduled deletions or config validation) if valid.
 func Execute(buildTime, commit, tag string) int {
 	buildTime = normalizeFlag(buildTime)
 	commit, tag = normalizeFlag(commit), normalizeFlag(tag)
 
 	var option Option
-	monitorCommand, logsCommand := option.SetFlags()
+	commands := option.SetFlags()
 
 	if ok := printVersionOrHelp(os.Stdout, os.Args, buildTime, commit, tag); ok {
 		return 0
 	}
 
-	if ok := isMonitorOrLogsViewCommandArgs(os.Args); !ok {
+	if ok := isValidCommandArgs(os.Args); !ok {
 		fmt.Printf("Invalid syntax. Run '%s help' for usage.\n", filepath.Base(os.Args[0]))
 		return 1
 	}
@@ -33,28 +36,72 @@ func Execute(buildTime, commit, tag string) int {
 	command := strings.ToLower(os.Args[1])
 	switch command {
 	case "monitor":
-		if err := monitorCommand.Parse(os.Args[2:]); err != nil {
+		if err := option.parseMonitorArgs(commands[command], os.Args[2:]); err != nil {
 			log.Printf("app monitoring mode: failed to parse arguments provided: %v", err)
 			return 1
 		}
 
-		exitCode, err := app.Backup(runtime.NumCPU()*2-1, option.logFilePath, option.srcPath, option.dstPath, commit, tag)
+		exitCode, err := app.BackupJobs(option.workers(), option.monitor.LogFile, commit, tag, option.monitor.Debug, option.jobs())
 		if err != nil {
 			log.Printf("app monitoring mode: %v", err)
 		}
 		return exitCode
 
 	case "logs":
-		if err := logsCommand.Parse(os.Args[2:]); err != nil {
+		if err := commands[command].Parse(os.Args[2:]); err != nil {
 			log.Printf("app logs filtering mode: failed to parse arguments provided: %v", err)
 			return 1
 		}
 
-		exitCode, err := app.ViewLogs(option.fileToFilter, option.date, option.regex)
+		exitCode, err := app.ViewLogs(option.fileToFilter, option.date, option.regex, option.job)
 		if err != nil {
 			log.Printf("app logs filtering mode: logs filtering mode: %v", err)
 		}
 		return exitCode
+
+	case "restore":
+		if err := commands[command].Parse(os.Args[2:]); err != nil {
+			log.Printf("app restore mode: failed to parse arguments provided: %v", err)
+			return 1
+		}
+
+		exitCode, err := app.Restore(os.Stdout, option.restoreOptions())
+		if err != nil {
+			log.Printf("app restore mode: %v", err)
+		}
+		return exitCode
+
+	case "verify":
+		if err := commands[command].Parse(os.Args[2:]); err != nil {
+			log.Printf("app verify mode: failed to parse arguments provided: %v", err)
+			return 1
+		}
+
+		exitCode, err := app.Verify(os.Stdout, option.verifyOptions())
+		if err != nil {
+			log.Printf("app verify mode: %v", err)
+		}
+		return exitCode
+
+	case "schedule":
+		if err := commands[command].Parse(os.Args[3:]); err != nil {
+			log.Printf("app schedule mode: failed to parse arguments provided: %v", err)
+			return 1
+		}
+
+		exitCode, err := runSchedule(os.Stdout, strings.ToLower(os.Args[2]), option)
+		if err != nil {
+			log.Printf("app schedule mode: %v", err)
+		}
+		return exitCode
+
+	case "config":
+		if err := commands[command].Parse(os.Args[3:]); err != nil {
+			log.Printf("app config mode: failed to parse arguments provided: %v", err)
+			return 1
+		}
+
+		return validateConfig(os.Stdout, option.configPath)
 	}
 	return 0
 }
@@ -79,21 +126,78 @@ func printVersionOrHelp(out io.Writer, args []string, buildTime, commit, tag str
 	return false
 }
 
-// isMonitorOrLogsViewCommandArgs checks if the commands line arguments satisfy
-// the minimal requirements to run the app into monitoring or log-filtering mode.
-// To run the app we expect at least 5 arguments. See commands examples below :
+// isValidCommandArgs checks if the commands line arguments satisfy the minimal
+// requirements to run the app into monitoring or log-filtering or restore or verify
+// mode or to validate a config file. To run the app we expect at least 5 arguments
+// unless the monitor settings come from a config file. See commands examples:
 // appExec monitor [-file <logpath>] -source <src> -backup <dst>
-// appExec logs [-file <logpath>] -date <date> -regex <regex>
-func isMonitorOrLogsViewCommandArgs(args []string) bool {
-	if len(args) < 6 {
+// appExec monitor -config <path> [-source <src>] [-backup <dst>]
+// appExec logs [-file <logpath>] -date <date> -regex <regex> [-job <name>]
+// appExec restore -backup <dst|archive> [-source <src>] [-target <path>]
+// appExec verify -backup <dst|archive> [-key-file <path>]
+// appExec schedule list -backup <dst>
+// appExec schedule cancel -backup <dst> [-path <path>] [-at <time>]
+// appExec config validate -config <path>
+func isValidCommandArgs(args []string) bool {
+	if len(args) < 3 {
 		return false
 	}
 
-	cmd := args[1]
-	if cmd != "monitor" && cmd != "logs" {
-		return false
+	switch args[1] {
+	case "monitor":
+		return len(args) >= 6 || hasConfigFlag(args[2:])
+	case "logs", "restore":
+		return len(args) >= 6
+	case "verify":
+		return len(args) >= 4
+	case "schedule":
+		return len(args) >= 5 && (args[2] == "list" || args[2] == "cancel")
+	case "config":
+		return len(args) >= 5 && args[2] == "validate"
+	}
+	return false
+}
+
+// hasConfigFlag checks if the `-config` flag is part of the arguments.
+func hasConfigFlag(args []string) bool {
+	for _, arg := range args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "config" {
+			return true
+		}
+	}
+	return false
+}
+
+// runSchedule lists or cancels the deletions scheduled
+// into the backup folder based on the `subcommand`.
+func runSchedule(out io.Writer, subcommand string, option Option) (int, error) {
+	if subcommand == "cancel" {
+		return app.CancelSchedule(out, option.scheduleDir, option.schedulePath, option.scheduleAt)
+	}
+	return app.ListSchedule(out, option.scheduleDir)
+}
+
+// validateConfig checks the config file at `path` and reports into `out`
+// each error with its line number. It returns the exit code.
+func validateConfig(out io.Writer, path string) int {
+	var cfg config.Config
+	err := config.Load(path, &cfg)
+	if err == nil {
+		fmt.Fprintf(out, "valid config file: %s\n", path)
+		return 0
+	}
+
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		fmt.Fprintf(out, "invalid config file: %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Fprintf(out, "invalid config file: %s\n", path)
+	for _, e := range errs {
+		fmt.Fprintf(out, "%s:%d: %s\n", path, e.Line, e.Msg)
 	}
-	return true
+	return 1
 }
 
 // isVersionCommand checks if argument is any `version` keyword.
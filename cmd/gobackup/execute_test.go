@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jeamon/gobackup/pkg/app"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsVersionCommand(t *testing.T) {
@@ -108,7 +112,7 @@ func TestPrintVersionOrHelp(t *testing.T) {
 	}
 }
 
-func TestIsMonitorOrLogsViewCommandArgs(t *testing.T) {
+func TestIsValidCommandArgs(t *testing.T) {
 	cases := []struct {
 		name string
 		args []string
@@ -174,12 +178,72 @@ func TestIsMonitorOrLogsViewCommandArgs(t *testing.T) {
 			strings.Fields("logs -file file.log -date date"),
 			true,
 		},
+		{
+			"restore shortest command",
+			strings.Fields("restore -backup dstpath -source srcpath"),
+			true,
+		},
+		{
+			"restore longest command",
+			strings.Fields("restore -backup dstpath -target path -pattern *.txt -dry-run -force"),
+			true,
+		},
+		{
+			"invalid restore command",
+			strings.Fields("restore -backup dstpath"),
+			false,
+		},
+		{
+			"monitor with config file",
+			strings.Fields("monitor -config gobackup.yaml"),
+			true,
+		},
+		{
+			"monitor with config file and value",
+			strings.Fields("monitor --config=gobackup.yaml"),
+			true,
+		},
+		{
+			"config validate command",
+			strings.Fields("config validate -config gobackup.yaml"),
+			true,
+		},
+		{
+			"unknown config subcommand",
+			strings.Fields("config check -config gobackup.yaml"),
+			false,
+		},
+		{
+			"verify command",
+			strings.Fields("verify -backup dstpath"),
+			true,
+		},
+		{
+			"invalid verify command",
+			strings.Fields("verify -backup"),
+			false,
+		},
+		{
+			"schedule list command",
+			strings.Fields("schedule list -backup dstpath"),
+			true,
+		},
+		{
+			"schedule cancel command",
+			strings.Fields("schedule cancel -backup dstpath -path srcpath/file.txt"),
+			true,
+		},
+		{
+			"unknown schedule subcommand",
+			strings.Fields("schedule clear -backup dstpath"),
+			false,
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			args := append([]string{"gobackup"}, tc.args...)
-			got := isMonitorOrLogsViewCommandArgs(args)
+			got := isValidCommandArgs(args)
 			assert.Equal(t, tc.want, got)
 		})
 	}
@@ -247,11 +311,41 @@ func TestExecute(t *testing.T) {
 			strings.Fields("logs -file noexist.log.txt -date date -regex *.bak"),
 			1,
 		},
+		{
+			"restore: command with invalid backup path",
+			strings.Fields("restore -backup noexist.folderpath -source srcpath"),
+			1,
+		},
 		{
 			"unknown command",
 			strings.Fields("unknown.command -date date -regex *.bak"),
 			1,
 		},
+		{
+			"verify: command with invalid backup path",
+			strings.Fields("verify -backup noexist.folderpath"),
+			1,
+		},
+		{
+			"schedule: list with invalid backup path",
+			strings.Fields("schedule list -backup noexist.folderpath"),
+			1,
+		},
+		{
+			"schedule: cancel with invalid backup path",
+			strings.Fields("schedule cancel -backup noexist.folderpath -path file.txt"),
+			1,
+		},
+		{
+			"monitor: missing config file",
+			strings.Fields("monitor -config noexist.gobackup.yaml"),
+			1,
+		},
+		{
+			"config: missing config file",
+			strings.Fields("config validate -config noexist.gobackup.yaml"),
+			1,
+		},
 	}
 
 	for _, tc := range cases {
@@ -262,3 +356,108 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMonitorArgs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gobackup.yaml")
+	content := "source: /data/source\nbackup: /data/backup\nworkers: 4\nversioning:\n  enabled: true\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	var option Option
+	commands := option.SetFlags()
+	args := strings.Fields("-config " + path + " -backup /mnt/backup -max-revisions 3")
+	require.NoError(t, option.parseMonitorArgs(commands["monitor"], args))
+
+	m := option.monitor
+	assert.Equal(t, "/data/source", m.Source)
+	assert.Equal(t, "/mnt/backup", m.Backup)
+	assert.Equal(t, 4, option.workers())
+	assert.Equal(t, true, m.Versioning.Enabled)
+	assert.Equal(t, 3, m.Versioning.MaxRevisions)
+	// values missing from both keep their default.
+	assert.Equal(t, "file.log", m.LogFile)
+	assert.Equal(t, ".bak", m.Extension)
+}
+
+func TestPatternsFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gobackup.yaml")
+	content := "source: /data/source\nbackup: /data/backup\ninclude: [\"*.txt\"]\nexclude: [\"*.swp\"]\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	var option Option
+	commands := option.SetFlags()
+	args := strings.Fields("-config " + path + " -exclude *.tmp,build/,.git/ -debug -orphans remove -on-delete mirror -archive-interval 1h -keep-daily 7 -archive-format tar.gz -archive-stream")
+	require.NoError(t, option.parseMonitorArgs(commands["monitor"], args))
+
+	opts := monitorOptions(option.monitor.Job)
+	assert.Equal(t, []string{"*.txt"}, opts.Include)
+	assert.Equal(t, []string{"*.tmp", "build/", ".git/"}, opts.Exclude)
+	assert.Equal(t, true, option.monitor.Debug)
+	assert.Equal(t, true, opts.Sync)
+	assert.Equal(t, app.OrphansRemove, opts.Orphans)
+	assert.Equal(t, app.DeleteMirror, opts.OnDelete)
+	assert.Equal(t, 24*time.Hour, opts.DeleteGrace)
+	assert.Equal(t, time.Hour, opts.ArchiveInterval)
+	assert.Equal(t, 7, opts.KeepDaily)
+	assert.Equal(t, "tar.gz", opts.ArchiveFormat)
+	assert.Equal(t, true, opts.ArchiveStream)
+
+	var p patterns
+	assert.Error(t, p.Set("*.swp,[abc"))
+}
+
+func TestJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gobackup.yaml")
+	content := "compression:\n  algorithm: gzip\njobs:\n" +
+		"  - name: docs\n    source: /docs\n    backup: /backup/docs\n" +
+		"  - name: photos\n    source: /photos\n    backup: /backup/photos\n    extension: .photo\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	var option Option
+	commands := option.SetFlags()
+	args := strings.Fields("-config " + path + " -extension .backup")
+	require.NoError(t, option.parseMonitorArgs(commands["monitor"], args))
+
+	jobs := option.jobs()
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "docs", jobs[0].Name)
+	assert.Equal(t, "/docs", jobs[0].Source)
+	assert.Equal(t, "/backup/docs", jobs[0].Backup)
+	assert.Equal(t, ".backup", jobs[0].Options.Extension)
+	assert.Equal(t, "gzip", jobs[0].Options.Compression)
+	assert.Equal(t, "photos", jobs[1].Name)
+	assert.Equal(t, "/photos", jobs[1].Source)
+	// flags provided explicitly override the values of each entry.
+	assert.Equal(t, ".backup", jobs[1].Options.Extension)
+	assert.Equal(t, "gzip", jobs[1].Options.Compression)
+}
+
+func TestRunSchedule(t *testing.T) {
+	option := Option{scheduleDir: t.TempDir()}
+	out := bytes.NewBuffer(nil)
+	code, err := runSchedule(out, "list", option)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "no scheduled deletion\n", out.String())
+
+	option.schedulePath = "file.txt"
+	code, err = runSchedule(out, "cancel", option)
+	assert.EqualError(t, err, "no matching scheduled deletion")
+	assert.Equal(t, 1, code)
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	require.NoError(t, os.WriteFile(valid, []byte("workers: 2\n"), 0o644))
+	invalid := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("workers: 2\ndelete_interval: -1s\n"), 0o644))
+
+	out := bytes.NewBuffer(nil)
+	assert.Equal(t, 0, validateConfig(out, valid))
+	assert.Equal(t, "valid config file: "+valid+"\n", out.String())
+
+	out.Reset()
+	assert.Equal(t, 1, validateConfig(out, invalid))
+	expected := "invalid config file: " + invalid + "\n" + invalid + ":2: delete_interval must be positive\n"
+	assert.Equal(t, expected, out.String())
+}
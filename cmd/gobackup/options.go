@@ -1,27 +1,324 @@
 package gobackup
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
-// Option represents user inputs.
+	"github.com/jeamon/gobackup/pkg/app"
+	"github.com/jeamon/gobackup/pkg/config"
+	"github.com/jeamon/gobackup/pkg/ignore"
+	"github.com/jeamon/gobackup/pkg/storage"
+)
+
+// Option represents user inputs. Monitor command flags are bound
+// to the configuration so they override values loaded from a file.
 type Option struct {
 	srcPath      string
-	dstPath      string
 	date         string
 	regex        string
-	logFilePath  string
+	job          string
 	fileToFilter string
+	configPath   string
+	monitor      config.Config
+	monitorFlags *flag.FlagSet // monitor flags parsed from `monitorArgs`.
+	monitorArgs  []string
+	keyFile      string
+	extension    string
+	restoreFrom  string
+	restoreTo    string
+	verifyFrom   string
+	scheduleDir  string
+	schedulePath string
+	scheduleAt   string
+	pattern      string
+	dryRun       bool
+	force        bool
 }
 
-// SetFlags configures flags for both monitoring and logs filtering commands.
-func (o *Option) SetFlags() (*flag.FlagSet, *flag.FlagSet) {
+// SetFlags configures flags for each command (monitoring, logs filtering, restore,
+// verification, scheduled deletions and config validation) and returns them indexed by the command name.
+func (o *Option) SetFlags() map[string]*flag.FlagSet {
+	m := &o.monitor
 	monitorCommand := flag.NewFlagSet("monitor", flag.ExitOnError)
-	monitorCommand.StringVar(&o.logFilePath, "file", "file.log", "path to the file for logging.")
-	monitorCommand.StringVar(&o.srcPath, "source", "", "path of the source folder to monitor its content.")
-	monitorCommand.StringVar(&o.dstPath, "backup", "", "path of the backup folder for storing copied files.")
+	monitorCommand.StringVar(&o.configPath, "config", "", "path to a YAML or JSON configuration file. Flags override its values.")
+	monitorCommand.StringVar(&m.LogFile, "file", "file.log", "path to the file for logging.")
+	monitorCommand.StringVar(&m.Source, "source", "", "path of the source folder to monitor its content.")
+	monitorCommand.StringVar(&m.Backup, "backup", "", "path of the backup folder for storing copied files.")
+	monitorCommand.IntVar(&m.Workers, "workers", 0, "number of backup workers (0 for twice the number of CPUs minus one).")
+	monitorCommand.StringVar(&m.Extension, "extension", ".bak", "extension of backup files.")
+	monitorCommand.StringVar(&m.DeletePrefix, "delete-prefix", "delete_", "prefix of file names requesting a deletion.")
+	monitorCommand.DurationVar(&m.DeleteInterval, "delete-interval", 500*time.Millisecond, "delay between checks of scheduled deletions.")
+	monitorCommand.BoolVar(&m.Versioning.Enabled, "versions", false, "keep previous revisions of each backed up file.")
+	monitorCommand.IntVar(&m.Versioning.MaxRevisions, "max-revisions", 10, "maximum number of revisions kept per file (0 for no limit).")
+	monitorCommand.DurationVar(&m.Versioning.MaxAge, "max-age", 0, "maximum age of a revision before it gets pruned (0 for no limit).")
+	monitorCommand.BoolVar(&m.Dedup, "dedup", false, "store files as deduplicated content-defined chunks.")
+	monitorCommand.StringVar(&m.S3.Endpoint, "s3-endpoint", "", "base url of an S3-compatible service to upload backup files into.")
+	monitorCommand.StringVar(&m.S3.Bucket, "s3-bucket", "", "name of the S3 bucket.")
+	monitorCommand.StringVar(&m.S3.Region, "s3-region", "us-east-1", "region of the S3 bucket.")
+	monitorCommand.StringVar(&m.S3.Prefix, "s3-prefix", "backup/", "key prefix of backup files into the S3 bucket.")
+	monitorCommand.StringVar(&m.S3.ArchivePrefix, "s3-archive-prefix", "archives/", "key prefix of zip archives into the S3 bucket.")
+	monitorCommand.Int64Var(&m.S3.PartSize, "s3-part-size", 8, "size in MiB of each part for multipart uploads.")
+	monitorCommand.BoolVar(&m.S3.UploadArchive, "s3-upload-archive", false, "upload the zip archive into the S3 bucket on exit.")
+	monitorCommand.StringVar(&m.SFTP.Address, "sftp-addr", "", "host:port of an SSH server to upload backup files over SFTP.")
+	monitorCommand.StringVar(&m.SFTP.User, "sftp-user", "", "user to login on the SSH server.")
+	monitorCommand.StringVar(&m.SFTP.KeyFile, "sftp-key", defaultSSHFile("id_ed25519"), "path to the private key used for authentication.")
+	monitorCommand.StringVar(&m.SFTP.KnownHostsFile, "sftp-known-hosts", defaultSSHFile("known_hosts"), "path to the known_hosts file to verify the server.")
+	monitorCommand.StringVar(&m.SFTP.Root, "sftp-root", "", "path of the remote folder for storing backup files.")
+	monitorCommand.StringVar(&m.SFTP.SpoolFolder, "sftp-spool", "", "path of the folder keeping uploads not yet sent (default <backup>/.spool).")
+	monitorCommand.BoolVar(&m.Encryption.Enabled, "encrypt", false, "encrypt backup files with the -key-file key or the GOBACKUP_PASSPHRASE passphrase.")
+	monitorCommand.StringVar(&m.Encryption.KeyFile, "key-file", "", "path to the file of a 32 bytes (raw or hex) encryption key.")
+	monitorCommand.StringVar(&m.Compression.Algorithm, "compress", "", "algorithm (gzip or zstd) to compress each backup file with.")
+	monitorCommand.IntVar(&m.Compression.Level, "compress-level", 0, "compression level, 1-9 for gzip and 1-22 for zstd (0 for default).")
+	monitorCommand.Var((*patterns)(&m.Include), "include", "comma-separated gitignore-style patterns of the only files to back up.")
+	monitorCommand.Var((*patterns)(&m.Exclude), "exclude", "comma-separated gitignore-style patterns of files and folders not to back up.")
+	monitorCommand.DurationVar(&m.Debounce, "debounce", 0, "quiet window to merge bursts of events of a file before its backup (0 to disable).")
+	monitorCommand.DurationVar(&m.RenameWindow, "rename-window", time.Second, "delay to pair the deletion and the creation of a renamed file (0 to disable).")
+	monitorCommand.BoolVar(&m.Sync.Enabled, "sync", true, "back up missing and stale files of the source folder on startup.")
+	monitorCommand.BoolVar(&m.Sync.Hash, "sync-hash", false, "compare contents of files with their backup on startup (slower).")
+	monitorCommand.StringVar(&m.Sync.Orphans, "orphans", "keep", "what to do on startup with backup files without source file (keep, flag or remove).")
+	monitorCommand.StringVar(&m.Deletion.Policy, "on-delete", "keep", "what to do with backup files of deleted files (keep, trash or mirror).")
+	monitorCommand.DurationVar(&m.Deletion.Retention, "trash-retention", 30*24*time.Hour, "delay before trashed backup files get deleted (0 to keep them).")
+	monitorCommand.DurationVar(&m.Deletion.Grace, "delete-grace", 24*time.Hour, "delay before deleting backup files of deleted files with -on-delete mirror.")
+	monitorCommand.BoolVar(&m.SafeCopy.Enabled, "safe-copy", false, "copy again files modified during their copy (reflink copy when supported).")
+	monitorCommand.IntVar(&m.SafeCopy.Retries, "copy-retries", 3, "maximum copies retried of a file modified during its copy.")
+	monitorCommand.DurationVar(&m.SafeCopy.WaitClose, "wait-close", 0, "maximum delay to wait for writers to close a file before its safe copy (0 to disable).")
+	monitorCommand.StringVar(&m.Archive.Format, "archive-format", "zip", "format of archives of the backup folder: zip, tar.gz or tar.zst.")
+	monitorCommand.BoolVar(&m.Archive.Stream, "archive-stream", false, "walk the backup folder while archiving instead of listing it first.")
+	monitorCommand.DurationVar(&m.Archive.Interval, "archive-interval", 0, "delay between two archives of the backup folder while running (0 to disable).")
+	monitorCommand.StringVar(&m.Archive.Cron, "archive-cron", "", "cron expression (minute hour day month weekday) of the times to make archives.")
+	monitorCommand.IntVar(&m.Archive.KeepHourly, "keep-hourly", 0, "number of last hours to keep the newest archive of.")
+	monitorCommand.IntVar(&m.Archive.KeepDaily, "keep-daily", 0, "number of last days to keep the newest archive of.")
+	monitorCommand.IntVar(&m.Archive.KeepWeekly, "keep-weekly", 0, "number of last weeks to keep the newest archive of.")
+	monitorCommand.BoolVar(&m.Debug, "debug", false, "log debug level entries like events of ignored files.")
 
 	logsCommand := flag.NewFlagSet("logs", flag.ExitOnError)
 	logsCommand.StringVar(&o.fileToFilter, "file", "file.log", "path to the log file for filtering.")
 	logsCommand.StringVar(&o.date, "date", "", "date of log entries to display.")
 	logsCommand.StringVar(&o.regex, "regex", "", "regex to match against filename into logs.")
-	return monitorCommand, logsCommand
+	logsCommand.StringVar(&o.job, "job", "", "name of the job to display log entries of (default all).")
+
+	restoreCommand := flag.NewFlagSet("restore", flag.ExitOnError)
+	restoreCommand.StringVar(&o.restoreFrom, "backup", "", "path of the backup folder or archive to restore from.")
+	restoreCommand.StringVar(&o.srcPath, "source", "", "path of the original source folder to restore into.")
+	restoreCommand.StringVar(&o.restoreTo, "target", "", "path of an alternate folder to restore into.")
+	restoreCommand.StringVar(&o.pattern, "pattern", "", "relative path, folder or glob of files to restore (default all).")
+	restoreCommand.BoolVar(&o.dryRun, "dry-run", false, "only display files which would be restored.")
+	restoreCommand.BoolVar(&o.force, "force", false, "overwrite existing files even if they are newer than the backup.")
+	restoreCommand.StringVar(&o.keyFile, "key-file", "", "path to the key file to decrypt encrypted backup files.")
+	restoreCommand.StringVar(&o.extension, "extension", ".bak", "extension of backup files.")
+
+	verifyCommand := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyCommand.StringVar(&o.verifyFrom, "backup", "", "path of the backup folder or archive to verify.")
+	verifyCommand.StringVar(&o.keyFile, "key-file", "", "path to the key file to decrypt encrypted backup files.")
+	verifyCommand.StringVar(&o.extension, "extension", ".bak", "extension of backup files.")
+
+	scheduleCommand := flag.NewFlagSet("schedule", flag.ExitOnError)
+	scheduleCommand.StringVar(&o.scheduleDir, "backup", "", "path of the backup folder holding the scheduled deletions.")
+	scheduleCommand.StringVar(&o.schedulePath, "path", "", "path of the file whose scheduled deletion to cancel.")
+	scheduleCommand.StringVar(&o.scheduleAt, "at", "", "RFC3339 time of the scheduled deletions to cancel.")
+
+	configCommand := flag.NewFlagSet("config", flag.ExitOnError)
+	configCommand.StringVar(&o.configPath, "config", "", "path to the YAML or JSON configuration file to validate.")
+
+	return map[string]*flag.FlagSet{
+		"monitor":  monitorCommand,
+		"logs":     logsCommand,
+		"restore":  restoreCommand,
+		"verify":   verifyCommand,
+		"schedule": scheduleCommand,
+		"config":   configCommand,
+	}
+}
+
+// parseMonitorArgs parses the monitor command arguments. When a config file
+// is provided, its values are loaded then the arguments are parsed again so
+// flags provided explicitly override the config file values.
+func (o *Option) parseMonitorArgs(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	o.monitorFlags, o.monitorArgs = fs, args
+	if o.configPath == "" {
+		return nil
+	}
+	if err := config.Load(o.configPath, &o.monitor); err != nil {
+		return fmt.Errorf("invalid config file %s:\n%w", o.configPath, err)
+	}
+	return fs.Parse(args)
+}
+
+// patterns is a flag value holding a comma-separated list of patterns.
+// Setting it replaces the patterns loaded from the config file.
+type patterns []string
+
+// String implements flag.Value interface.
+func (p *patterns) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(*p, ",")
+}
+
+// Set implements flag.Value interface.
+func (p *patterns) Set(value string) error {
+	*p = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*p = append(*p, v)
+		}
+	}
+	return ignore.Validate(*p)
+}
+
+// passphraseEnv is the environment variable holding the encryption passphrase.
+const passphraseEnv = "GOBACKUP_PASSPHRASE"
+
+// jobs builds the jobs to run from the monitor configuration.
+func (o *Option) jobs() []app.Job {
+	var jobs []app.Job
+	for _, j := range o.monitor.Resolve() {
+		if len(o.monitor.Jobs) > 0 {
+			j = o.withFlags(j)
+		}
+		jobs = append(jobs, app.Job{Name: j.Name, Source: j.Source, Backup: j.Backup, Options: monitorOptions(j)})
+	}
+	return jobs
+}
+
+// withFlags applies the monitor flags provided explicitly on the job `j`
+// decoded from an entry of `jobs` so they override its values like they
+// override the top level ones. The name and folders of the entry are kept.
+func (o *Option) withFlags(j config.Job) config.Job {
+	if o.monitorFlags == nil {
+		return j
+	}
+	top := o.monitor.Job
+	defer func() { o.monitor.Job = top }()
+	// flags are bound to the top level settings so the entry takes their place.
+	o.monitor.Job = j
+	// the arguments were already parsed successfully.
+	_ = o.monitorFlags.Parse(o.monitorArgs)
+	job := o.monitor.Job
+	job.Name, job.Source, job.Backup = j.Name, j.Source, j.Backup
+	return job
+}
+
+// monitorOptions builds the App options from the job configuration.
+// S3 credentials are loaded from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables and the encryption passphrase from GOBACKUP_PASSPHRASE
+// so they never show up into the processes list nor configuration files.
+func monitorOptions(m config.Job) app.Options {
+	opts := app.Options{
+		Versioning:      m.Versioning.Enabled,
+		MaxRevisions:    m.Versioning.MaxRevisions,
+		MaxAge:          m.Versioning.MaxAge,
+		Dedup:           m.Dedup,
+		UploadArchive:   m.S3.UploadArchive,
+		Compression:     m.Compression.Algorithm,
+		Level:           m.Compression.Level,
+		Extension:       m.Extension,
+		DeletePrefix:    m.DeletePrefix,
+		DeleteInterval:  m.DeleteInterval,
+		Include:         m.Include,
+		Exclude:         m.Exclude,
+		Debounce:        m.Debounce,
+		RenameWindow:    m.RenameWindow,
+		Sync:            m.Sync.Enabled,
+		SyncHash:        m.Sync.Hash,
+		Orphans:         m.Sync.Orphans,
+		OnDelete:        m.Deletion.Policy,
+		TrashRetention:  m.Deletion.Retention,
+		DeleteGrace:     m.Deletion.Grace,
+		SafeCopy:        m.SafeCopy.Enabled,
+		CopyRetries:     m.SafeCopy.Retries,
+		WaitClose:       m.SafeCopy.WaitClose,
+		ArchiveFormat:   m.Archive.Format,
+		ArchiveStream:   m.Archive.Stream,
+		ArchiveInterval: m.Archive.Interval,
+		ArchiveCron:     m.Archive.Cron,
+		KeepHourly:      m.Archive.KeepHourly,
+		KeepDaily:       m.Archive.KeepDaily,
+		KeepWeekly:      m.Archive.KeepWeekly,
+	}
+	if m.S3.Endpoint != "" {
+		opts.S3 = &storage.S3Options{
+			Endpoint:      m.S3.Endpoint,
+			Region:        m.S3.Region,
+			Bucket:        m.S3.Bucket,
+			AccessKey:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:     os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Prefix:        m.S3.Prefix,
+			ArchivePrefix: m.S3.ArchivePrefix,
+			PartSize:      m.S3.PartSize * 1024 * 1024,
+		}
+	}
+	if m.SFTP.Address != "" {
+		opts.SFTP = &storage.SFTPOptions{
+			Address:        m.SFTP.Address,
+			User:           m.SFTP.User,
+			KeyFile:        m.SFTP.KeyFile,
+			KnownHostsFile: m.SFTP.KnownHostsFile,
+			Root:           m.SFTP.Root,
+			SpoolFolder:    m.SFTP.SpoolFolder,
+		}
+	}
+	if m.Encryption.Enabled {
+		opts.KeyFile = m.Encryption.KeyFile
+		if opts.KeyFile == "" {
+			opts.Passphrase = os.Getenv(passphraseEnv)
+		}
+	}
+	return opts
+}
+
+// workers returns the number of backup workers to start.
+func (o *Option) workers() int {
+	if o.monitor.Workers > 0 {
+		return o.monitor.Workers
+	}
+	return runtime.NumCPU()*2 - 1
+}
+
+// restoreOptions builds the restore options from the restore command flags.
+// Files are restored into the original source folder unless a target is set.
+func (o *Option) restoreOptions() app.RestoreOptions {
+	to := o.restoreTo
+	if to == "" {
+		to = o.srcPath
+	}
+	return app.RestoreOptions{
+		From:       o.restoreFrom,
+		To:         to,
+		Pattern:    o.pattern,
+		DryRun:     o.dryRun,
+		Force:      o.force,
+		KeyFile:    o.keyFile,
+		Passphrase: os.Getenv(passphraseEnv),
+		Extension:  o.extension,
+	}
+}
+
+// verifyOptions builds the verification options from the verify command flags.
+func (o *Option) verifyOptions() app.VerifyOptions {
+	return app.VerifyOptions{
+		From:       o.verifyFrom,
+		KeyFile:    o.keyFile,
+		Passphrase: os.Getenv(passphraseEnv),
+		Extension:  o.extension,
+	}
+}
+
+// defaultSSHFile returns the path of the file `name`
+// under the `.ssh` folder of the current user.
+func defaultSSHFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", name)
 }
@@ -8,16 +8,124 @@ const usage = `Usage:
 	the filename. Finally it allows you to view logs entries based on the date and filename regex.
 	Specify the path towards the log file for filtering. If not specified it default to <file.log>.
 	Use CTRL+C to stop the program on windows machines. On Linux and MacOS you can use Kill command. 
+	Use -versions to keep previous revisions (<name>.bak.<utc-timestamp>) of each modified file.
+	Old revisions are pruned based on -max-revisions count (default 10) and -max-age duration.
+	Use -dedup to split files into content-defined chunks stored once under their SHA-256 hash into
+	the <.chunks> sub-folder, each backup file being a small manifest. Identical content is stored once.
+	Use -s3-endpoint and -s3-bucket to upload backup files into an S3-compatible bucket (AWS, MinIO...)
+	instead of the backup folder which then only holds zip archives. Credentials are read from the
+	AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables. Add -s3-upload-archive to
+	upload the final zip archive into the bucket under the -s3-archive-prefix (default archives/).
+	Use -sftp-addr, -sftp-user and -sftp-root to upload backup files to a remote host over SFTP. The
+	server is authenticated against -sftp-known-hosts and the client with the -sftp-key private key.
+	Changes are spooled into <backup>/.spool (or -sftp-spool) and sent in order once the link is up.
+	A change failing 5 times while connected is recorded into the spool dead.jsonl file and skipped.
+	Use -encrypt to store each backup file encrypted with AES-256-GCM. The key is loaded from -key-file
+	(32 bytes raw or hex, e.g. openssl rand -hex 32) or derived from the GOBACKUP_PASSPHRASE variable.
+	Archive entries and the checksums manifest copied into archives or remote storages stay encrypted.
+	Restore and verify decrypt them with the same -key-file or passphrase.
+	Use -compress gzip or -compress zstd with an optional -compress-level to compress each backup file.
+	Already compressed files (images, videos, archives...) are kept as is. Archive and restore
+	decompress them transparently.
+	Use -exclude with comma-separated gitignore-style patterns (*.swp,.git/,build/) to skip files and
+	folders, and -include to only back up matching files. A .gobackupignore file into any folder of the
+	source adds exclude rules for that folder, with !pattern to re-include. Ignored events are only
+	logged with -debug.
+	Use -debounce (e.g. 1s) to merge bursts of events of a file (create, modify, attributes...) so the
+	file is only copied once it got no event during that window and its size and modification time
+	stopped changing. It is disabled by default so each event is handled at once.
+	A file deleted then created elsewhere in the source within the -rename-window (default 1s) is seen as
+	renamed and its backup file is moved along with its revisions and checksum. Otherwise the new file is
+	copied and the old backup file gets the .stale suffix. Use -rename-window 0 to disable it.
+	Backup files of deleted files are kept by default. Use -on-delete trash to move them with their
+	revisions into <backup>/.trash/<deletion time>, deleted after -trash-retention (default 720h, 0
+	keeps them), or -on-delete mirror to delete them after -delete-grace (default 24h) unless the file
+	shows up again. Both rely on scheduled deletions.
+	On startup, files of the source missing from the backup or newer than their backup (or of another
+	size when stored as is) are copied. Add -sync-hash to compare their contents too or use -sync=false
+	to skip it. Backup files whose source file is gone are reported and -orphans flag renames them with
+	the .orphan suffix while -orphans remove deletes them (default keep).
+	Use -config to load the monitor settings from a YAML or JSON file. Flags override its values. Besides
+	the flags above, it sets the number of -workers, the backup files -extension (default .bak), the
+	-delete-prefix of deletion requests (default delete_) and their check -delete-interval (500ms).
+	Scheduled deletions are kept into <backup>/.schedule.json so they survive a restart and overdue ones
+	run on startup. Use schedule list to display them and schedule cancel with the -path of a file or the
+	-at time of a request to cancel them, even while the monitor is running.
+	Use config validate to check a config file. Each error is reported with its line number. TOML files
+	are not supported. The config file could list several jobs, each one with its own name, source and
+	backup folders and settings which default to the top level ones. Flags provided explicitly override
+	the settings of each job but its name and folders. All jobs share the workers and the log file
+	where each entry holds the job name. Use logs -job to only display the entries of a job.
+	Use restore to bring back files from the backup folder or from one of its archives into the
+	source folder or into an alternate -target folder. Select a single file, a folder or a glob with
+	-pattern. Existing files newer than their backup are kept unless -force. Try it with -dry-run.
+	The SHA-256 checksum, size and source modification time of each backup file content is recorded
+	into <backup>/.manifest.jsonl, also added to archives. Use verify to re-hash a backup folder or
+	archive and report missing, extra and corrupted files. It fails on any mismatch. With S3 or SFTP,
+	the manifest is also put into the remote storage when compacted at start and stop.
+	Archive entries keep their path into the backup folder along with the mode and modification time
+	of their source file. Each archive embeds .archive.json with the source folder, host, gobackup version
+	and commit and the checksum of each archived file so it describes itself. Revisions, trashed and
+	flagged files are left out.
+	Use -archive-format tar.gz or tar.zst to save tarballs instead of zip archives (7-Zip reads all of
+	them). Restore and verify accept any of these formats and read tarballs without extracting them.
+	Add -archive-stream for large backup folders to archive files while walking the folder instead of
+	listing it first. The description then omits the list of files whose checksums stay into the manifest.
+	Backup files keep the permissions (without setuid and setgid bits) and modification time of their
+	source file and, on Linux, its owner, group and extended attributes when allowed. These metadata are
+	recorded into the manifest too so restore applies them. Attributes changes only update them.
+	Add -safe-copy for files still being written like logs or databases: a file modified during its copy
+	is copied again up to -copy-retries times (default 3) while its backup file keeps the previous version.
+	With -wait-close, the copy waits up to that delay for writers to close the file (Linux only). Files
+	stored as is into a local backup folder are cloned (reflink) as a cheap point in time copy when the
+	filesystem supports it.
+	Use -archive-interval (e.g. 6h) or -archive-cron with a cron expression (e.g. "0 */6 * * *" or @daily)
+	to also save archives while running so a crash does not lose them all. On Linux and MacOS, send
+	SIGUSR1 (kill -USR1 <pid>) to save one at once. Use -keep-hourly, -keep-daily and -keep-weekly to only
+	keep the newest archive of each of the last hours, days and weeks (UTC) having one. Others are removed.
 	
 	gobackup [version | help ]
 	gobackup monitor -source <path-to-hot-folder> -backup <path-to-backup-folder>
-	gobackup logs -file <logfile-path> -date <yyyy-mm-dd> -regex <filename-regex>
+	gobackup monitor -source <path> -backup <path> -versions [-max-revisions <count>] [-max-age <duration>]
+	gobackup monitor -source <path> -backup <path> -dedup
+	gobackup monitor -source <path> -backup <path> -s3-endpoint <url> -s3-bucket <name> [-s3-prefix <prefix>] [-s3-upload-archive]
+	gobackup monitor -source <path> -backup <path> -sftp-addr <host:port> -sftp-user <user> -sftp-root <path> [-sftp-key <path>]
+	gobackup monitor -source <path> -backup <path> -encrypt [-key-file <path>]
+	gobackup monitor -source <path> -backup <path> -compress <gzip|zstd> [-compress-level <level>]
+	gobackup monitor -source <path> -backup <path> [-include <patterns>] [-exclude <patterns>] [-debug]
+	gobackup monitor -source <path> -backup <path> -debounce <duration>
+	gobackup monitor -source <path> -backup <path> -rename-window <duration>
+	gobackup monitor -source <path> -backup <path> [-sync=false] [-sync-hash] [-orphans <keep|flag|remove>]
+	gobackup monitor -source <path> -backup <path> -on-delete <keep|trash|mirror> [-trash-retention <duration>] [-delete-grace <duration>]
+	gobackup monitor -source <path> -backup <path> -safe-copy [-copy-retries <count>] [-wait-close <duration>]
+	gobackup monitor -source <path> -backup <path> [-archive-interval <duration>|-archive-cron <expr>] [-keep-hourly <count>] [-keep-daily <count>] [-keep-weekly <count>]
+	gobackup monitor -source <path> -backup <path> -archive-format <zip|tar.gz|tar.zst> [-archive-stream]
+	gobackup monitor -config <config-file> [-source <path>] [-backup <path>]
+	gobackup config validate -config <config-file>
+	gobackup schedule list -backup <path-to-backup-folder>
+	gobackup schedule cancel -backup <path-to-backup-folder> [-path <file-path>] [-at <yyyy-mm-ddThh:mm:ssZ>]
+	gobackup logs -file <logfile-path> -date <yyyy-mm-dd> -regex <filename-regex> [-job <name>]
+	gobackup restore -backup <backup-folder|archive> -source <path> [-target <path>] [-pattern <glob>] [-dry-run] [-force] [-key-file <path>]
+	gobackup verify -backup <backup-folder|archive> [-key-file <path>] [-extension <ext>]
 
     Examples:
 	
 	$ ./gobackup monitor -source "C:\demo\source" -backup "C:\demo\backup"
+	$ ./gobackup monitor -source "C:\demo\source" -backup "C:\demo\backup" -versions -max-revisions 5 -max-age 72h
+	$ ./gobackup monitor -source "/data/source" -backup "/data/spool" -sftp-addr backup.local:22 -sftp-user ops -sftp-root /srv/backup
+	$ ./gobackup monitor -source "/data/source" -backup "/data/backup" -exclude "*.swp,*.tmp,.git/,build/"
+	$ ./gobackup monitor -source "/data/source" -backup "/data/backup" -archive-cron "0 */6 * * *" -keep-daily 7 -keep-weekly 4
+	$ ./gobackup monitor -config "C:\demo\gobackup.yaml" -workers 4
+	$ ./gobackup config validate -config "C:\demo\gobackup.yaml"
+	$ ./gobackup verify -backup "C:\demo\backup"
+	$ ./gobackup schedule list -backup "C:\demo\backup"
+	$ ./gobackup schedule cancel -backup "C:\demo\backup" -at 2023-08-14T10:00:00Z
 	$ ./gobackup logs -date 2023-08-14 -regex *.bak
 	$ ./gobackup logs -file file.log -date 2023-08-14 -regex *.bak
+	$ ./gobackup logs -file file.log -date 2023-08-14 -regex *.bak -job docs
+	$ ./gobackup restore -backup "C:\demo\backup" -source "C:\demo\source" -pattern "docs/*.txt" -dry-run
+	$ ./gobackup restore -backup "C:\demo\backup.20230814.100000.1111.zip" -target "C:\demo\restored"
+	$ ./gobackup restore -backup "C:\demo\backup" -source "C:\demo\source" -key-file "C:\demo\backup.key"
 	
 	$ ./gobackup help
 	$ ./gobackup version
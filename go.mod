@@ -6,8 +6,15 @@ require github.com/jeamon/gorsn v0.0.0-20230930215504-34661629119d
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
-require github.com/stretchr/testify v1.8.4
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/pkg/sftp v1.13.7
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
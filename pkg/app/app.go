@@ -2,19 +2,40 @@ package app
 
 import (
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"io"
+	"io/fs"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jeamon/gobackup/pkg/compression"
+	"github.com/jeamon/gobackup/pkg/cron"
+	"github.com/jeamon/gobackup/pkg/debounce"
+	"github.com/jeamon/gobackup/pkg/encryption"
 	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/ignore"
 	"github.com/jeamon/gobackup/pkg/logger"
+	"github.com/jeamon/gobackup/pkg/metadata"
+	"github.com/jeamon/gobackup/pkg/rename"
 )
 
 const (
 	backupFileExtension = ".bak"
+	// prefix of file names requesting the deletion of a file.
+	deleteRequestPrefix = "delete_"
+	// default delay between two checks of scheduled deletions.
+	deleteCheckInterval = 500 * time.Millisecond
+	// folder under the backup folder holding uploads not yet sent.
+	spoolFolder = ".spool"
+	// suffix of backup files of renamed files which could not be moved.
+	staleSuffix = ".stale"
+	// default number of copies retried of a file changed meanwhile.
+	copyRetries = 3
+	// delay before the first retry of a copy. It grows with each retry.
+	copyRetryDelay = 200 * time.Millisecond
 )
 
 // Monitor is an interface defining the behavior of any object
@@ -24,28 +45,95 @@ type Monitor interface {
 	Stop() error
 }
 
+// Storage is an interface defining the behavior of any object capable
+// to save and load backup files. Each file is identified by its name
+// which is its slash-separated path relative to the backup root like
+// `docs/report.txt.bak`.
+type Storage interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	Stat(name string) (fs.FileInfo, error)
+	Delete(name string) error
+	List(prefix string) ([]string, error)
+}
+
+// renamer is implemented by storages able to rename a file in place.
+type renamer interface {
+	Rename(from, to string) error
+}
+
+// metadataSetter is implemented by storages keeping backup files as
+// plain files whose metadata could be set like mode and times.
+type metadataSetter interface {
+	SetMetadata(name string, md metadata.Metadata) error
+}
+
+// fileCopier is implemented by storages able to copy a local file
+// as is, ideally as a cheap point in time copy.
+type fileCopier interface {
+	CopyFile(name, src string) error
+}
+
+// walker is implemented by storages able to visit their files one
+// by one without listing them all at once.
+type walker interface {
+	Walk(prefix string, fn func(name string) error) error
+}
+
+// folderCreator is implemented by storages having a notion of folder.
+type folderCreator interface {
+	CreateFolder(name string) error
+}
+
+// archiveUploader is implemented by remote storages able to keep
+// a copy of the archives produced from the backup folder.
+type archiveUploader interface {
+	UploadArchive(filename string, r io.Reader) error
+}
+
+// garbageCollector is implemented by storages which need to reclaim
+// space once files got deleted (e.g. shared chunks of dedup store).
+type garbageCollector interface {
+	GC() (int, error)
+}
+
 // App is the structue of an app instance.
 type App struct {
 	pid       int                  // process id for this App instance.
+	name      string               // name of the job. Empty when running alone.
 	srcFolder string               // absolute path of folder to monitor.
 	dstFolder string               // backup folder absolute path.
 	notifier  Monitor              // concrete object of Monitor contract.
+	storage   Storage              // concrete object of Storage contract.
 	stop      chan struct{}        // helps goroutines to stop on exit signal.
+	stopOnce  *sync.Once           // ensures the stop channel is closed once.
 	jobs      events.Queue         // queue to store instant tasks to handle.
 	store     map[string]time.Time // store infos for scheduled deletion action.
-	wg        *sync.WaitGroup      // helps ensure all goroutines are stopped.
-	mutex     *sync.RWMutex        // mutex to synchronize operations on tasks store.
-	log       logger.Logger        // app level json-based logger.
+	// checksum of the journal of scheduled deletions when last written or read.
+	scheduleSum [sha256.Size]byte
+	wg          *sync.WaitGroup         // helps ensure all goroutines are stopped.
+	mutex       *sync.RWMutex           // mutex to synchronize operations on tasks store.
+	log         logger.Logger           // app level json-based logger.
+	opts        Options                 // optional behaviors like versioning.
+	cipher      *encryption.Cipher      // encrypts backup files content if set.
+	compress    *compression.Compressor // compresses backup files content if set.
+	rules       *ignore.Rules           // excludes files and folders from the backup if set.
+	manifest    *manifest               // checksums of backup files if set.
+	archiveCron *cron.Schedule          // times to make archives while running if set.
+	version     string                  // git tag of the program build recorded into archives.
+	commit      string                  // git commit of the program build recorded into archives.
 }
 
 // New configures a new App instance.
-func New(queueSize int, pid int, src, dst string, monitor Monitor, logger logger.Logger) *App {
+func New(queueSize int, pid int, src, dst string, monitor Monitor, storage Storage, logger logger.Logger) *App {
 	return &App{
 		pid:       pid,
 		srcFolder: src,
 		dstFolder: dst,
 		notifier:  monitor,
+		storage:   storage,
 		stop:      make(chan struct{}, 1),
+		stopOnce:  &sync.Once{},
 		jobs:      make(events.Queue, queueSize),
 		store:     make(map[string]time.Time),
 		wg:        &sync.WaitGroup{},
@@ -61,20 +149,84 @@ func (app *App) sigHandler(sigChan chan os.Signal) {
 
 	<-sigChan
 	signal.Stop(sigChan)
-	close(app.stop)
+	app.Stop()
 }
 
 // monitorFiles calls the monitoring routine of the App instance watcher in
-// order to start gathering events of each watched files and errors.
+// order to start gathering events of each watched files and errors. Events
+// go through the enabled stages before reaching the jobs queue: renames get
+// paired, including the ones of files existing before, then bursts of
+// events get merged. All pending events are queued
+// before it returns.
 func (app *App) monitorFiles(ctx context.Context) error {
-	return app.notifier.Start(ctx, app.stop, app.jobs)
+	var stages []stage
+	if app.opts.RenameWindow > 0 {
+		detector := rename.New(app.opts.RenameWindow)
+		if err := detector.Seed(app.srcFolder); err != nil {
+			app.log.Error("failed: seed renames detector", string(events.RENAME), app.srcFolder, err)
+		}
+		stages = append(stages, detector.Run)
+	}
+	if app.opts.Debounce > 0 {
+		stages = append(stages, debounce.New(app.opts.Debounce).Run)
+	}
+	changes, wait := app.pipeline(stages)
+	err := app.notifier.Start(ctx, app.stop, changes)
+	wait()
+	return err
+}
+
+// stage reads changes from `in` until it is closed and writes
+// them into `out` once processed.
+type stage func(in <-chan *events.Change, out chan<- *events.Change)
+
+// pipeline chains the `stages` in front of the jobs queue. It returns the
+// queue to write changes into and a function which closes it then waits
+// for all stages to forward their pending changes.
+func (app *App) pipeline(stages []stage) (events.Queue, func()) {
+	if len(stages) == 0 {
+		return app.jobs, func() {}
+	}
+	in := make(events.Queue, cap(app.jobs))
+	src := in
+	var done chan struct{}
+	for i, run := range stages {
+		dst := app.jobs
+		if i < len(stages)-1 {
+			dst = make(events.Queue, cap(app.jobs))
+		}
+		done = make(chan struct{})
+		go func(run stage, src, dst events.Queue, done chan struct{}, last bool) {
+			run(src, dst)
+			if !last {
+				close(dst)
+			}
+			close(done)
+		}(run, src, dst, done, i == len(stages)-1)
+		src = dst
+	}
+	return in, func() {
+		close(in)
+		<-done
+	}
 }
 
 // Stop stops the app instance by closing
 // the stop channel which all goroutines
-// and workers listen on to exit.
+// and workers listen on to exit. It is
+// safe to call it several times.
 func (app *App) Stop() {
-	close(app.stop)
+	app.stopOnce.Do(func() { close(app.stop) })
+}
+
+// closeStorage releases the storage resources when it holds any
+// like the connection and the uploader of a remote storage.
+func (app *App) closeStorage() {
+	if c, ok := app.storage.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			app.log.Error("failed: close storage", SAVE, app.dstFolder, err)
+		}
+	}
 }
 
 // CloseQueue close the channel of events.
@@ -85,20 +237,5 @@ func (app *App) CloseQueue() {
 // start prepares and performs all required routines needed
 // to watch and monitor files from source folder.
 func (app *App) start(maxWorkers int) (int, error) {
-	ctx := context.Background()
-	sigChan := make(chan os.Signal, 1)
-	go app.sigHandler(sigChan)
-	app.startDeleteWorker()
-	app.startBackupWorkers(maxWorkers)
-	err := app.monitorFiles(ctx)
-	if err != nil {
-		return 1, fmt.Errorf("failed to start files monitor: %v", err)
-	}
-	app.CloseQueue()
-	app.wg.Wait()
-	err = app.SaveAsZipFile(time.Now().UTC())
-	if err != nil {
-		return 1, err
-	}
-	return 0, nil
+	return run([]*App{app}, maxWorkers)
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/jeamon/gobackup/pkg/events"
 	"github.com/jeamon/gobackup/pkg/notifier"
+	"github.com/jeamon/gobackup/pkg/storage"
 	"github.com/jeamon/gobackup/pkg/testhelpers"
 	"github.com/jeamon/gorsn"
 	"github.com/stretchr/testify/assert"
@@ -23,17 +24,18 @@ import (
 func TestNew(t *testing.T) {
 	w, err := notifier.New("./", nil)
 	require.NoError(t, err)
-	app := New(2, 0, "./", "dst", w, testhelpers.NewTestLogger(t, io.Discard))
+	app := New(2, 0, "./", "dst", w, storage.NewLocal("dst"), testhelpers.NewTestLogger(t, io.Discard))
 	assert.Equal(t, 0, app.pid)
 	assert.Equal(t, "./", app.srcFolder)
 	assert.Equal(t, "dst", app.dstFolder)
 	assert.Equal(t, w, app.notifier)
+	assert.Equal(t, storage.NewLocal("dst"), app.storage)
 	assert.Equal(t, 2, cap(app.jobs))
 }
 
 func TestSigHandler(t *testing.T) {
 	sigChan := make(chan os.Signal, 1)
-	app := New(1, 0, "", "", nil, nil)
+	app := New(1, 0, "", "", nil, nil, nil)
 	go func() {
 		time.Sleep(1 * time.Millisecond)
 		sigChan <- syscall.SIGINT
@@ -74,7 +76,7 @@ func TestStart_Success(t *testing.T) {
 
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, src, dst, watcher, logger)
+	app := New(1, 0, src, dst, watcher, storage.NewLocal(dst), logger)
 	go func() {
 		time.Sleep(1 * time.Second)
 		app.Stop()
@@ -120,12 +122,40 @@ func TestStart_Fail(t *testing.T) {
 	require.NoError(t, err)
 	file.Close()
 
-	dst, err := os.MkdirTemp("", "backup")
-	require.NoError(t, err)
-	defer os.RemoveAll(dst)
-
-	app := New(1, 0, src, dst, watcher, nil)
+	// the backup folder state is saved next to it after the failure.
+	dst := t.TempDir()
+	app := New(1, 0, src, dst, watcher, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
 	code, err := app.start(1)
 	assert.EqualError(t, err, fmt.Sprintf("failed to start files monitor: %v", gorsn.ErrScanIsNotReady))
 	assert.Equal(t, 1, code)
 }
+
+func TestMonitorFiles_Debounce(t *testing.T) {
+	src := t.TempDir()
+	file := filepath.Join(src, "file.txt")
+	require.NoError(t, os.WriteFile(file, []byte("content"), 0o644))
+	watcher := &testhelpers.MockMonitor{
+		StartFunc: func(_ context.Context, quit <-chan struct{}, jobs events.Queue) error {
+			jobs <- &events.Change{Path: file, Ops: events.CREATE}
+			jobs <- &events.Change{Path: file, Ops: events.MODIFY}
+			jobs <- &events.Change{Path: file, Ops: events.MODIFY}
+			<-quit
+			return nil
+		},
+	}
+
+	app := New(10, 0, src, t.TempDir(), watcher, nil, nil)
+	app.opts.Debounce = 50 * time.Millisecond
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		app.Stop()
+	}()
+	require.NoError(t, app.monitorFiles(context.Background()))
+	app.CloseQueue()
+
+	var ops []events.Event
+	for ce := range app.jobs {
+		ops = append(ops, ce.Ops)
+	}
+	assert.Equal(t, []events.Event{events.CREATE, events.MODIFY}, ops)
+}
@@ -1,82 +1,322 @@
 package app
 
 import (
-	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"time"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/compression"
 )
 
 // defines ops name for saving backup folder.
 const SAVE string = "SAVE"
 
+// zipTimeLayout is the layout of the datetime part of archives ID.
+const zipTimeLayout = "20060102.150405"
+
+// archiveInfoFile describes the content of an archive and where it comes from.
+const archiveInfoFile = ".archive.json"
+
+// ArchiveInfo makes an archive self-describing. It is added last into
+// each archive with the checksum of each archived file content, except
+// in streaming mode where the manifest holds the checksums.
+type ArchiveInfo struct {
+	Source  string         `json:"source"` // absolute path of the monitored folder.
+	Host    string         `json:"host"`
+	Version string         `json:"version"` // git tag of the program build.
+	Commit  string         `json:"commit"`  // git commit of the program build.
+	Created time.Time      `json:"created"`
+	Files   []ArchivedFile `json:"files,omitempty"`
+}
+
+// ArchivedFile describes a file of an archive. Its mode and modification
+// time are the ones of the source file when recorded into the manifest.
+type ArchivedFile struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	SHA256  string      `json:"sha256"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
 // getZipID builds the suffix based on datetime provided and the app process id.
-// This value is used to build a unique filename for zip archive of the backup folder.
+// This value is used to build a unique filename for archives of the backup folder.
 func (app *App) getZipID(now time.Time) string {
-	return fmt.Sprintf("%d%02d%02d.%02d%02d%02d.%d", now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), app.pid)
+	return fmt.Sprintf("%s.%d", now.Format(zipTimeLayout), app.pid)
 }
 
-// save creates a zip archive of backup folder. It returns the statistics like
-// number of successful files added and the number of failures along with the
-// details (message and path and error if any) to insert a log entry.
+// save creates an archive of backup folder content loaded from the storage
+// in the format of the options (zip by default). Files from sub-folders are
+// stored under their path relative to the backup folder with their mode and
+// modification time along with the checksums manifest and the archive
+// description. Only backup files are archived. It returns the
+// statistics like number of successful files added and the number of
+// failures along with the details (message and path and error if any)
+// to insert a log entry.
 func (app *App) save(zipID string) (success, fails int, msg, path string, err error) {
-	zipFilepath := fmt.Sprintf("%s.%s.zip", app.dstFolder, zipID)
-	zfile, err := os.Create(zipFilepath)
+	format := app.opts.archiveFormat()
+	zfile, zipFilepath, err := app.createArchiveFile(zipID, archive.Extension(format))
 	if err != nil {
-		msg = "failed: create zip file"
+		msg = "failed: create archive file"
 		path = zipFilepath
 		return
 	}
-	defer zfile.Close()
 
-	zw := zip.NewWriter(zfile)
-	defer zw.Close()
+	aw, err := archive.NewWriter(format, zfile, filepath.Dir(zipFilepath))
+	if err != nil {
+		zfile.Close()
+		os.Remove(zipFilepath)
+		msg = "failed: create archive file"
+		path = zipFilepath
+		return
+	}
 
-	files, err := os.ReadDir(app.dstFolder)
+	info := app.newArchiveInfo()
+	ext := app.opts.extension()
+	err = app.walkBackupFiles(func(name string) error {
+		// revisions, trashed, flagged and internal files stay out.
+		if _, ok := entryName(name, ext); !ok || name == manifestFile {
+			return nil
+		}
+		file, zerr := app.addToArchive(aw, name)
+		if zerr != nil {
+			fails++
+			return nil
+		}
+		if !app.opts.ArchiveStream {
+			info.Files = append(info.Files, file)
+		}
+		success++
+		return nil
+	})
 	if err != nil {
+		aw.Close()
+		zfile.Close()
+		os.Remove(zipFilepath)
 		msg = "failed: load backup files"
 		path = app.dstFolder
 		return
 	}
+	if zerr := app.addManifestToArchive(aw); zerr != nil {
+		fails++
+	}
+	if zerr := addInfoToArchive(aw, info); zerr != nil {
+		fails++
+	}
 
-	err = os.Chdir(app.dstFolder)
+	// the archive is only complete once written out so a partial one is dropped.
+	err = aw.Close()
+	if cerr := zfile.Close(); err == nil {
+		err = cerr
+	}
+	path = zipFilepath
 	if err != nil {
-		msg = "failed: change working directory to backup folder"
-		path = app.dstFolder
+		os.Remove(zipFilepath)
+		msg = "failed: write archive file"
 		return
 	}
+	msg = "success: save backup folder state"
+	return success, fails, msg, path, nil
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		f, zerr := os.Open(file.Name())
-		if zerr != nil {
-			fails++
-			continue
+// createArchiveFile creates the archive file of ID `zipID` with extension `ext`
+// next to the backup folder. An archive made earlier with the same ID is never
+// replaced: the ID then gets a `-<n>` suffix starting at 2. It returns the file
+// and its path.
+func (app *App) createArchiveFile(zipID, ext string) (*os.File, string, error) {
+	path := fmt.Sprintf("%s.%s%s", app.dstFolder, zipID, ext)
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, path, err
 		}
+		path = fmt.Sprintf("%s.%s-%d%s", app.dstFolder, zipID, n, ext)
+	}
+}
 
-		w, zerr := zw.Create(file.Name())
-		if zerr != nil {
-			fails++
-			continue
+// walkBackupFiles calls `fn` with the name of each file of the storage.
+// In streaming mode, files are visited one by one without listing them
+// all at once when the storage allows it.
+func (app *App) walkBackupFiles(fn func(name string) error) error {
+	if w, ok := app.storage.(walker); ok && app.opts.ArchiveStream {
+		return w.Walk("", fn)
+	}
+	names, err := app.storage.List("")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = fn(name); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if _, zerr := io.Copy(w, f); zerr != nil {
-			fails++
-			continue
-		}
+// newArchiveInfo provides the description of an archive of the backup
+// folder made now. The host is left empty if it cannot be found.
+func (app *App) newArchiveInfo() *ArchiveInfo {
+	host, _ := os.Hostname()
+	return &ArchiveInfo{
+		Source:  app.srcFolder,
+		Host:    host,
+		Version: app.version,
+		Commit:  app.commit,
+		Created: time.Now().UTC(),
+	}
+}
 
-		f.Close()
-		success++
+// addToArchive writes the content of the backup file `name` loaded
+// from the storage into the archive under the same name. The
+// content is decompressed since the archive compresses it anyway,
+// unless it is encrypted and then copied as is. It returns the
+// description of the archived file.
+func (app *App) addToArchive(aw archive.Writer, name string) (ArchivedFile, error) {
+	file := ArchivedFile{Name: name}
+	file.Mode, file.ModTime = app.archivedMetadata(name)
+
+	f, err := app.storage.Get(name)
+	if err != nil {
+		return file, err
 	}
-	msg = "success: save backup folder state"
-	path = zipFilepath
-	return success, fails, msg, path, err
+	defer f.Close()
+	enc := app.manifest.encoding(name)
+	size, err := app.archivedSize(f, enc)
+	if err != nil {
+		return file, err
+	}
+	r, err := app.archivedContent(f, enc)
+	if err != nil {
+		return file, err
+	}
+	defer r.Close()
+
+	hdr := archive.Header{Name: name, Mode: file.Mode, ModTime: file.ModTime, Size: size}
+	sum := newDigest()
+	if err = aw.Add(hdr, io.TeeReader(r, sum)); err != nil {
+		return file, err
+	}
+	file.Size, file.SHA256 = sum.size, sum.String()
+	return file, nil
+}
+
+// archiveDecodes reports whether the content of a backup file stored with the
+// encoding `enc` is decompressed when archived, which it is unless encrypted.
+// Without recorded encoding, it is guessed from the content unless a cipher
+// is set since encrypted content is kept as is.
+func (app *App) archiveDecodes(enc *Encoding) bool {
+	if enc == nil {
+		return app.cipher == nil
+	}
+	return enc.Compressed && !enc.Encrypted
+}
+
+// archivedContent returns the content of the backup file read from `r`
+// with the encoding `enc` as it is archived.
+func (app *App) archivedContent(r io.Reader, enc *Encoding) (io.ReadCloser, error) {
+	switch {
+	case !app.archiveDecodes(enc):
+		return io.NopCloser(r), nil
+	case enc == nil:
+		return compression.Open(r)
+	}
+	return compression.Decompress(r)
+}
+
+// localFile is implemented by backup files read from a local folder.
+type localFile interface {
+	io.ReadSeeker
+	Stat() (fs.FileInfo, error)
+}
+
+// archivedSize returns the size of the content of the backup file read from
+// `f` with the encoding `enc` as it is archived so tarballs do not copy it
+// aside to know it. Local files kept as is have their own size while the
+// decompressed ones are decoded once to count it before being rewound. It
+// is -1 when not needed or unknown.
+func (app *App) archivedSize(f io.Reader, enc *Encoding) (int64, error) {
+	lf, ok := f.(localFile)
+	if !ok || !archive.IsTar(app.opts.archiveFormat()) {
+		return -1, nil
+	}
+	fi, err := lf.Stat()
+	if err != nil {
+		return -1, err
+	}
+	if !app.archiveDecodes(enc) {
+		return fi.Size(), nil
+	}
+	r, err := app.archivedContent(lf, enc)
+	if err != nil {
+		return -1, err
+	}
+	size, err := io.Copy(io.Discard, r)
+	r.Close()
+	if err != nil {
+		return -1, err
+	}
+	_, err = lf.Seek(0, io.SeekStart)
+	return size, err
 }
 
-// SaveAsZipFile orchestrates the creation of a zip archive of backup folder.
+// archivedMetadata returns the mode and modification time to archive the
+// backup file `name` with. These are the ones of its source file recorded
+// into the manifest if any, otherwise the ones of the backup file itself.
+func (app *App) archivedMetadata(name string) (fs.FileMode, time.Time) {
+	if e, ok := app.manifest.get(name); ok && e.Mode != 0 {
+		return e.Mode, e.ModTime
+	}
+	if fi, err := app.storage.Stat(name); err == nil {
+		return fi.Mode().Perm(), fi.ModTime()
+	}
+	return 0o644, time.Now()
+}
+
+// addInfoToArchive writes the description `info` into the archive.
+func addInfoToArchive(aw archive.Writer, info *ArchiveInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := archive.Header{Name: archiveInfoFile, Mode: 0o644, ModTime: info.Created, Size: int64(len(data))}
+	return aw.Add(hdr, bytes.NewReader(data))
+}
+
+// addManifestToArchive writes the checksums manifest of the backup folder
+// into the archive so the archive could be verified. It is encrypted like
+// the backup files when a cipher is set. Nothing is written without manifest.
+func (app *App) addManifestToArchive(aw archive.Writer) error {
+	f, err := os.Open(filepath.Join(app.dstFolder, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// entries appended meanwhile are left out.
+	hdr := archive.Header{Name: manifestFile, Mode: 0o644, ModTime: fi.ModTime(), Size: fi.Size()}
+	var r io.Reader = f
+	if app.cipher != nil {
+		hdr.Size, r = -1, app.cipher.Encrypt(io.LimitReader(f, fi.Size()))
+	}
+	return aw.Add(hdr, r)
+}
+
+// SaveAsZipFile orchestrates the creation of an archive of backup folder
+// in the format of the options. Zip archives are made by default.
 func (app *App) SaveAsZipFile(t time.Time) error {
 	zipID := app.getZipID(t)
 	success, fails, msg, path, err := app.save(zipID)
@@ -85,5 +325,31 @@ func (app *App) SaveAsZipFile(t time.Time) error {
 		return err
 	}
 	app.log.Info(fmt.Sprintf("%s [success/fails: %d/%d]", msg, success, fails), SAVE, path)
+	if app.opts.UploadArchive {
+		return app.UploadArchive(path)
+	}
+	return nil
+}
+
+// UploadArchive sends a copy of the archive located at `path`
+// to the storage when it is able to keep archives.
+func (app *App) UploadArchive(path string) error {
+	uploader, ok := app.storage.(archiveUploader)
+	if !ok {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		app.log.Error("failed: upload archive file", SAVE, path, err)
+		return err
+	}
+	defer f.Close()
+
+	if err = uploader.UploadArchive(path, f); err != nil {
+		app.log.Error("failed: upload archive file", SAVE, path, err)
+		return err
+	}
+	app.log.Info("success: upload archive file", SAVE, path)
 	return nil
 }
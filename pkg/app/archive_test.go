@@ -1,14 +1,19 @@
 package app
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/storage"
 	"github.com/jeamon/gobackup/pkg/testhelpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,31 +35,58 @@ func TestSave(t *testing.T) {
 	dst, err := os.MkdirTemp(folder, "backup")
 	require.NoError(t, err)
 
-	file, err := os.CreateTemp(dst, "file")
+	file, err := os.CreateTemp(dst, "file*.bak")
 	require.NoError(t, err)
 	file.Close()
 
-	app := &App{dstFolder: dst}
+	require.NoError(t, os.MkdirAll(filepath.Join(dst, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "sub", "nested.bak"), []byte("nested"), 0o644))
+
+	app := &App{dstFolder: dst, storage: storage.NewLocal(dst)}
 	id := "20230814.100000.1111"
 	t.Run("success", func(t *testing.T) {
 		success, fails, msg, path, err := app.save(id)
 		require.NoError(t, err)
-		assert.Equal(t, 1, success)
+		assert.Equal(t, 2, success)
 		assert.Equal(t, 0, fails)
 		assert.Equal(t, "success: save backup folder state", msg)
 		zipFilename := fmt.Sprintf("%s.%s.zip", filepath.Base(dst), id)
 		require.Equal(t, filepath.Join(folder, zipFilename), path)
 		assert.FileExists(t, path)
+
+		zr, err := zip.OpenReader(path)
+		require.NoError(t, err)
+		defer zr.Close()
+		names := []string{}
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		assert.ElementsMatch(t, []string{filepath.Base(file.Name()), "sub/nested.bak", archiveInfoFile}, names)
+	})
+
+	t.Run("same id", func(t *testing.T) {
+		first := filepath.Join(folder, fmt.Sprintf("%s.%s.zip", filepath.Base(dst), id))
+		before, err := os.ReadFile(first)
+		require.NoError(t, err)
+		_, _, _, path, err := app.save(id)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(folder, fmt.Sprintf("%s.%s-2.zip", filepath.Base(dst), id)), path)
+		after, err := os.ReadFile(first)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
 	})
 
 	t.Run("fail", func(t *testing.T) {
 		app.dstFolder = filepath.Join(app.dstFolder, "noexist.folderpath")
+		app.storage = storage.NewLocal(app.dstFolder)
 		success, fails, msg, path, err := app.save(id)
 		require.Error(t, err)
 		assert.Equal(t, 0, success)
 		assert.Equal(t, 0, fails)
 		assert.Equal(t, "failed: load backup files", msg)
 		require.Equal(t, app.dstFolder, path)
+		// no partial archive is left behind.
+		assert.NoFileExists(t, fmt.Sprintf("%s.%s.zip", app.dstFolder, id))
 	})
 }
 
@@ -66,14 +98,14 @@ func TestSaveAsZipFile(t *testing.T) {
 	dst, err := os.MkdirTemp(folder, "backup")
 	require.NoError(t, err)
 
-	file, err := os.CreateTemp(dst, "file")
+	file, err := os.CreateTemp(dst, "file*.bak")
 	require.NoError(t, err)
 	file.Close()
 
 	dt, err := time.Parse(time.RFC3339, "2023-08-14T10:00:00Z")
 	require.NoError(t, err)
 	out := bytes.NewBuffer(nil)
-	app := &App{pid: 1111, dstFolder: dst, log: testhelpers.NewTestLogger(t, out)}
+	app := &App{pid: 1111, dstFolder: dst, storage: storage.NewLocal(dst), log: testhelpers.NewTestLogger(t, out)}
 	expectedZipFilename := fmt.Sprintf("%s.%s.zip", filepath.Base(dst), "20230814.100000.1111")
 	t.Run("success", func(t *testing.T) {
 		app.SaveAsZipFile(dt)
@@ -101,6 +133,7 @@ func TestSaveAsZipFile(t *testing.T) {
 	t.Run("fail", func(t *testing.T) {
 		out.Reset()
 		app.dstFolder = filepath.Join(app.dstFolder, "noexist.folderpath")
+		app.storage = storage.NewLocal(app.dstFolder)
 		app.SaveAsZipFile(dt)
 		var data map[string]interface{}
 		err := json.Unmarshal(out.Bytes(), &data)
@@ -123,3 +156,253 @@ func TestSaveAsZipFile(t *testing.T) {
 		assert.Equal(t, app.dstFolder, path)
 	})
 }
+
+func TestSaveAsZipFile_S3Storage(t *testing.T) {
+	server := testhelpers.NewS3Server("bucket")
+	defer server.Close()
+	store, err := storage.NewS3(storage.S3Options{
+		Endpoint: server.URL, Bucket: "bucket", AccessKey: "key", SecretKey: "secret",
+		Prefix: "backup/", ArchivePrefix: "archives/",
+	})
+	require.NoError(t, err)
+
+	src, err := os.MkdirTemp("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	folder, err := os.MkdirTemp("", "folder")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.Mkdir(dst, 0o755))
+
+	app := New(1, 1111, src, dst, nil, store, testhelpers.NewTestLogger(t, io.Discard))
+	app.opts.UploadArchive = true
+	spath := filepath.Join(src, "report.txt")
+	require.NoError(t, os.WriteFile(spath, []byte("report"), 0o644))
+	app.ModifyEventHandler(spath)
+	data, ok := server.Object("backup/report.txt.bak")
+	require.Equal(t, true, ok)
+	assert.Equal(t, "report", string(data))
+
+	dt, err := time.Parse(time.RFC3339, "2023-08-14T10:00:00Z")
+	require.NoError(t, err)
+	require.NoError(t, app.SaveAsZipFile(dt))
+	zipFilename := fmt.Sprintf("%s.%s.zip", filepath.Base(dst), "20230814.100000.1111")
+	assert.FileExists(t, filepath.Join(folder, zipFilename))
+	_, ok = server.Object("archives/" + zipFilename)
+	assert.Equal(t, true, ok)
+
+	request := filepath.Join(src, "delete_report.txt")
+	require.NoError(t, os.WriteFile(request, nil, 0o644))
+	app.DeleteRequestHandler(request)
+	assert.Equal(t, []string{"archives/" + zipFilename}, server.Keys())
+}
+
+func TestSave_Metadata(t *testing.T) {
+	folder := t.TempDir()
+	src := filepath.Join(folder, "source")
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0o755))
+	require.NoError(t, os.MkdirAll(dst, 0o755))
+	checksums, err := openManifest(dst, nil, nil)
+	require.NoError(t, err)
+	app := &App{pid: 1111, srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), manifest: checksums, version: "v1.2.0", commit: "abc123"}
+
+	spath := filepath.Join(src, "bin", "run.sh")
+	require.NoError(t, os.WriteFile(spath, []byte("#!/bin/sh"), 0o644))
+	require.NoError(t, os.Chmod(spath, 0o750))
+	mtime := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(spath, mtime, mtime))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+	// backup file without manifest entry like one made by a previous version.
+	other := filepath.Join(dst, "notes.txt.bak")
+	require.NoError(t, os.WriteFile(other, []byte("notes"), 0o600))
+	otime := time.Date(2023, 8, 14, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(other, otime, otime))
+	require.NoError(t, app.manifest.close())
+	// revisions, trashed, flagged and internal files are not archived.
+	for _, name := range []string{"notes.txt.bak.20230814T090000.000000000Z", ".trash/20230814T090000.000000000Z/old.txt.bak", "gone.txt.bak.orphan", scheduleFile} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dst, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, name), []byte("skipped"), 0o644))
+	}
+
+	_, fails, _, path, err := app.save("20230814.100000.1111")
+	require.NoError(t, err)
+	assert.Equal(t, 0, fails)
+	zr, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer zr.Close()
+
+	headers := map[string]*zip.File{}
+	for _, zf := range zr.File {
+		headers[zf.Name] = zf
+	}
+	require.Contains(t, headers, "bin/run.sh.bak")
+	assert.Equal(t, os.FileMode(0o750), headers["bin/run.sh.bak"].Mode())
+	assert.True(t, mtime.Equal(headers["bin/run.sh.bak"].Modified))
+	require.Contains(t, headers, "notes.txt.bak")
+	assert.Equal(t, os.FileMode(0o600), headers["notes.txt.bak"].Mode())
+	assert.True(t, otime.Equal(headers["notes.txt.bak"].Modified))
+	assert.Len(t, headers, 4)
+
+	require.Contains(t, headers, archiveInfoFile)
+	r, err := headers[archiveInfoFile].Open()
+	require.NoError(t, err)
+	var info ArchiveInfo
+	require.NoError(t, json.NewDecoder(r).Decode(&info))
+	r.Close()
+	host, _ := os.Hostname()
+	assert.Equal(t, src, info.Source)
+	assert.Equal(t, host, info.Host)
+	assert.Equal(t, "v1.2.0", info.Version)
+	assert.Equal(t, "abc123", info.Commit)
+	require.Len(t, info.Files, 2)
+	files := map[string]ArchivedFile{}
+	for _, f := range info.Files {
+		files[f.Name] = f
+	}
+	run := files["bin/run.sh.bak"]
+	assert.Equal(t, int64(len("#!/bin/sh")), run.Size)
+	entry, ok := app.manifest.get("bin/run.sh.bak")
+	require.True(t, ok)
+	assert.Equal(t, entry.SHA256, run.SHA256)
+	assert.Equal(t, os.FileMode(0o750), run.Mode)
+	assert.True(t, mtime.Equal(run.ModTime))
+
+	// the headers are enough to restore modes and times without manifest.
+	require.NoError(t, os.Remove(filepath.Join(dst, manifestFile)))
+	_, _, _, path, err = app.save("20230814.110000.1111")
+	require.NoError(t, err)
+	target := filepath.Join(folder, "restored")
+	code, err := Restore(io.Discard, RestoreOptions{From: path, To: target})
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	fi, err := os.Stat(filepath.Join(target, "bin", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+	assert.True(t, mtime.Equal(fi.ModTime()))
+}
+
+func TestSave_Formats(t *testing.T) {
+	folder := t.TempDir()
+	src := filepath.Join(folder, "source")
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0o755))
+	require.NoError(t, os.MkdirAll(dst, 0o755))
+	compressor, err := newCompressor("zstd", 0)
+	require.NoError(t, err)
+	checksums, err := openManifest(dst, nil, nil)
+	require.NoError(t, err)
+	app := &App{pid: 1111, srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), compress: compressor, manifest: checksums}
+
+	spath := filepath.Join(src, "bin", "run.sh")
+	require.NoError(t, os.WriteFile(spath, []byte("#!/bin/sh"), 0o644))
+	require.NoError(t, os.Chmod(spath, 0o750))
+	mtime := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(spath, mtime, mtime))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "notes.txt"), []byte("notes"), 0o644))
+	require.NoError(t, app.UpdateBackupFileContent(filepath.Join(src, "notes.txt")))
+	require.NoError(t, app.manifest.close())
+
+	for _, format := range []string{archive.TarGzip, archive.TarZstd} {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s stream=%t", format, stream), func(t *testing.T) {
+				app.opts.ArchiveFormat = format
+				app.opts.ArchiveStream = stream
+				id := "20230814.100000.1111"
+				if stream {
+					id = "20230814.100001.1111"
+				}
+				success, fails, _, path, err := app.save(id)
+				require.NoError(t, err)
+				assert.Equal(t, 2, success)
+				assert.Equal(t, 0, fails)
+				assert.Equal(t, dst+"."+id+"."+format, path)
+
+				target := filepath.Join(t.TempDir(), "restored")
+				code, err := Restore(io.Discard, RestoreOptions{From: path, To: target})
+				require.NoError(t, err)
+				assert.Equal(t, 0, code)
+				data, err := os.ReadFile(filepath.Join(target, "bin", "run.sh"))
+				require.NoError(t, err)
+				assert.Equal(t, "#!/bin/sh", string(data))
+				fi, err := os.Stat(filepath.Join(target, "bin", "run.sh"))
+				require.NoError(t, err)
+				assert.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+				assert.True(t, mtime.Equal(fi.ModTime()))
+
+				// the description lists files unless streaming.
+				tr, err := archive.OpenTar(path)
+				require.NoError(t, err)
+				defer tr.Close()
+				r, err := tr.Open(archiveInfoFile)
+				require.NoError(t, err)
+				var info ArchiveInfo
+				require.NoError(t, json.NewDecoder(r).Decode(&info))
+				assert.Equal(t, src, info.Source)
+				if stream {
+					assert.Empty(t, info.Files)
+				} else {
+					assert.Len(t, info.Files, 2)
+				}
+				// temporary files of unknown sizes are removed.
+				matches, err := filepath.Glob(filepath.Join(folder, ".gobackup-tar-*"))
+				require.NoError(t, err)
+				assert.Empty(t, matches)
+			})
+		}
+	}
+}
+
+func TestArchivedSize(t *testing.T) {
+	dst := t.TempDir()
+	content := strings.Repeat("line of log\n", 100)
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "plain.bak"), []byte(content), 0o644))
+	compressor, err := newCompressor("zstd", 0)
+	require.NoError(t, err)
+	data, err := io.ReadAll(compressor.Compress(strings.NewReader(content)))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "packed.bak"), data, 0o644))
+	// a plain file which looks like compressed content is archived as is.
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "lookalike.bak"), data, 0o644))
+
+	app := &App{dstFolder: dst, storage: storage.NewLocal(dst)}
+	app.opts.ArchiveFormat = archive.TarZstd
+	tests := []struct {
+		name string
+		enc  *Encoding
+		size int
+	}{
+		{"plain.bak", &Encoding{}, len(content)},
+		{"packed.bak", &Encoding{Compressed: true}, len(content)},
+		{"packed.bak", nil, len(content)},
+		{"lookalike.bak", &Encoding{}, len(data)},
+	}
+	for _, tc := range tests {
+		f, err := app.storage.Get(tc.name)
+		require.NoError(t, err)
+		size, err := app.archivedSize(f, tc.enc)
+		require.NoError(t, err)
+		assert.Equal(t, int64(tc.size), size, tc.name)
+		// the file is read again from its start.
+		r, err := app.archivedContent(f, tc.enc)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		r.Close()
+		f.Close()
+		require.NoError(t, err)
+		assert.Len(t, got, tc.size, tc.name)
+	}
+
+	size, err := app.archivedSize(strings.NewReader(content), &Encoding{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), size)
+	app.opts.ArchiveFormat = archive.Zip
+	f, err := app.storage.Get("plain.bak")
+	require.NoError(t, err)
+	defer f.Close()
+	size, err = app.archivedSize(f, &Encoding{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), size)
+}
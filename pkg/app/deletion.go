@@ -0,0 +1,148 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// policies applied to the backup file of a file deleted from the source.
+const (
+	DeleteKeep   = "keep"   // keep the backup file forever.
+	DeleteTrash  = "trash"  // move it into the trashFolder until the retention expires.
+	DeleteMirror = "mirror" // delete it once the grace delay expires.
+)
+
+// trashFolder is the folder under the backup folder holding the backup
+// files of deleted files with the trash policy. Each deletion gets its own
+// timestamped folder mirroring the source tree so a file deleted again
+// does not replace the previous one.
+const trashFolder = ".trash"
+
+// checkOnDelete ensures `policy` is a known deletion policy.
+func checkOnDelete(policy string) error {
+	switch policy {
+	case "", DeleteKeep, DeleteTrash, DeleteMirror:
+		return nil
+	}
+	return fmt.Errorf("invalid deletion policy %q: expected keep, trash or mirror", policy)
+}
+
+// isTrashName reports whether the storage name `name` is inside the trashFolder.
+func isTrashName(name string) bool {
+	return name == trashFolder || strings.HasPrefix(name, trashFolder+"/")
+}
+
+// trashName returns the name into the trashFolder of the backup file `name`
+// of a file deleted at `at`.
+func trashName(name string, at time.Time) string {
+	return trashFolder + "/" + at.UTC().Format(revisionTimeLayout) + "/" + name
+}
+
+// backupFiles returns the name of the backup file `name` if it exists
+// followed by the names of its revisions.
+func (app *App) backupFiles(name string) ([]string, error) {
+	var names []string
+	if _, err := app.storage.Stat(name); err == nil {
+		names = append(names, name)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	revisions, err := app.revisionsOf(name)
+	if err != nil {
+		return nil, err
+	}
+	return append(names, revisions...), nil
+}
+
+// trashBackupFile moves the backup file of the deleted file `path` and its
+// revisions into the trashFolder under a name unique to this deletion. Only
+// these files are scheduled for deletion once the trash retention expires
+// if any. It returns the trashed file names.
+func (app *App) trashBackupFile(path string, now time.Time) ([]string, error) {
+	name := app.backupName(path)
+	names, err := app.backupFiles(name)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	tname := trashName(name, now)
+	for {
+		// deletions of a same file at a same time stay apart.
+		used, err := app.backupFiles(tname)
+		if err != nil {
+			return nil, err
+		}
+		if len(used) == 0 {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+		tname = trashName(name, now)
+	}
+	if names[0] == name {
+		if err = app.moveBackupFile(name, tname); err != nil {
+			return nil, err
+		}
+	}
+	if err = app.moveRevisions(name, tname); err != nil {
+		return nil, err
+	}
+	if names, err = app.backupFiles(tname); err != nil {
+		return nil, err
+	}
+	if app.opts.TrashRetention > 0 {
+		app.scheduleBackupDeletion(now.Add(app.opts.TrashRetention), names)
+	}
+	return names, nil
+}
+
+// mirrorDeletion schedules the deletion of the backup file of the deleted
+// file `path` and its revisions once the grace delay expires. It returns
+// the names of the files to be deleted.
+func (app *App) mirrorDeletion(path string, now time.Time) ([]string, error) {
+	names, err := app.backupFiles(app.backupName(path))
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	app.scheduleBackupDeletion(now.Add(app.opts.DeleteGrace), names)
+	return names, nil
+}
+
+// scheduleBackupDeletion schedules the deletion of the backup files `names` at `at`.
+func (app *App) scheduleBackupDeletion(at time.Time, names []string) {
+	locations := make([]string, 0, len(names))
+	for _, name := range names {
+		locations = append(locations, app.backupLocation(name))
+	}
+	app.ScheduleDeleteRequests(at, locations...)
+}
+
+// cancelMirrorDeletion drops the pending deletion of the backup file of
+// `path` and its revisions when the file shows up again into the source
+// during the grace delay. It reports whether any deletion was cancelled.
+func (app *App) cancelMirrorDeletion(path string) bool {
+	if app.opts.OnDelete != DeleteMirror {
+		return false
+	}
+	name := app.backupName(path)
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	defer app.lockSchedule()()
+	app.restoreSchedule()
+	cancelled := false
+	for location := range app.store {
+		sname, ok := app.storageName(location)
+		if !ok {
+			continue
+		}
+		if bname, _, ok := parseRevisionName(sname); sname == name || (ok && bname == name) {
+			delete(app.store, location)
+			cancelled = true
+		}
+	}
+	if cancelled {
+		app.persistSchedule()
+	}
+	return cancelled
+}
@@ -2,10 +2,17 @@ package app
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/compression"
+	"github.com/jeamon/gobackup/pkg/encryption"
+	"github.com/jeamon/gobackup/pkg/ignore"
 	"github.com/jeamon/gobackup/pkg/logger"
 	"github.com/jeamon/gobackup/pkg/notifier"
+	"github.com/jeamon/gobackup/pkg/storage"
 	"github.com/jeamon/gobackup/pkg/utils"
 	"github.com/jeamon/gobackup/pkg/viewer"
 	"github.com/jeamon/gorsn"
@@ -14,30 +21,189 @@ import (
 // Ensure that `*notifier.Notifier` always implements Monitor interface.
 var _ Monitor = (*notifier.Notifier)(nil)
 
+// Ensure that storages always implement Storage interface.
+var (
+	_ Storage = (*storage.Local)(nil)
+	_ Storage = (*storage.Dedup)(nil)
+	_ Storage = (*storage.S3)(nil)
+	_ Storage = (*storage.SFTP)(nil)
+)
+
+// newStorage provides the storage selected by the options. Files are
+// saved as plain copies into the backup folder `dst` by default. For
+// SFTP, pending uploads are spooled into `dst` unless set otherwise.
+func newStorage(dst string, opts Options) (Storage, error) {
+	switch {
+	case opts.S3 != nil && opts.SFTP != nil:
+		return nil, fmt.Errorf("s3 and sftp storages cannot be combined")
+	case (opts.S3 != nil || opts.SFTP != nil) && opts.Dedup:
+		return nil, fmt.Errorf("dedup store cannot be combined with remote storage")
+	case opts.S3 != nil:
+		return storage.NewS3(*opts.S3)
+	case opts.SFTP != nil:
+		sftpOpts := *opts.SFTP
+		if sftpOpts.SpoolFolder == "" {
+			sftpOpts.SpoolFolder = filepath.Join(dst, spoolFolder)
+		}
+		return storage.NewSFTP(sftpOpts)
+	case opts.Dedup:
+		return storage.NewDedup(dst), nil
+	default:
+		return storage.NewLocal(dst), nil
+	}
+}
+
+// closeStore releases the resources of the storage `store` if it holds any
+// like the connection and the uploader of a remote storage.
+func closeStore(store Storage) {
+	if c, ok := store.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// newCipher provides the cipher to encrypt or decrypt backup files with.
+// The key file takes precedence over the passphrase. It returns nil when
+// none is set.
+func newCipher(keyFile, passphrase string) (*encryption.Cipher, error) {
+	switch {
+	case keyFile != "":
+		return encryption.LoadKeyFile(keyFile)
+	case passphrase != "":
+		return encryption.NewPassphrase(passphrase)
+	default:
+		return nil, nil
+	}
+}
+
+// newCompressor provides the compressor of backup files with the
+// `algorithm` at `level`. It returns nil when no algorithm is set.
+func newCompressor(algorithm string, level int) (*compression.Compressor, error) {
+	if algorithm == "" {
+		return nil, nil
+	}
+	return compression.New(algorithm, level)
+}
+
 // Backup finalizes the initialization of an App instance and
 // orchestrates required routines to monitor and handle changes.
-func Backup(maxWorkers int, logfile, src, dst, commit, tag string) (int, error) {
-	if !utils.IsDirPath(src) || !utils.IsDirPath(dst) {
-		return 1, fmt.Errorf("invalid source or backup folder paths. run --help for usage")
+func Backup(maxWorkers int, logfile, src, dst, commit, tag string, opts Options) (int, error) {
+	return BackupJobs(maxWorkers, logfile, commit, tag, false, []Job{{Source: src, Backup: dst, Options: opts}})
+}
+
+// BackupJobs runs all jobs into the same process. They share `maxWorkers`
+// backup workers and the log file where each entry holds the job name.
+// Debug level entries like ignored events are only logged if `debug`.
+func BackupJobs(maxWorkers int, logfile, commit, tag string, debug bool, jobs []Job) (int, error) {
+	if err := validateJobs(jobs); err != nil {
+		return 1, fmt.Errorf("backup: %v", err)
+	}
+	for _, job := range jobs {
+		if !utils.IsDirPath(job.Source) || !utils.IsDirPath(job.Backup) {
+			return 1, fmt.Errorf("invalid source or backup folder paths. run --help for usage")
+		}
 	}
 
-	file, logger, err := logger.New(logfile, commit, tag, os.Getpid())
+	file, logger, err := logger.New(logfile, commit, tag, os.Getpid(), debug)
 	if err != nil {
 		return 1, fmt.Errorf("failed to setup logger: %v", err)
 	}
 	defer file.Close()
-	var opts gorsn.Options
-	notifier, err := notifier.New(src, &opts)
+
+	apps := make([]*App, 0, len(jobs))
+	for _, job := range jobs {
+		app, err := newJobApp(maxWorkers, job, logger)
+		if err != nil {
+			// the jobs already configured hold their storage and manifest.
+			releaseAll(apps)
+			return 1, fmt.Errorf("backup: %s%v", jobPrefix(job.Name), err)
+		}
+		app.version, app.commit = tag, commit
+		apps = append(apps, app)
+	}
+	return run(apps, maxWorkers)
+}
+
+// jobPrefix returns the prefix of messages about the job `name` if set.
+func jobPrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("job %s: ", name)
+}
+
+// newJobApp configures the App instance in charge of the job `job`.
+// Its log entries hold the job name when set.
+func newJobApp(queueSize int, job Job, log logger.Logger) (*App, error) {
+	opts := job.Options
+	if err := checkOrphans(opts.Orphans); err != nil {
+		return nil, err
+	}
+	if err := checkOnDelete(opts.OnDelete); err != nil {
+		return nil, err
+	}
+	if err := archive.Check(opts.archiveFormat()); err != nil {
+		return nil, err
+	}
+	cipher, err := newCipher(opts.KeyFile, opts.Passphrase)
 	if err != nil {
-		return 1, fmt.Errorf("backup: %v", err)
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	if cipher != nil && opts.Dedup {
+		// random nonces make identical files differ so nothing would be shared.
+		return nil, fmt.Errorf("dedup store cannot be combined with encryption")
+	}
+	compressor, err := newCompressor(opts.Compression, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ignore.New(job.Source, opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	archiveCron, err := newArchiveSchedule(opts.ArchiveCron, opts.ArchiveInterval)
+	if err != nil {
+		return nil, err
+	}
+	// resources are opened once settings are valid and released on failure.
+	store, err := newStorage(job.Backup, opts)
+	if err != nil {
+		return nil, err
+	}
+	var remote Storage
+	if opts.S3 != nil || opts.SFTP != nil {
+		remote = store
+	}
+	checksums, err := openManifest(job.Backup, remote, cipher)
+	if err != nil {
+		closeStore(store)
+		return nil, fmt.Errorf("cannot open checksums manifest: %v", err)
+	}
+	var nopts gorsn.Options
+	// the notifier holds nothing to release until it is started.
+	notifier, err := notifier.New(job.Source, &nopts)
+	if err != nil {
+		_ = checksums.close()
+		closeStore(store)
+		return nil, err
+	}
+	if job.Name != "" {
+		log = log.WithJob(job.Name)
 	}
-	app := New(maxWorkers, os.Getpid(), src, dst, notifier, logger)
-	return app.start(maxWorkers)
+	app := New(queueSize, os.Getpid(), job.Source, job.Backup, notifier, store, log)
+	app.name = job.Name
+	app.opts = opts
+	app.cipher = cipher
+	app.compress = compressor
+	app.rules = rules
+	app.manifest = checksums
+	app.archiveCron = archiveCron
+	return app, nil
 }
 
 // ViewLogs uses logview routines to process the content
-// of a given log file based on provided filters.
-func ViewLogs(logfile, date, reg string) (int, error) {
+// of a given log file based on provided filters. Entries
+// could be restricted to those of the job `job` if set.
+func ViewLogs(logfile, date, reg, job string) (int, error) {
 	if !viewer.IsValidFilters(date, reg) {
 		return 1, fmt.Errorf("invalid date and/or regex")
 	}
@@ -45,5 +211,49 @@ func ViewLogs(logfile, date, reg string) (int, error) {
 	if err != nil {
 		return 1, fmt.Errorf("cannot open file: %w", err)
 	}
-	return viewer.Filter(file, date, reg)
+	return viewer.Filter(file, date, reg, job)
+}
+
+// Restore brings back files from a backup folder or an archive
+// into the original source folder or an alternate target folder.
+// Each action is reported into `out`.
+func Restore(out io.Writer, opts RestoreOptions) (int, error) {
+	if opts.To == "" {
+		return 1, fmt.Errorf("missing source or target folder to restore into")
+	}
+
+	ext := Options{Extension: opts.Extension}.extension()
+	cipher, err := newCipher(opts.KeyFile, opts.Passphrase)
+	if err != nil {
+		return 1, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	var entries []backupEntry
+	// the metadata of files is restored when recorded into the manifest.
+	var checksums map[string]ManifestEntry
+	if isArchive(opts.From) {
+		content, err := openArchive(opts.From, ext)
+		if err != nil {
+			return 1, err
+		}
+		defer content.close()
+		entries = content.entries
+		checksums, _ = content.manifest(cipher)
+	} else {
+		if !utils.IsDirPath(opts.From) {
+			return 1, fmt.Errorf("invalid backup folder path. run --help for usage")
+		}
+		var err error
+		if entries, err = loadStorageEntries(openFolderStorage(opts.From), ext); err != nil {
+			return 1, fmt.Errorf("cannot load backup files: %w", err)
+		}
+		checksums, _ = loadManifestFile(filepath.Join(opts.From, manifestFile))
+	}
+	attachMetadata(entries, checksums, ext)
+	attachEncodings(entries, checksums, ext, isArchive(opts.From))
+	decodeEntries(entries, cipher)
+
+	if fails := restore(out, entries, opts); fails > 0 {
+		return 1, fmt.Errorf("failed to restore %d file(s)", fails)
+	}
+	return 0, nil
 }
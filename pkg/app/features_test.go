@@ -1,22 +1,28 @@
 package app
 
 import (
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestViewLogs(t *testing.T) {
 	t.Run("invalid filters", func(t *testing.T) {
-		code, err := ViewLogs("logfile", "23-08-22", "")
+		code, err := ViewLogs("logfile", "23-08-22", "", "")
 		assert.Equal(t, 1, code)
 		assert.EqualError(t, err, "invalid date and/or regex")
 	})
 
 	t.Run("log file does not exist", func(t *testing.T) {
-		code, err := ViewLogs("logfile", "2023-08-22", "*.zip")
+		code, err := ViewLogs("logfile", "2023-08-22", "*.zip", "")
 		assert.Equal(t, 1, code)
 		assert.ErrorIs(t, err, os.ErrNotExist)
 	})
@@ -25,8 +31,96 @@ func TestViewLogs(t *testing.T) {
 		require.NoError(t, err)
 		defer os.Remove(file.Name())
 		file.Close()
-		code, err := ViewLogs(file.Name(), "2023-08-22", "*.zip")
+		code, err := ViewLogs(file.Name(), "2023-08-22", "*.zip", "")
 		assert.Equal(t, 0, code)
 		assert.NoError(t, err)
 	})
 }
+
+func TestNewJobApp_Release(t *testing.T) {
+	dst := t.TempDir()
+	server, err := testhelpers.NewSSHServer(dst)
+	require.NoError(t, err)
+	defer server.Close()
+
+	log := testhelpers.NewTestLogger(t, io.Discard)
+	before := runtime.NumGoroutine()
+	// the source folder is missing so the notifier fails once the storage is open.
+	job := Job{Source: filepath.Join(dst, "missing"), Backup: dst, Options: Options{SFTP: &storage.SFTPOptions{
+		Address:        server.Addr,
+		User:           server.User,
+		KeyFile:        server.KeyFile,
+		KnownHostsFile: server.KnownHostsFile,
+		Root:           filepath.ToSlash(filepath.Join(dst, "remote")),
+	}}}
+	_, err = newJobApp(0, job, log)
+	require.Error(t, err)
+	// an invalid setting fails before the storage is open.
+	job.Source, job.Options.Compression = dst, "lzma"
+	_, err = newJobApp(0, job, log)
+	require.Error(t, err)
+	// the uploader of the sftp storage was stopped.
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestNewStorage(t *testing.T) {
+	s3opts := &storage.S3Options{
+		Endpoint: "http://localhost:9000", Bucket: "bucket", AccessKey: "key", SecretKey: "secret",
+		Prefix: "backup/", ArchivePrefix: "archives/",
+	}
+	cases := []struct {
+		name     string
+		opts     Options
+		expected Storage
+		fail     bool
+	}{
+		{"default", Options{}, storage.NewLocal("dst"), false},
+		{"dedup", Options{Dedup: true}, storage.NewDedup("dst"), false},
+		{"s3 and dedup", Options{Dedup: true, S3: s3opts}, nil, true},
+		{"invalid s3", Options{S3: &storage.S3Options{}}, nil, true},
+		{"s3 and sftp", Options{S3: s3opts, SFTP: &storage.SFTPOptions{}}, nil, true},
+		{"sftp and dedup", Options{Dedup: true, SFTP: &storage.SFTPOptions{}}, nil, true},
+		{"invalid sftp", Options{SFTP: &storage.SFTPOptions{Address: "localhost:22"}}, nil, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := newStorage("dst", tc.opts)
+			assert.Equal(t, tc.fail, err != nil)
+			if !tc.fail {
+				assert.Equal(t, tc.expected, st)
+			}
+		})
+	}
+
+	t.Run("s3", func(t *testing.T) {
+		st, err := newStorage("dst", Options{S3: s3opts})
+		require.NoError(t, err)
+		assert.IsType(t, &storage.S3{}, st)
+	})
+
+	t.Run("sftp", func(t *testing.T) {
+		dst, err := os.MkdirTemp("", "backup")
+		require.NoError(t, err)
+		defer os.RemoveAll(dst)
+		server, err := testhelpers.NewSSHServer(dst)
+		require.NoError(t, err)
+		defer server.Close()
+
+		st, err := newStorage(dst, Options{SFTP: &storage.SFTPOptions{
+			Address:        server.Addr,
+			User:           server.User,
+			KeyFile:        server.KeyFile,
+			KnownHostsFile: server.KnownHostsFile,
+			Root:           filepath.ToSlash(filepath.Join(dst, "remote")),
+		}})
+		require.NoError(t, err)
+		require.IsType(t, &storage.SFTP{}, st)
+		defer st.(*storage.SFTP).Close()
+		// pending uploads are spooled into the backup folder by default.
+		assert.DirExists(t, filepath.Join(dst, spoolFolder))
+	})
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,11 +12,11 @@ import (
 )
 
 // ReceiveFolderEventHandler handles folder added into the source
-// folder. It configures the App watcher to immediately monitor all
-// files inside the folder and its sub-folders. All files found are
-// backed up immediately according to events rules.
+// folder. It creates the same folder tree into the backup folder.
+// All files inside the folder and its sub-folders are watched by
+// the App notifier and backed up according to events rules.
 func (app *App) ReceiveFolderEventHandler(path string) {
-	err := utils.CreateFolder(path)
+	err := app.createBackupFolder(filepath.ToSlash(app.relativePath(path)))
 	if err != nil {
 		app.log.Error("failed: create folder", string(events.WATCH), path, err)
 		return
@@ -26,7 +27,7 @@ func (app *App) ReceiveFolderEventHandler(path string) {
 // DeleteRequestHandler orchestrates the processing of file deletion request.
 func (app *App) DeleteRequestHandler(path string) {
 	var err error
-	filename := strings.TrimPrefix(filepath.Base(path), "delete_")
+	filename := strings.TrimPrefix(filepath.Base(path), app.opts.deletePrefix())
 	spath := filepath.Join(filepath.Dir(path), filename)
 	if err = utils.DeleteFile(spath); err != nil {
 		app.log.Error("failed: delete file", string(events.RDELETE), spath, err)
@@ -34,8 +35,8 @@ func (app *App) DeleteRequestHandler(path string) {
 		app.log.Info("success: delete file", string(events.RDELETE), spath)
 	}
 
-	dpath := filepath.Join(app.dstFolder, filename) + backupFileExtension
-	if err = utils.DeleteFile(dpath); err != nil {
+	dpath := app.backupFilePath(spath)
+	if err = app.deleteFile(dpath); err != nil {
 		app.log.Error("failed: delete file", string(events.RDELETE), dpath, err)
 	} else {
 		app.log.Info("success: delete file", string(events.RDELETE), dpath)
@@ -50,6 +51,9 @@ func (app *App) DeleteRequestHandler(path string) {
 
 // CreateEventHandler orchestrates the processing of file creation events.
 func (app *App) CreateEventHandler(path string) {
+	if app.cancelMirrorDeletion(path) {
+		app.log.Info("success: cancel backup file deletion", string(events.CREATE), path)
+	}
 	err := app.CreateBackupFile(path)
 	if err != nil {
 		app.log.Error("failed: create file", string(events.CREATE), path, err)
@@ -69,38 +73,145 @@ func (app *App) ModifyEventHandler(path string) {
 }
 
 // ScheduleDeleteRequests adds each absolute filepath and its deletion
-// datetime to the map store.
+// datetime to the map store. The journal is reloaded first so deletions
+// cancelled meanwhile are not scheduled again then the store is persisted.
 func (app *App) ScheduleDeleteRequests(at time.Time, paths ...string) {
 	app.mutex.Lock()
+	unlock := app.lockSchedule()
+	app.restoreSchedule()
 	for _, path := range paths {
 		app.store[path] = at
 	}
+	app.persistSchedule()
+	unlock()
 	app.mutex.Unlock()
 }
 
-// RenameEventHandler just logs folder or file renaming events.
-func (app *App) RenameEventHandler(path string) {
-	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
-		app.log.Info("receive: rename folder event", string(events.RENAME), path)
+// RenameEventHandler moves the backup file of a file renamed from `from`
+// to `to` along with its revisions and its checksum. When the previous
+// path is unknown or its backup file could not be moved, the file is
+// copied again and the backup file of the previous path is marked as
+// stale. Files of a renamed folder are handled by their own events.
+func (app *App) RenameEventHandler(from, to string) {
+	if fi, err := os.Stat(to); err == nil && fi.IsDir() {
+		app.log.Info("receive: rename folder event", string(events.RENAME), to)
 		return
 	}
-	app.log.Info("receive: rename file event", string(events.RENAME), path)
+	if app.cancelMirrorDeletion(to) {
+		app.log.Info("success: cancel backup file deletion", string(events.RENAME), to)
+	}
+	if from != "" {
+		err := app.renameBackupFile(from, to)
+		if err == nil {
+			app.log.Info("success: rename file", string(events.RENAME), to)
+			return
+		}
+		app.log.Error("failed: rename file", string(events.RENAME), to, err)
+	}
+	app.copyRenamedFile(from, to)
 }
 
-// DeleteEventHandler just logs folder or file delete events.
-func (app *App) DeleteEventHandler(path string) {
-	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
-		app.log.Info("receive: delete folder event", string(events.DELETE), path)
+// renameBackupFile moves the backup file and the revisions of the file
+// `from` to the names of the file `to`. The content is copied again if
+// the file changed since its last backup.
+func (app *App) renameBackupFile(from, to string) error {
+	oldName, newName := app.backupName(from), app.backupName(to)
+	if app.opts.Versioning {
+		if _, err := app.SaveRevision(newName, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := app.moveBackupFile(oldName, newName); err != nil {
+		return err
+	}
+	if err := app.moveRevisions(oldName, newName); err != nil {
+		return err
+	}
+	if stale, err := app.isStale(to); err != nil || !stale {
+		return err
+	}
+	return app.UpdateBackupFileContent(to)
+}
+
+// copyRenamedFile copies the content of the renamed file `to` and marks
+// the backup file of its previous path `from` as stale. A single path
+// which does not exist anymore is considered as the previous one.
+func (app *App) copyRenamedFile(from, to string) {
+	if _, err := os.Stat(to); err != nil && from == "" {
+		from, to = to, ""
+	}
+	if to != "" {
+		if err := app.UpdateBackupFileContent(to); err != nil {
+			app.log.Error("failed: copy renamed file", string(events.RENAME), to, err)
+		} else {
+			app.log.Info("success: copy renamed file", string(events.RENAME), to)
+		}
+	}
+	if from != "" {
+		app.markStale(from)
+	}
+}
+
+// markStale flags the backup file of the file at `path` with the
+// staleSuffix so it is no longer considered as its current backup.
+func (app *App) markStale(path string) {
+	name := app.backupName(path)
+	if _, err := app.storage.Stat(name); err != nil {
 		return
 	}
-	app.log.Info("receive: delete file event", string(events.DELETE), path)
+	location := app.backupLocation(name)
+	if err := app.moveBackupFile(name, name+staleSuffix); err != nil {
+		app.log.Error("failed: mark stale backup file", string(events.RENAME), location, err)
+		return
+	}
+	app.log.Info("success: mark stale backup file", string(events.RENAME), location)
 }
 
-// AttributeEventHandler just logs folder or file attributes events.
+// DeleteEventHandler applies the deletion policy to the backup file of
+// a deleted file. It is kept by default, moved into the trash folder or
+// scheduled for deletion. Files of a deleted folder have their own events.
+func (app *App) DeleteEventHandler(path string) {
+	policy := app.opts.OnDelete
+	if fi, err := os.Stat(path); err == nil {
+		if fi.IsDir() {
+			app.log.Info("receive: delete folder event", string(events.DELETE), path)
+			return
+		}
+		// the file showed up again so its backup file is still needed.
+		policy = DeleteKeep
+	}
+	switch policy {
+	case DeleteTrash:
+		names, err := app.trashBackupFile(path, time.Now())
+		if err != nil {
+			app.log.Error("failed: trash backup file", string(events.DELETE), path, err)
+			return
+		}
+		app.log.Info(fmt.Sprintf("success: trash backup file [count: %d]", len(names)), string(events.DELETE), path)
+	case DeleteMirror:
+		names, err := app.mirrorDeletion(path, time.Now())
+		if err != nil {
+			app.log.Error("failed: schedule backup file deletion", string(events.DELETE), path, err)
+			return
+		}
+		app.log.Info(fmt.Sprintf("success: schedule backup file deletion [count: %d]", len(names)), string(events.DELETE), path)
+	default:
+		app.log.Info("receive: delete file event", string(events.DELETE), path)
+	}
+}
+
+// AttributeEventHandler updates the metadata of the backup file of a file
+// whose attributes changed without copying its content. Folder attributes
+// events are just logged.
 func (app *App) AttributeEventHandler(path string) {
 	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
 		app.log.Info("receive: folder attribute event", string(events.ATTRIBUTE), path)
 		return
 	}
-	app.log.Info("receive: file attribute event", string(events.ATTRIBUTE), path)
+	err := app.UpdateBackupFileMetadata(path)
+	if err != nil {
+		app.log.Error("failed: update file attributes", string(events.ATTRIBUTE), path, err)
+		return
+	}
+	app.log.Info("success: update file attributes", string(events.ATTRIBUTE), path)
 }
@@ -3,12 +3,14 @@ package app
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/jeamon/gobackup/pkg/storage"
 	"github.com/jeamon/gobackup/pkg/testhelpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,7 +42,7 @@ func TestDeleteRequestHandler(t *testing.T) {
 
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, src, dst, nil, logger)
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
 	app.DeleteRequestHandler(path)
 
 	assert.NoFileExists(t, spath)
@@ -49,6 +51,23 @@ func TestDeleteRequestHandler(t *testing.T) {
 	assert.Equal(t, 4, len(strings.Split(out.String(), "\n")))
 }
 
+func TestReceiveFolderEventHandler(t *testing.T) {
+	src, err := os.MkdirTemp("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	dst, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	folder := filepath.Join(src, "a", "b")
+	require.NoError(t, os.MkdirAll(folder, 0o755))
+
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+	app.ReceiveFolderEventHandler(folder)
+	assert.DirExists(t, filepath.Join(dst, "a", "b"))
+}
+
 func TestCreateEventHandler(t *testing.T) {
 	src, err := os.MkdirTemp("", "source")
 	require.NoError(t, err)
@@ -65,7 +84,7 @@ func TestCreateEventHandler(t *testing.T) {
 
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, src, dst, nil, logger)
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
 	t.Run("success", func(t *testing.T) {
 		app.CreateEventHandler(spath)
 		require.FileExists(t, filepath.Join(dst, "file.bak"))
@@ -142,7 +161,7 @@ func TestModifyEventHandler(t *testing.T) {
 
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, src, dst, nil, logger)
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
 	t.Run("success", func(t *testing.T) {
 		app.ModifyEventHandler(spath)
 		content, err := os.ReadFile(filepath.Join(dst, "file.bak"))
@@ -203,7 +222,7 @@ func TestModifyEventHandler(t *testing.T) {
 }
 
 func TestScheduleDeleteRequests(t *testing.T) {
-	app := New(1, 0, "", "", nil, nil)
+	app := New(1, 0, "", "", nil, nil, nil)
 	now := time.Now()
 	app.ScheduleDeleteRequests(now, "file/path", "file.bak/path", "delete_file/path")
 	require.Equal(t, 3, len(app.store))
@@ -219,36 +238,84 @@ func TestScheduleDeleteRequests(t *testing.T) {
 }
 
 func TestRenameEventHandler(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, "", "", nil, logger)
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
+	revision := revisionName("old.txt.bak", time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC))
+	past := time.Now().Add(-time.Hour)
+
 	t.Run("file", func(t *testing.T) {
-		file, err := os.CreateTemp("", "file")
+		out.Reset()
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "old.txt.bak"), []byte("content"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, revision), []byte("previous"), 0o644))
+		npath := filepath.Join(src, "docs", "new.txt")
+		require.NoError(t, os.MkdirAll(filepath.Dir(npath), 0o755))
+		require.NoError(t, os.WriteFile(npath, []byte("content"), 0o644))
+		require.NoError(t, os.Chtimes(npath, past, past))
+		app.RenameEventHandler(filepath.Join(src, "old.txt"), npath)
+
+		assert.NoFileExists(t, filepath.Join(dst, "old.txt.bak"))
+		assert.NoFileExists(t, filepath.Join(dst, revision))
+		assert.FileExists(t, filepath.Join(dst, "docs", "new.txt.bak"+strings.TrimPrefix(revision, "old.txt.bak")))
+		content, err := os.ReadFile(filepath.Join(dst, "docs", "new.txt.bak"))
 		require.NoError(t, err)
-		fpath := file.Name()
-		file.Close()
-		defer os.Remove(file.Name())
-		app.RenameEventHandler(fpath)
+		assert.Equal(t, "content", string(content))
 
 		var data map[string]interface{}
-		err = json.Unmarshal(out.Bytes(), &data)
+		require.NoError(t, json.Unmarshal(out.Bytes(), &data))
+		assert.Equal(t, "success: rename file", data["msg"])
+		assert.Equal(t, "RENAME", data["event"])
+		assert.Equal(t, npath, data["path"])
+	})
+
+	t.Run("modified file", func(t *testing.T) {
+		out.Reset()
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "draft.txt.bak"), []byte("draft"), 0o644))
+		require.NoError(t, os.Chtimes(filepath.Join(dst, "draft.txt.bak"), past, past))
+		npath := filepath.Join(src, "final.txt")
+		require.NoError(t, os.WriteFile(npath, []byte("final"), 0o644))
+		app.RenameEventHandler(filepath.Join(src, "draft.txt"), npath)
+
+		content, err := os.ReadFile(filepath.Join(dst, "final.txt.bak"))
 		require.NoError(t, err)
+		assert.Equal(t, "final", string(content))
+	})
 
-		level, ok := data["level"].(string)
-		require.Equal(t, true, ok)
-		assert.Equal(t, "INFO", level)
+	t.Run("fallback", func(t *testing.T) {
+		out.Reset()
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "gone.txt.bak"), []byte("gone"), 0o644))
+		npath := filepath.Join(src, "copied.txt")
+		require.NoError(t, os.WriteFile(npath, []byte("copied"), 0o644))
+		app.RenameEventHandler("", npath)
+		app.RenameEventHandler("", filepath.Join(src, "gone.txt"))
 
-		msg, ok := data["msg"].(string)
-		require.Equal(t, true, ok)
-		assert.Equal(t, "receive: rename file event", msg)
+		content, err := os.ReadFile(filepath.Join(dst, "copied.txt.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "copied", string(content))
+		assert.NoFileExists(t, filepath.Join(dst, "gone.txt.bak"))
+		assert.FileExists(t, filepath.Join(dst, "gone.txt.bak"+staleSuffix))
+
+		var msgs []string
+		for _, line := range bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n")) {
+			var data map[string]interface{}
+			require.NoError(t, json.Unmarshal(line, &data))
+			msgs = append(msgs, data["msg"].(string))
+		}
+		assert.Equal(t, []string{"success: copy renamed file", "success: mark stale backup file"}, msgs)
+	})
 
-		event, ok := data["event"].(string)
-		require.Equal(t, true, ok)
-		assert.Equal(t, "RENAME", event)
+	t.Run("missing backup file", func(t *testing.T) {
+		out.Reset()
+		npath := filepath.Join(src, "unknown.txt")
+		require.NoError(t, os.WriteFile(npath, []byte("unknown"), 0o644))
+		app.RenameEventHandler(filepath.Join(src, "missing.txt"), npath)
 
-		path, ok := data["path"].(string)
-		require.Equal(t, true, ok)
-		assert.Equal(t, fpath, path)
+		content, err := os.ReadFile(filepath.Join(dst, "unknown.txt.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "unknown", string(content))
+		assert.Contains(t, out.String(), "failed: rename file")
+		assert.Contains(t, out.String(), "success: copy renamed file")
 	})
 
 	t.Run("folder", func(t *testing.T) {
@@ -256,7 +323,7 @@ func TestRenameEventHandler(t *testing.T) {
 		folder, err := os.MkdirTemp("", "folder")
 		require.NoError(t, err)
 		defer os.RemoveAll(folder)
-		app.RenameEventHandler(folder)
+		app.RenameEventHandler("", folder)
 
 		var data map[string]interface{}
 		err = json.Unmarshal(out.Bytes(), &data)
@@ -283,7 +350,7 @@ func TestRenameEventHandler(t *testing.T) {
 func TestDeleteEventHandler(t *testing.T) {
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, "", "", nil, logger)
+	app := New(1, 0, "", "", nil, nil, logger)
 	t.Run("file", func(t *testing.T) {
 		file, err := os.CreateTemp("", "file")
 		require.NoError(t, err)
@@ -340,22 +407,94 @@ func TestDeleteEventHandler(t *testing.T) {
 		require.Equal(t, true, ok)
 		assert.Equal(t, folder, path)
 	})
+
+	src, dst := t.TempDir(), t.TempDir()
+	revision := revisionName("docs/report.txt.bak", time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC))
+	backup := func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dst, "docs"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "docs", "report.txt.bak"), []byte("report"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, filepath.FromSlash(revision)), []byte("draft"), 0o644))
+	}
+	spath := filepath.Join(src, "docs", "report.txt")
+
+	t.Run("trash", func(t *testing.T) {
+		out.Reset()
+		backup(t)
+		app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
+		app.opts.OnDelete = DeleteTrash
+		app.opts.TrashRetention = time.Hour
+		app.DeleteEventHandler(spath)
+
+		assert.NoFileExists(t, filepath.Join(dst, "docs", "report.txt.bak"))
+		trashed, err := filepath.Glob(filepath.Join(dst, trashFolder, "*", "docs", "report.txt.bak"))
+		require.NoError(t, err)
+		require.Len(t, trashed, 1)
+		assert.FileExists(t, filepath.Join(filepath.Dir(filepath.Dir(trashed[0])), filepath.FromSlash(revision)))
+		assert.Len(t, app.store, 2)
+		assert.Contains(t, app.store, trashed[0])
+
+		var data map[string]interface{}
+		require.NoError(t, json.Unmarshal(out.Bytes(), &data))
+		assert.Equal(t, "success: trash backup file [count: 2]", data["msg"])
+
+		// the same file deleted again at the same time gets its own entry.
+		backup(t)
+		now := time.Now()
+		first, err := app.trashBackupFile(spath, now)
+		require.NoError(t, err)
+		backup(t)
+		second, err := app.trashBackupFile(spath, now)
+		require.NoError(t, err)
+		require.Len(t, first, 2)
+		require.Len(t, second, 2)
+		assert.NotEqual(t, first[0], second[0])
+		for _, name := range append(first, second...) {
+			assert.FileExists(t, filepath.Join(dst, filepath.FromSlash(name)))
+		}
+		assert.Len(t, app.store, 6)
+		require.NoError(t, os.RemoveAll(filepath.Join(dst, trashFolder)))
+		require.NoError(t, os.Remove(filepath.Join(dst, scheduleFile)))
+	})
+
+	t.Run("mirror", func(t *testing.T) {
+		out.Reset()
+		backup(t)
+		app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
+		app.opts.OnDelete = DeleteMirror
+		app.opts.DeleteGrace = time.Hour
+		app.DeleteEventHandler(spath)
+
+		assert.FileExists(t, filepath.Join(dst, "docs", "report.txt.bak"))
+		at, ok := app.store[filepath.Join(dst, "docs", "report.txt.bak")]
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), at, time.Minute)
+		assert.Len(t, app.store, 2)
+
+		// the file shows up again during the grace delay.
+		assert.True(t, app.cancelMirrorDeletion(spath))
+		assert.Empty(t, app.store)
+		app.deleteDue(time.Now().Add(2 * time.Hour))
+		assert.FileExists(t, filepath.Join(dst, "docs", "report.txt.bak"))
+	})
 }
 
 func TestAttributeEventHandler(t *testing.T) {
 	out := bytes.NewBuffer(nil)
 	logger := testhelpers.NewTestLogger(t, out)
-	app := New(1, 0, "", "", nil, logger)
+	src, dst := t.TempDir(), t.TempDir()
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), logger)
 	t.Run("file", func(t *testing.T) {
-		file, err := os.CreateTemp("", "file")
-		require.NoError(t, err)
-		fpath := file.Name()
-		file.Close()
-		defer os.Remove(file.Name())
+		fpath := filepath.Join(src, "script.sh")
+		require.NoError(t, os.WriteFile(fpath, []byte("#!/bin/sh"), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(fpath))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "script.sh.bak"), []byte("kept"), 0o644))
+		require.NoError(t, os.Chmod(fpath, 0o750))
+		mtime := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+		require.NoError(t, os.Chtimes(fpath, mtime, mtime))
 		app.AttributeEventHandler(fpath)
 
 		var data map[string]interface{}
-		err = json.Unmarshal(out.Bytes(), &data)
+		err := json.Unmarshal(out.Bytes(), &data)
 		require.NoError(t, err)
 
 		level, ok := data["level"].(string)
@@ -364,7 +503,7 @@ func TestAttributeEventHandler(t *testing.T) {
 
 		msg, ok := data["msg"].(string)
 		require.Equal(t, true, ok)
-		assert.Equal(t, "receive: file attribute event", msg)
+		assert.Equal(t, "success: update file attributes", msg)
 
 		event, ok := data["event"].(string)
 		require.Equal(t, true, ok)
@@ -373,6 +512,15 @@ func TestAttributeEventHandler(t *testing.T) {
 		path, ok := data["path"].(string)
 		require.Equal(t, true, ok)
 		assert.Equal(t, fpath, path)
+
+		// the content is not copied again.
+		content, err := os.ReadFile(filepath.Join(dst, "script.sh.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "kept", string(content))
+		fi, err := os.Stat(filepath.Join(dst, "script.sh.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o750), fi.Mode().Perm())
+		assert.True(t, mtime.Equal(fi.ModTime()))
 	})
 
 	t.Run("folder", func(t *testing.T) {
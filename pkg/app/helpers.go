@@ -1,53 +1,203 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/jeamon/gobackup/pkg/compression"
+	"github.com/jeamon/gobackup/pkg/metadata"
+	"github.com/jeamon/gobackup/pkg/safecopy"
 	"github.com/jeamon/gobackup/pkg/utils"
 )
 
 // UpdateBackupFileContent copies the content of a given file path
-// to its the backup file.
-func (app *App) UpdateBackupFileContent(path string) (err error) {
-	r, err := os.Open(path)
+// to its the backup file. In versioning mode, the previous content
+// of the backup file is kept as a timestamped revision which is
+// dropped if the copy fails since the backup file remains. The content
+// is compressed then encrypted when these options are enabled. Its
+// checksum is recorded into the manifest along the file metadata.
+// In safe copy mode, a file modified during its copy is copied again.
+func (app *App) UpdateBackupFileContent(path string) error {
+	name := app.backupName(path)
+	var revision string
+	if app.opts.Versioning {
+		var err error
+		if revision, err = app.SaveRevision(name, time.Now()); err != nil {
+			return err
+		}
+	}
+	if app.opts.SafeCopy && app.opts.WaitClose > 0 {
+		// the copy goes on once the delay expired and is retried if needed.
+		safecopy.WaitClosed(path, app.opts.WaitClose)
+	}
+	var sum *digest
+	var md metadata.Metadata
+	var err error
+	for attempt := 1; ; attempt++ {
+		sum, md, err = app.copyContent(path, name)
+		if !errors.Is(err, safecopy.ErrChanged) || attempt > app.opts.copyRetries() {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * copyRetryDelay)
+	}
 	if err != nil {
+		if revision != "" {
+			_ = app.removeBackupFile(revision)
+		}
 		return err
 	}
-	defer r.Close()
+	if err = app.setBackupMetadata(name, md); err != nil {
+		return err
+	}
+	return app.recordChecksum(name, sum, app.encodingOf(path), md)
+}
+
+// encodingOf returns how the content of the file `path` is stored
+// into its backup file with the options of the App.
+func (app *App) encodingOf(path string) Encoding {
+	return Encoding{
+		Compressed: app.compress != nil && !compression.Skip(path),
+		Encrypted:  app.cipher != nil,
+	}
+}
 
-	w, err := os.OpenFile(filepath.Join(app.dstFolder, filepath.Base(path))+backupFileExtension, os.O_CREATE|os.O_WRONLY, 0o644)
+// copyContent writes the content of the file `path` into the backup file
+// `name` and returns its checksum and the metadata of the file. In safe
+// copy mode, it fails with safecopy.ErrChanged if the file was modified
+// meanwhile so the previous content of the backup file is kept.
+func (app *App) copyContent(path, name string) (*digest, metadata.Metadata, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, metadata.Metadata{}, err
+	}
+	defer f.Close()
+	md, err := metadata.Read(path)
+	if err != nil {
+		return nil, md, err
 	}
 
-	defer func() {
-		if c := w.Close(); c != nil && err == nil {
-			err = c
+	enc := app.encodingOf(path)
+	if fc, ok := app.storage.(fileCopier); ok && app.opts.SafeCopy && enc == (Encoding{}) {
+		sum, err := app.cloneContent(fc, path, name)
+		return sum, md, err
+	}
+
+	var src io.Reader = f
+	if app.opts.SafeCopy {
+		if src, err = safecopy.NewReader(f); err != nil {
+			return nil, md, err
 		}
-	}()
+	}
+	sum := newDigest()
+	r := io.TeeReader(src, sum)
+	if enc.Compressed {
+		cr := app.compress.Compress(r)
+		defer cr.Close()
+		r = cr
+	}
+	if enc.Encrypted {
+		r = app.cipher.Encrypt(r)
+	}
+	return sum, md, app.storage.Put(name, r)
+}
 
-	_, err = io.Copy(w, r)
-	return err
+// cloneContent copies the file `path` as is into the backup file `name`
+// through the storage copier which gets a point in time copy when the
+// filesystem supports it. The checksum is computed from the backup file.
+func (app *App) cloneContent(fc fileCopier, path, name string) (*digest, error) {
+	if err := fc.CopyFile(name, path); err != nil {
+		return nil, err
+	}
+	rc, err := app.storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	sum := newDigest()
+	if _, err = io.Copy(sum, rc); err != nil {
+		return nil, err
+	}
+	return sum, nil
 }
 
 // CreateBackupFile creates a file into the backup folder with
-// same name as the original file and use `.bat` as extension.
+// same relative path as the original file and use `.bak` as
+// extension. Missing intermediate folders are created. In versioning
+// mode, an existing backup file of a recreated file is kept as a revision.
 func (app *App) CreateBackupFile(path string) error {
-	f, err := os.Create(filepath.Join(app.dstFolder, filepath.Base(path)) + backupFileExtension)
+	name := app.backupName(path)
+	if app.opts.Versioning {
+		if _, err := app.SaveRevision(name, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := app.storage.Put(name, strings.NewReader("")); err != nil {
+		return err
+	}
+	// the metadata is only set on the backup file once the content is copied.
+	md, _ := metadata.Read(path)
+	return app.recordChecksum(name, newDigest(), Encoding{}, md)
+}
+
+// UpdateBackupFileMetadata updates the metadata of the backup file of a
+// given file path without copying its content again. A missing backup
+// file gets the whole content.
+func (app *App) UpdateBackupFileMetadata(path string) error {
+	name := app.backupName(path)
+	if _, err := app.storage.Stat(name); errors.Is(err, fs.ErrNotExist) {
+		return app.UpdateBackupFileContent(path)
+	}
+	md, err := metadata.Read(path)
 	if err != nil {
 		return err
 	}
-	return f.Close()
+	if err = app.setBackupMetadata(name, md); err != nil {
+		return err
+	}
+	if err = app.manifest.setMetadata(name, md); err != nil {
+		return fmt.Errorf("failed to record metadata: %w", err)
+	}
+	return nil
+}
+
+// setBackupMetadata sets the metadata `md` of a source file on its backup
+// file `name` when the storage supports it. Backup files never get setuid
+// nor setgid bits and stay readable and writable by their owner.
+func (app *App) setBackupMetadata(name string, md metadata.Metadata) error {
+	ms, ok := app.storage.(metadataSetter)
+	if !ok {
+		return nil
+	}
+	if md.Mode != 0 {
+		md.Mode = md.Mode&fs.ModePerm | 0o600
+	}
+	if err := ms.SetMetadata(name, md); err != nil {
+		return fmt.Errorf("failed to set metadata: %w", err)
+	}
+	return nil
+}
+
+// recordChecksum saves into the manifest the checksum `sum` of the content
+// of the backup file `name`, its encoding `enc` and the metadata `md` of
+// its source.
+func (app *App) recordChecksum(name string, sum *digest, enc Encoding, md metadata.Metadata) error {
+	if err := app.manifest.record(name, sum, enc, md); err != nil {
+		return fmt.Errorf("failed to record checksum: %w", err)
+	}
+	return nil
 }
 
 // IsImmediateDelete checks wether the filename matches the required pattern
 // to trigger immediate deletion action of that file.
 func (app *App) IsImmediateDelete(path string) bool {
-	return strings.HasPrefix(path, "delete_") && len(strings.TrimPrefix(path, "delete_")) > 0
+	prefix := app.opts.deletePrefix()
+	return strings.HasPrefix(path, prefix) && len(strings.TrimPrefix(path, prefix)) > 0
 }
 
 // IsScheduleDelete checks if a filename matches the required pattern
@@ -56,19 +206,143 @@ func (app *App) IsImmediateDelete(path string) bool {
 // should matches the RFC3339 ("2006-01-02T15:04:05Z07:00") format.
 // If so, it returns the absolute source & backup filepaths and datetime.
 func (app *App) IsScheduleDelete(path string) (string, string, time.Time, bool) {
-	if !strings.HasPrefix(filepath.Base(path), "delete_") {
+	prefix := app.opts.deletePrefix()
+	if !strings.HasPrefix(filepath.Base(path), prefix) {
 		return "", "", time.Time{}, false
 	}
 
-	suffix := strings.TrimPrefix(filepath.Base(path), "delete_")
+	suffix := strings.TrimPrefix(filepath.Base(path), prefix)
 	isodatetime, filename, found := strings.Cut(suffix, "_")
 	if found && len(filename) > 0 {
 		if at, err := time.Parse(time.RFC3339, utils.FixColonCharacter(isodatetime)); err == nil {
 			spath := filepath.Join(filepath.Dir(path), filename)
-			dpath := filepath.Join(app.dstFolder, filename) + backupFileExtension
-			return spath, dpath, at, true
+			return spath, app.backupFilePath(spath), at, true
 		}
 	}
 
 	return "", "", time.Time{}, false
 }
+
+// relativePath returns the path of a given file relative to the source
+// folder. Files outside the source folder fall back to their base name.
+func (app *App) relativePath(path string) string {
+	rel, err := filepath.Rel(app.srcFolder, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// backupFilePath maps a given source file path to its backup file path.
+// The relative path of the file under the source folder is reproduced
+// inside the backup folder so same-named files never overwrite each other.
+func (app *App) backupFilePath(path string) string {
+	return filepath.Join(app.dstFolder, app.relativePath(path)) + app.opts.extension()
+}
+
+// backupName maps a given source file path to the name of its backup
+// file into the storage. It is the slash-separated form of the backup
+// file path relative to the backup folder.
+func (app *App) backupName(path string) string {
+	return filepath.ToSlash(app.relativePath(path)) + app.opts.extension()
+}
+
+// storageName converts a path inside the backup folder into its name
+// into the storage. It reports false if the path is outside the folder.
+func (app *App) storageName(dpath string) (string, bool) {
+	if app.dstFolder == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(app.dstFolder, dpath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// backupLocation converts a storage name into its path inside the backup
+// folder. It is used to reference backup files into log entries.
+func (app *App) backupLocation(name string) string {
+	return filepath.Join(app.dstFolder, filepath.FromSlash(name))
+}
+
+// deleteFile removes a file. Paths inside the backup folder are
+// removed from the storage, others from the local filesystem.
+func (app *App) deleteFile(path string) error {
+	if name, ok := app.storageName(path); ok {
+		return app.removeBackupFile(name)
+	}
+	return utils.DeleteFile(path)
+}
+
+// createBackupFolder creates a folder into the storage when it
+// supports folders. Flat object stores have nothing to create.
+func (app *App) createBackupFolder(name string) error {
+	if fc, ok := app.storage.(folderCreator); ok {
+		return fc.CreateFolder(name)
+	}
+	return nil
+}
+
+// moveBackupFile moves a backup file from the name `from` to `to`. The
+// storage renaming ability is used when available. Otherwise the content
+// is copied to the new name then removed from the old one.
+func (app *App) moveBackupFile(from, to string) error {
+	if rn, ok := app.storage.(renamer); ok {
+		if err := rn.Rename(from, to); err != nil {
+			return err
+		}
+		return app.manifest.move(from, to)
+	}
+
+	r, err := app.storage.Get(from)
+	if err != nil {
+		return err
+	}
+	err = app.storage.Put(to, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if err = app.storage.Delete(from); err != nil {
+		return err
+	}
+	return app.manifest.move(from, to)
+}
+
+// copyBackupFile copies the backup file `from` and its manifest entry to `to`.
+func (app *App) copyBackupFile(from, to string) error {
+	r, err := app.storage.Get(from)
+	if err != nil {
+		return err
+	}
+	err = app.storage.Put(to, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	return app.manifest.copy(from, to)
+}
+
+// removeBackupFile deletes the backup file `name` and its manifest entry.
+func (app *App) removeBackupFile(name string) error {
+	if err := app.storage.Delete(name); err != nil {
+		return err
+	}
+	return app.manifest.remove(name)
+}
+
+// CollectChunks removes the chunks no longer referenced by any backup
+// file when the storage is a deduplicating store.
+func (app *App) CollectChunks() {
+	gc, ok := app.storage.(garbageCollector)
+	if !ok {
+		return
+	}
+	removed, err := gc.GC()
+	if err != nil {
+		app.log.Error("failed: remove unused chunks", PRUNE, app.dstFolder, err)
+		return
+	}
+	app.log.Info(fmt.Sprintf("success: remove unused chunks [count: %d]", removed), PRUNE, app.dstFolder)
+}
@@ -1,12 +1,18 @@
 package app
 
 import (
+	"archive/zip"
 	"bytes"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,7 +26,7 @@ func TestUpdateBackupFileContent(t *testing.T) {
 	require.NoError(t, err)
 	sfile.Close()
 
-	app := &App{dstFolder: filepath.Dir(sfilepath)}
+	app := &App{dstFolder: filepath.Dir(sfilepath), storage: storage.NewLocal(filepath.Dir(sfilepath))}
 	err = app.UpdateBackupFileContent(sfilepath)
 	require.NoError(t, err)
 	backupFilepath := sfilepath + backupFileExtension
@@ -39,7 +45,7 @@ func TestCreateBackupFile(t *testing.T) {
 	defer os.Remove(sfilepath)
 	sfile.Close()
 
-	app := &App{dstFolder: filepath.Dir(sfilepath)}
+	app := &App{dstFolder: filepath.Dir(sfilepath), storage: storage.NewLocal(filepath.Dir(sfilepath))}
 	err = app.CreateBackupFile(sfilepath)
 	require.NoError(t, err)
 
@@ -130,7 +136,7 @@ func TestIsScheduleDelete(t *testing.T) {
 		},
 	}
 
-	app := &App{srcFolder: src, dstFolder: dst}
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst)}
 	for _, tc := range cases {
 		t.Run(tc.path, func(t *testing.T) {
 			s, d, dt, m := app.IsScheduleDelete(tc.path)
@@ -141,3 +147,189 @@ func TestIsScheduleDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestBackupFilePath(t *testing.T) {
+	src := filepath.Join("home", "source")
+	dst := filepath.Join("home", "backup")
+	cases := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"top level file", filepath.Join(src, "report.txt"), filepath.Join(dst, "report.txt.bak")},
+		{"nested file", filepath.Join(src, "a", "report.txt"), filepath.Join(dst, "a", "report.txt.bak")},
+		{"other nested file", filepath.Join(src, "b", "report.txt"), filepath.Join(dst, "b", "report.txt.bak")},
+		{"outside source folder", filepath.Join("home", "other", "report.txt"), filepath.Join(dst, "report.txt.bak")},
+	}
+
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst)}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := app.backupFilePath(tc.path)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestCustomNaming(t *testing.T) {
+	src := filepath.Join("home", "source")
+	dst := filepath.Join("home", "backup")
+	app := &App{srcFolder: src, dstFolder: dst, opts: Options{Extension: ".backup", DeletePrefix: "remove_"}}
+
+	assert.Equal(t, filepath.Join(dst, "a", "report.txt.backup"), app.backupFilePath(filepath.Join(src, "a", "report.txt")))
+	assert.Equal(t, "a/report.txt.backup", app.backupName(filepath.Join(src, "a", "report.txt")))
+	assert.True(t, app.IsImmediateDelete("remove_report.txt"))
+	assert.False(t, app.IsImmediateDelete("delete_report.txt"))
+
+	spath, dpath, _, ok := app.IsScheduleDelete(filepath.Join(src, "remove_2023-08-14T10:00:00Z_report.txt"))
+	require.True(t, ok)
+	assert.Equal(t, filepath.Join(src, "report.txt"), spath)
+	assert.Equal(t, filepath.Join(dst, "report.txt.backup"), dpath)
+}
+
+func TestUpdateBackupFileContent_NestedFolders(t *testing.T) {
+	src, err := os.MkdirTemp("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst)}
+	for _, folder := range []string{"a", "b"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(src, folder), 0o755))
+		spath := filepath.Join(src, folder, "report.txt")
+		require.NoError(t, os.WriteFile(spath, []byte(folder+" content"), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+	}
+
+	for _, folder := range []string{"a", "b"} {
+		data, err := os.ReadFile(filepath.Join(dst, folder, "report.txt.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, folder+" content", string(data))
+	}
+}
+
+func TestUpdateBackupFileContent_Dedup(t *testing.T) {
+	src, err := os.MkdirTemp("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	folder, err := os.MkdirTemp("", "folder")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.Mkdir(dst, 0o755))
+
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewDedup(dst)}
+	for _, name := range []string{"one.txt", "two.txt"} {
+		spath := filepath.Join(src, name)
+		require.NoError(t, os.WriteFile(spath, []byte("same content"), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+	}
+
+	m, err := storage.ReadManifest(filepath.Join(dst, "one.txt.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("same content")), m.Size)
+
+	t.Run("archive", func(t *testing.T) {
+		success, fails, _, zpath, err := app.save("20230814.100000.1111")
+		require.NoError(t, err)
+		assert.Equal(t, 2, success)
+		assert.Equal(t, 0, fails)
+		zr, err := zip.OpenReader(zpath)
+		require.NoError(t, err)
+		defer zr.Close()
+		for _, zf := range zr.File {
+			if zf.Name == archiveInfoFile {
+				continue
+			}
+			r, err := zf.Open()
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			r.Close()
+			require.NoError(t, err)
+			assert.Equal(t, "same content", string(data))
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		target := filepath.Join(folder, "restored")
+		code, err := Restore(io.Discard, RestoreOptions{From: dst, To: target})
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		data, err := os.ReadFile(filepath.Join(target, "two.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "same content", string(data))
+	})
+}
+
+func TestMoveBackupFile_NoRenamer(t *testing.T) {
+	store := testhelpers.NewMemoryStorage()
+	require.NoError(t, store.Put("a/file.bak", strings.NewReader("content")))
+	app := &App{storage: store}
+	require.NoError(t, app.moveBackupFile("a/file.bak", "b/file.bak"))
+
+	_, err := store.Stat("a/file.bak")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	r, err := store.Get("b/file.bak")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+}
+
+// growingStorage appends a line to the file `path` on the first
+// Put like a process still writing into it during its copy.
+type growingStorage struct {
+	*testhelpers.MemoryStorage
+	path string
+	puts int
+}
+
+func (g *growingStorage) Put(name string, r io.Reader) error {
+	g.puts++
+	if g.puts == 1 {
+		f, err := os.OpenFile(g.path, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString("second line\n")
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return g.MemoryStorage.Put(name, r)
+}
+
+func TestUpdateBackupFileContent_SafeCopy(t *testing.T) {
+	src := t.TempDir()
+	spath := filepath.Join(src, "app.log")
+	require.NoError(t, os.WriteFile(spath, []byte("first line\n"), 0o644))
+
+	t.Run("retry changed file", func(t *testing.T) {
+		store := &growingStorage{MemoryStorage: testhelpers.NewMemoryStorage(), path: spath}
+		app := &App{srcFolder: src, storage: store, opts: Options{SafeCopy: true}}
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+		assert.Equal(t, 2, store.puts)
+		r, err := store.Get("app.log.bak")
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "first line\nsecond line\n", string(data))
+	})
+
+	t.Run("local copy", func(t *testing.T) {
+		dst := t.TempDir()
+		checksums, err := openManifest(dst, nil, nil)
+		require.NoError(t, err)
+		defer checksums.close()
+		app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), manifest: checksums, opts: Options{SafeCopy: true, WaitClose: time.Second}}
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+		data, err := os.ReadFile(filepath.Join(dst, "app.log.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "first line\nsecond line\n", string(data))
+		assert.Equal(t, int64(len(data)), checksums.entries["app.log.bak"].Size)
+	})
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+)
+
+// Job defines a source folder to monitor and the backup folder where
+// to save its files with its own options. All jobs of a process share
+// the same backup workers and log file.
+type Job struct {
+	Name    string // identifies the job into log entries. Optional for a single job.
+	Source  string
+	Backup  string
+	Options Options
+}
+
+// task is a change to handle by the App of the job it belongs to. A task
+// without change is the barrier of a rename handled by another worker.
+type task struct {
+	app    *App
+	change *events.Change
+	ready  chan struct{} // closed once the worker of the previous path is held.
+	done   chan struct{} // closed once the rename ran to release that worker.
+}
+
+// pool is the set of backup workers shared by the App of all jobs. Each
+// worker has its own queue and tasks are dispatched by the hash of their
+// path so events of a same path are always handled serially, in order,
+// while different paths are handled in parallel. A rename is handled once
+// the worker of its previous path reached it so it never races with the
+// events of both paths.
+type pool struct {
+	queues []chan task
+	mu     sync.Mutex // queues both tasks of a rename at once so they never deadlock.
+	wg     sync.WaitGroup
+}
+
+// newPool configures a pool of `workers` workers having each
+// a tasks queue of `size` capacity.
+func newPool(workers, size int) *pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &pool{queues: make([]chan task, workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan task, size)
+	}
+	return p
+}
+
+// start boots the workers in charge of consuming
+// tasks queued on their queue and process them.
+func (p *pool) start() {
+	for i := range p.queues {
+		id := i
+		p.wg.Add(1)
+		go p.worker(id)
+	}
+}
+
+// worker processes each task of its queue with the
+// App it belongs to until the queue is closed.
+func (p *pool) worker(id int) {
+	defer p.wg.Done()
+	for t := range p.queues[id] {
+		if t.change == nil {
+			close(t.ready)
+			<-t.done
+			continue
+		}
+		if t.ready != nil {
+			<-t.ready
+		}
+		t.app.handleEvent(t.change)
+		if t.done != nil {
+			close(t.done)
+		}
+	}
+	log.Println("stopped backup worker:", id)
+}
+
+// submit queues the task `t` to the worker in charge of its path. A rename
+// from a path of another worker also queues a barrier to that worker.
+func (p *pool) submit(t task) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.queueOf(t.change.Path)
+	if t.change.Ops == events.RENAME && t.change.From != "" {
+		if from := p.queueOf(t.change.From); from != id {
+			t.ready, t.done = make(chan struct{}), make(chan struct{})
+			p.queues[from] <- task{ready: t.ready, done: t.done}
+		}
+	}
+	p.queues[id] <- t
+}
+
+// queueOf returns the index of the queue of the worker in charge of `path`.
+func (p *pool) queueOf(path string) int {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// forward submits each event queued on the App `jobs` queue
+// to the pool until that queue is closed.
+func (p *pool) forward(app *App) {
+	for ce := range app.jobs {
+		p.submit(task{app: app, change: ce})
+	}
+}
+
+// close waits for queued tasks to be processed then stops all workers.
+func (p *pool) close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// run watches the source folder of each App with `maxWorkers` backup
+// workers shared between them. A signal stops all of them and so does
+// the failure of any monitor. Once stopped, each App saves its backup
+// folder state even after a failure, whose error is reported along.
+func run(apps []*App, maxWorkers int) (int, error) {
+	p := newPool(maxWorkers, maxWorkers)
+	p.start()
+	err := monitorAll(apps, p)
+	p.close()
+	for _, app := range apps {
+		app.wg.Wait()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to start files monitor: %v", err)
+	}
+	if serr := saveAll(apps); serr != nil {
+		err = errors.Join(err, serr)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// monitorAll starts the routines of each App and forwards their events
+// to the pool. It returns once all monitors stopped with their errors.
+func monitorAll(apps []*App, p *pool) error {
+	ctx := context.Background()
+	errs := make([]error, len(apps))
+	var wg sync.WaitGroup
+	for i, app := range apps {
+		i, app := i, app
+		go app.sigHandler(make(chan os.Signal, 1))
+		// resume deletions scheduled before a restart and run overdue ones.
+		app.deleteDue(time.Now())
+		app.startDeleteWorker()
+		app.startArchiveWorker(make(chan os.Signal, 1))
+		if app.opts.Versioning {
+			app.startPruneWorker()
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.forward(app)
+		}()
+		go func() {
+			defer wg.Done()
+			synced := app.startSync()
+			if err := app.monitorFiles(ctx); err != nil {
+				errs[i] = err
+				if app.name != "" {
+					errs[i] = fmt.Errorf("job %s: %w", app.name, err)
+				}
+				stopAll(apps)
+			}
+			// the reconciliation queues events so it must end before.
+			<-synced
+			app.CloseQueue()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// stopAll stops all App instances.
+func stopAll(apps []*App) {
+	for _, app := range apps {
+		app.Stop()
+	}
+}
+
+// releaseAll closes the manifest and the storage of each App which
+// was configured but never started.
+func releaseAll(apps []*App) {
+	for _, app := range apps {
+		if err := app.manifest.close(); err != nil {
+			app.log.Error("failed: save checksums manifest", SAVE, app.dstFolder, err)
+		}
+		app.closeStorage()
+	}
+}
+
+// saveAll releases the storage and the manifest of each App and saves
+// its backup folder state. It returns the first error once all got saved.
+func saveAll(apps []*App) error {
+	var first error
+	for _, app := range apps {
+		app.CollectChunks()
+		if err := app.manifest.close(); err != nil {
+			app.log.Error("failed: save checksums manifest", SAVE, app.dstFolder, err)
+		}
+		err := app.saveArchive(time.Now())
+		app.closeStorage()
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// validateJobs ensures jobs can run together. Each job must have a
+// unique name, its own backup folder and a source folder which does
+// not overlap with the others nor with any backup folder.
+func validateJobs(jobs []Job) error {
+	if len(jobs) == 0 {
+		return fmt.Errorf("no job to run")
+	}
+	if len(jobs) == 1 {
+		return nil
+	}
+	names := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		if job.Name == "" {
+			return fmt.Errorf("missing name of job with source %s", job.Source)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("duplicate job name %s", job.Name)
+		}
+		names[job.Name] = true
+	}
+	for i, a := range jobs {
+		for _, b := range jobs[i+1:] {
+			if err := checkOverlap(a, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkOverlap ensures the folders of the jobs `a` and `b` are distinct.
+func checkOverlap(a, b Job) error {
+	switch {
+	case isWithin(a.Source, b.Source) || isWithin(b.Source, a.Source):
+		return fmt.Errorf("jobs %s and %s have overlapping source folders", a.Name, b.Name)
+	case isWithin(a.Backup, b.Backup) || isWithin(b.Backup, a.Backup):
+		return fmt.Errorf("jobs %s and %s have overlapping backup folders", a.Name, b.Name)
+	case isWithin(a.Backup, b.Source) || isWithin(b.Backup, a.Source):
+		return fmt.Errorf("jobs %s and %s have a backup folder into the source folder of the other", a.Name, b.Name)
+	}
+	return nil
+}
+
+// isWithin reports whether the folder `path` is `root` or one of its subfolders.
+func isWithin(path, root string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
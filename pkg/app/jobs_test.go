@@ -0,0 +1,232 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/jeamon/gorsn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestJobApp provides an App watching `src` into `dst` with the
+// monitor `start` routine. Its log entries hold the job `name`.
+func newTestJobApp(t *testing.T, name, src, dst string, out io.Writer, start func(context.Context, <-chan struct{}, events.Queue) error) *App {
+	t.Helper()
+	watcher := &testhelpers.MockMonitor{StartFunc: start}
+	app := New(1, 0, src, dst, watcher, storage.NewLocal(dst), testhelpers.NewTestLogger(t, out).WithJob(name))
+	app.name = name
+	return app
+}
+
+func TestPool(t *testing.T) {
+	folder := t.TempDir()
+	var apps []*App
+	for _, name := range []string{"a", "b"} {
+		src := filepath.Join(folder, name, "source")
+		dst := filepath.Join(folder, name, "backup")
+		require.NoError(t, os.MkdirAll(src, 0o755))
+		require.NoError(t, os.MkdirAll(dst, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(src, "file.txt"), []byte(name), 0o644))
+		apps = append(apps, newTestJobApp(t, name, src, dst, io.Discard, nil))
+	}
+
+	p := newPool(3, 1)
+	p.start()
+	done := make(chan struct{})
+	go func() {
+		for _, app := range apps {
+			p.forward(app)
+		}
+		close(done)
+	}()
+	for _, app := range apps {
+		app.jobs <- &events.Change{Path: filepath.Join(app.srcFolder, "file.txt"), Ops: events.CREATE}
+		app.CloseQueue()
+	}
+	<-done
+	p.close()
+
+	for _, app := range apps {
+		assert.FileExists(t, filepath.Join(app.dstFolder, "file.txt"+backupFileExtension))
+	}
+}
+
+func TestPool_Submit(t *testing.T) {
+	p := newPool(4, 100)
+	app := New(0, 0, "", "", nil, nil, nil)
+	paths := []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"}
+	ops := []events.Event{events.CREATE, events.MODIFY, events.ATTRIBUTE, events.MODIFY}
+	for _, ops := range ops {
+		for _, path := range paths {
+			p.submit(task{app: app, change: &events.Change{Path: path, Ops: ops}})
+		}
+	}
+
+	// all events of a path are queued to the same worker in order.
+	got := make(map[string][]events.Event)
+	queueOf := make(map[string]int)
+	for i, q := range p.queues {
+		close(q)
+		for task := range q {
+			path := task.change.Path
+			got[path] = append(got[path], task.change.Ops)
+			if id, ok := queueOf[path]; ok {
+				assert.Equal(t, id, i)
+			}
+			queueOf[path] = i
+		}
+	}
+	for _, path := range paths {
+		assert.Equal(t, ops, got[path])
+	}
+}
+
+func TestPool_Rename(t *testing.T) {
+	p := newPool(4, 100)
+	app := New(0, 0, "", "", nil, nil, nil)
+	from, to := "a.txt", ""
+	for _, path := range []string{"b.txt", "c.txt", "d.txt", "e.txt", "f.txt"} {
+		if p.queueOf(path) != p.queueOf(from) {
+			to = path
+			break
+		}
+	}
+	require.NotEmpty(t, to)
+	p.submit(task{app: app, change: &events.Change{Path: from, Ops: events.MODIFY}})
+	p.submit(task{app: app, change: &events.Change{Path: to, From: from, Ops: events.RENAME}})
+
+	// the worker of the previous path gets a barrier after its pending events.
+	fq, tq := p.queues[p.queueOf(from)], p.queues[p.queueOf(to)]
+	require.Len(t, fq, 2)
+	require.Len(t, tq, 1)
+	modify, barrier, rename := <-fq, <-fq, <-tq
+	assert.Equal(t, events.MODIFY, modify.change.Ops)
+	assert.Nil(t, barrier.change)
+	assert.Equal(t, events.RENAME, rename.change.Ops)
+	assert.Equal(t, barrier.ready, rename.ready)
+	assert.Equal(t, barrier.done, rename.done)
+}
+
+func TestRun(t *testing.T) {
+	folder := t.TempDir()
+	out := &testhelpers.SyncBuffer{}
+	var apps []*App
+	for _, name := range []string{"docs", "photos"} {
+		src := filepath.Join(folder, name, "source")
+		dst := filepath.Join(folder, name, "backup")
+		require.NoError(t, os.MkdirAll(src, 0o755))
+		require.NoError(t, os.MkdirAll(dst, 0o755))
+		file := filepath.Join(src, name+".txt")
+		require.NoError(t, os.WriteFile(file, []byte(name), 0o644))
+		apps = append(apps, newTestJobApp(t, name, src, dst, out, func(_ context.Context, quit <-chan struct{}, jobs events.Queue) error {
+			jobs <- &events.Change{Path: file, Ops: events.CREATE}
+			<-quit
+			return nil
+		}))
+	}
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		apps[0].Stop()
+		apps[1].Stop()
+	}()
+	code, err := run(apps, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+
+	jobs := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(out.Bytes()))
+	for scanner.Scan() {
+		var data map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &data))
+		job, _ := data["job"].(string)
+		path, _ := data["path"].(string)
+		assert.Contains(t, path, job)
+		jobs[job]++
+	}
+	assert.Equal(t, map[string]int{"docs": 2, "photos": 2}, jobs)
+	for _, app := range apps {
+		assert.FileExists(t, filepath.Join(app.dstFolder, app.name+".txt"+backupFileExtension))
+	}
+}
+
+func TestRun_MonitorFails(t *testing.T) {
+	folder := t.TempDir()
+	stopped := false
+	ok := newTestJobApp(t, "ok", folder, folder, io.Discard, func(_ context.Context, quit <-chan struct{}, _ events.Queue) error {
+		<-quit
+		stopped = true
+		return nil
+	})
+	ko := newTestJobApp(t, "ko", folder, folder, io.Discard, func(_ context.Context, _ <-chan struct{}, _ events.Queue) error {
+		return gorsn.ErrScanIsNotReady
+	})
+
+	code, err := run([]*App{ok, ko}, 1)
+	assert.EqualError(t, err, "failed to start files monitor: job ko: "+gorsn.ErrScanIsNotReady.Error())
+	assert.Equal(t, 1, code)
+	assert.True(t, stopped)
+	// the backup folder state is saved anyway.
+	archives, err := ok.listArchives()
+	require.NoError(t, err)
+	assert.NotEmpty(t, archives)
+}
+
+func TestValidateJobs(t *testing.T) {
+	folder := t.TempDir()
+	join := func(elem ...string) string { return filepath.Join(append([]string{folder}, elem...)...) }
+	cases := []struct {
+		name string
+		jobs []Job
+		err  string
+	}{
+		{"no job", nil, "no job to run"},
+		{"single unnamed job", []Job{{Source: join("src"), Backup: join("dst")}}, ""},
+		{"distinct jobs", []Job{
+			{Name: "a", Source: join("a", "src"), Backup: join("a", "dst")},
+			{Name: "b", Source: join("b", "src"), Backup: join("b", "dst")},
+		}, ""},
+		{"missing name", []Job{
+			{Name: "a", Source: join("a", "src"), Backup: join("a", "dst")},
+			{Source: join("b", "src"), Backup: join("b", "dst")},
+		}, "missing name of job with source " + join("b", "src")},
+		{"duplicate name", []Job{
+			{Name: "a", Source: join("a", "src"), Backup: join("a", "dst")},
+			{Name: "a", Source: join("b", "src"), Backup: join("b", "dst")},
+		}, "duplicate job name a"},
+		{"nested sources", []Job{
+			{Name: "a", Source: join("src"), Backup: join("a", "dst")},
+			{Name: "b", Source: join("src", "b"), Backup: join("b", "dst")},
+		}, "jobs a and b have overlapping source folders"},
+		{"same backup", []Job{
+			{Name: "a", Source: join("a", "src"), Backup: join("dst")},
+			{Name: "b", Source: join("b", "src"), Backup: join("dst")},
+		}, "jobs a and b have overlapping backup folders"},
+		{"backup into other source", []Job{
+			{Name: "a", Source: join("a", "src"), Backup: join("b", "src", "dst")},
+			{Name: "b", Source: join("b", "src"), Backup: join("b", "dst")},
+		}, "jobs a and b have a backup folder into the source folder of the other"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateJobs(tc.jobs)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/jeamon/gobackup/pkg/encryption"
+	"github.com/jeamon/gobackup/pkg/metadata"
+	"github.com/jeamon/gobackup/pkg/storage"
+)
+
+// manifestFile is the journal of checksums of backup files into the backup folder.
+const manifestFile = ".manifest.jsonl"
+
+// ManifestEntry describes what a backup file should contain once decoded,
+// how it is encoded and the metadata of its source file to restore along
+// its content. A journal line with `Deleted` set removes the entry of
+// that name.
+type ManifestEntry struct {
+	Name   string `json:"name"` // name of the backup file into the storage.
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size"`
+	metadata.Metadata
+	Encoding *Encoding `json:"encoding,omitempty"` // unknown for entries of previous versions.
+	Deleted  bool      `json:"deleted,omitempty"`
+}
+
+// Encoding records how the content of a backup file is stored so it is
+// decoded without guessing from its first bytes, which a plain file could
+// share with compressed or encrypted content.
+type Encoding struct {
+	Compressed bool `json:"compressed,omitempty"`
+	Encrypted  bool `json:"encrypted,omitempty"` // compressed content is encrypted after.
+}
+
+// manifest keeps the checksums of backup files. Each change is appended
+// to the journal so nothing is lost on crash and the journal is compacted
+// when opened and closed. A nil manifest records nothing.
+type manifest struct {
+	mu      sync.Mutex
+	folder  string
+	remote  Storage            // remote storage holding a copy of the journal if set.
+	cipher  *encryption.Cipher // encrypts the remote copy if set.
+	entries map[string]ManifestEntry
+	journal *os.File
+}
+
+// openManifest loads and compacts the journal of the backup folder `folder`
+// then opens it to append changes. With a `remote` storage, the journal is
+// loaded from it when missing locally and each compacted journal is put
+// into it too, encrypted with the cipher `c` if set. Without folder, it
+// returns nil.
+func openManifest(folder string, remote Storage, c *encryption.Cipher) (*manifest, error) {
+	if folder == "" {
+		return nil, nil
+	}
+	entries, err := loadManifestFile(filepath.Join(folder, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && remote != nil {
+		if entries, err = loadRemoteManifest(remote, c); err != nil {
+			return nil, err
+		}
+	}
+	m := &manifest{folder: folder, remote: remote, cipher: c, entries: entries}
+	if err = m.compact(); err != nil {
+		return nil, err
+	}
+	m.journal, err = os.OpenFile(filepath.Join(folder, manifestFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loadManifestFile reads the journal at `path`. A missing journal is empty.
+func loadManifestFile(path string) (map[string]ManifestEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]ManifestEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadManifest(f)
+}
+
+// loadRemoteManifest reads the journal from the storage `st` and decrypts
+// it with the cipher `c` if set. A missing journal is empty.
+func loadRemoteManifest(st Storage, c *encryption.Cipher) (map[string]ManifestEntry, error) {
+	rc, err := st.Get(manifestFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]ManifestEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return loadSealedManifest(rc, c)
+}
+
+// loadSealedManifest reads the journal from `r` which left the backup
+// folder encrypted with the cipher `c` when one was set. Unlike backup
+// files, a plain journal is read even with a cipher since backups made
+// without encryption and by previous versions left it plain.
+func loadSealedManifest(r io.Reader, c *encryption.Cipher) (map[string]ManifestEntry, error) {
+	br := bufio.NewReader(r)
+	// the header of encrypted content is longer than what is peeked.
+	head, _ := br.Peek(16)
+	if !encryption.IsEncrypted(head) {
+		return loadManifest(br)
+	}
+	if c == nil {
+		return nil, encryption.ErrKeyRequired
+	}
+	dr, err := c.Decrypt(br)
+	if err != nil {
+		return nil, err
+	}
+	return loadManifest(dr)
+}
+
+// loadManifest replays the journal read from `r` where later lines of a
+// name replace the earlier ones.
+func loadManifest(r io.Reader) (map[string]ManifestEntry, error) {
+	entries := make(map[string]ManifestEntry)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid manifest at line %d: %v", line, err)
+		}
+		if e.Deleted {
+			delete(entries, e.Name)
+			continue
+		}
+		entries[e.Name] = e
+	}
+	return entries, scanner.Err()
+}
+
+// compact replaces the journal with one line per entry sorted by name
+// then puts a copy into the remote storage if any. The copy is encrypted
+// like backup files so checksums and names do not leak.
+func (m *manifest) compact() error {
+	names := make([]string, 0, len(m.entries))
+	for name := range m.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var data []byte
+	for _, name := range names {
+		line, err := json.Marshal(m.entries[name])
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := storage.WriteFileAtomic(filepath.Join(m.folder, manifestFile), data); err != nil {
+		return err
+	}
+	if m.remote == nil {
+		return nil
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if m.cipher != nil {
+		r = m.cipher.Encrypt(r)
+	}
+	return m.remote.Put(manifestFile, r)
+}
+
+// append writes the entry `e` into the journal. The caller must hold the mutex.
+func (m *manifest) append(e ManifestEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = m.journal.Write(append(line, '\n'))
+	return err
+}
+
+// record saves the checksum `sum` and size of the content of the backup
+// file `name` stored with the encoding `enc` along with the metadata `md`
+// of its source file.
+func (m *manifest) record(name string, sum *digest, enc Encoding, md metadata.Metadata) error {
+	if m == nil {
+		return nil
+	}
+	e := ManifestEntry{Name: name, SHA256: sum.String(), Size: sum.size, Metadata: md, Encoding: &enc}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[name] = e
+	return m.append(e)
+}
+
+// setMetadata replaces the metadata of the source file of the backup
+// file `name` if it has an entry.
+func (m *manifest) setMetadata(name string, md metadata.Metadata) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[name]
+	if !ok {
+		return nil
+	}
+	e.Metadata = md
+	m.entries[name] = e
+	return m.append(e)
+}
+
+// remove drops the entry of the backup file `name` if any.
+func (m *manifest) remove(name string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[name]; !ok {
+		return nil
+	}
+	delete(m.entries, name)
+	return m.append(ManifestEntry{Name: name, Deleted: true})
+}
+
+// move transfers the entry of the backup file `from` to `to` if any.
+func (m *manifest) move(from, to string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[from]
+	if !ok {
+		return nil
+	}
+	delete(m.entries, from)
+	if err := m.append(ManifestEntry{Name: from, Deleted: true}); err != nil {
+		return err
+	}
+	e.Name = to
+	m.entries[to] = e
+	return m.append(e)
+}
+
+// copy duplicates the entry of the backup file `from` as the entry of `to` if any.
+func (m *manifest) copy(from, to string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[from]
+	if !ok {
+		return nil
+	}
+	e.Name = to
+	m.entries[to] = e
+	return m.append(e)
+}
+
+// encoding returns the recorded encoding of the backup file `name`.
+// It is nil when unknown.
+func (m *manifest) encoding(name string) *Encoding {
+	e, _ := m.get(name)
+	return e.Encoding
+}
+
+// has reports whether the backup file `name` has an entry.
+func (m *manifest) has(name string) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[name]
+	return ok
+}
+
+// get returns the entry of the backup file `name` if any.
+func (m *manifest) get(name string) (ManifestEntry, bool) {
+	if m == nil {
+		return ManifestEntry{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[name]
+	return e, ok
+}
+
+// close compacts the journal then closes it.
+func (m *manifest) close() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.journal.Close()
+	if cerr := m.compact(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// digest computes the SHA-256 checksum and the size of the data written.
+type digest struct {
+	hash.Hash
+	size int64
+}
+
+// newDigest provides an empty digest.
+func newDigest() *digest {
+	return &digest{Hash: sha256.New()}
+}
+
+// Write implements io.Writer interface.
+func (d *digest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.Hash.Write(p)
+}
+
+// String returns the hex-encoded checksum.
+func (d *digest) String() string {
+	return hex.EncodeToString(d.Sum(nil))
+}
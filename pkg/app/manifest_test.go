@@ -0,0 +1,124 @@
+package app
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/encryption"
+	"github.com/jeamon/gobackup/pkg/metadata"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	folder := t.TempDir()
+	m, err := openManifest(folder, nil, nil)
+	require.NoError(t, err)
+
+	md := metadata.Metadata{Mode: 0o644, ModTime: time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)}
+	sum := newDigest()
+	sum.Write([]byte("content"))
+	require.NoError(t, m.record("a.txt.bak", sum, Encoding{Compressed: true}, md))
+	require.NoError(t, m.record("b.txt.bak", newDigest(), Encoding{}, md))
+	require.NoError(t, m.move("a.txt.bak", "docs/a.txt.bak"))
+	require.NoError(t, m.remove("b.txt.bak"))
+	require.NoError(t, m.remove("unknown.bak"))
+	md.Mode = 0o600
+	require.NoError(t, m.setMetadata("docs/a.txt.bak", md))
+	assert.True(t, m.has("docs/a.txt.bak"))
+	assert.False(t, m.has("a.txt.bak"))
+	assert.Equal(t, &Encoding{Compressed: true}, m.encoding("docs/a.txt.bak"))
+
+	// changes are appended so they survive a crash.
+	entries, err := loadManifestFile(filepath.Join(folder, manifestFile))
+	require.NoError(t, err)
+	expected := map[string]ManifestEntry{
+		"docs/a.txt.bak": {
+			Name:     "docs/a.txt.bak",
+			SHA256:   "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73",
+			Size:     7,
+			Metadata: md,
+			Encoding: &Encoding{Compressed: true},
+		},
+	}
+	assert.Equal(t, expected, entries)
+
+	require.NoError(t, m.close())
+	data, err := os.ReadFile(filepath.Join(folder, manifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	t.Run("reopen", func(t *testing.T) {
+		m, err := openManifest(folder, nil, nil)
+		require.NoError(t, err)
+		defer m.close()
+		assert.Equal(t, expected, m.entries)
+	})
+
+	t.Run("remote copy", func(t *testing.T) {
+		remote := testhelpers.NewMemoryStorage()
+		m, err := openManifest(folder, remote, nil)
+		require.NoError(t, err)
+		require.NoError(t, m.close())
+		rc, err := remote.Get(manifestFile)
+		require.NoError(t, err)
+		entries, err := loadManifest(rc)
+		rc.Close()
+		require.NoError(t, err)
+		assert.Equal(t, expected, entries)
+
+		// a new backup folder gets the journal of the remote storage.
+		m, err = openManifest(t.TempDir(), remote, nil)
+		require.NoError(t, err)
+		defer m.close()
+		assert.Equal(t, expected, m.entries)
+	})
+
+	t.Run("encrypted remote copy", func(t *testing.T) {
+		keyFile := filepath.Join(t.TempDir(), "key")
+		require.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0o600))
+		cipher, err := newCipher(keyFile, "")
+		require.NoError(t, err)
+		remote := testhelpers.NewMemoryStorage()
+		m, err := openManifest(folder, remote, cipher)
+		require.NoError(t, err)
+		require.NoError(t, m.close())
+		rc, err := remote.Get(manifestFile)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		assert.True(t, encryption.IsEncrypted(data))
+		assert.NotContains(t, string(data), "docs/a.txt.bak")
+
+		_, err = openManifest(t.TempDir(), remote, nil)
+		assert.ErrorIs(t, err, encryption.ErrKeyRequired)
+		m, err = openManifest(t.TempDir(), remote, cipher)
+		require.NoError(t, err)
+		defer m.close()
+		assert.Equal(t, expected, m.entries)
+	})
+
+	t.Run("invalid journal", func(t *testing.T) {
+		folder := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(folder, manifestFile), []byte("{}\nnot json\n"), 0o644))
+		_, err := openManifest(folder, nil, nil)
+		assert.ErrorContains(t, err, "invalid manifest at line 2")
+	})
+
+	t.Run("nil manifest", func(t *testing.T) {
+		var m *manifest
+		assert.NoError(t, m.record("a.txt.bak", newDigest(), Encoding{}, md))
+		assert.NoError(t, m.move("a.txt.bak", "b.txt.bak"))
+		assert.NoError(t, m.remove("b.txt.bak"))
+		assert.NoError(t, m.setMetadata("b.txt.bak", md))
+		assert.True(t, m.has("b.txt.bak"))
+		assert.Nil(t, m.encoding("b.txt.bak"))
+		assert.NoError(t, m.close())
+	})
+}
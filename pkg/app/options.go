@@ -0,0 +1,91 @@
+package app
+
+import (
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/storage"
+)
+
+// Options holds the optional behaviors of an App instance.
+type Options struct {
+	Versioning      bool                 // keep previous revisions of each backup file.
+	MaxRevisions    int                  // maximum revisions kept per file. Zero means no limit.
+	MaxAge          time.Duration        // maximum age of a revision. Zero means no limit.
+	Dedup           bool                 // use the content-addressable deduplicating store.
+	S3              *storage.S3Options   // upload backup files into an S3-compatible bucket.
+	SFTP            *storage.SFTPOptions // upload backup files to a remote host over SFTP.
+	UploadArchive   bool                 // upload archives into the remote storage too.
+	KeyFile         string               // file of the key to encrypt backup files with.
+	Passphrase      string               // passphrase to derive the encryption key from.
+	Compression     string               // algorithm (gzip or zstd) to compress backup files with.
+	Level           int                  // compression level. Zero means the algorithm default.
+	Extension       string               // extension of backup files. Default to `.bak`.
+	DeletePrefix    string               // prefix of file names requesting a deletion. Default to `delete_`.
+	DeleteInterval  time.Duration        // delay between checks of scheduled deletions. Default to 500ms.
+	Include         []string             // gitignore-style patterns of the only files to back up.
+	Exclude         []string             // gitignore-style patterns of files and folders not to back up.
+	Debounce        time.Duration        // quiet window to merge bursts of events of a path. Zero disables it.
+	RenameWindow    time.Duration        // delay to pair the deletion and creation of a renamed file. Zero disables it.
+	Sync            bool                 // reconcile the source and backup folders on startup.
+	SyncHash        bool                 // compare contents of files and backup files while reconciling.
+	Orphans         string               // policy (keep, flag or remove) of backup files without source file.
+	OnDelete        string               // policy (keep, trash or mirror) of backup files of deleted files.
+	TrashRetention  time.Duration        // delay before trashed backup files get deleted. Zero keeps them.
+	DeleteGrace     time.Duration        // delay before mirroring the deletion of a file into the backup.
+	SafeCopy        bool                 // copy again files modified during their copy.
+	CopyRetries     int                  // copies retried in safe copy mode. Default to 3.
+	WaitClose       time.Duration        // maximum delay to wait for writers to close a file in safe copy mode.
+	ArchiveInterval time.Duration        // delay between two archives while running. Zero disables it.
+	ArchiveCron     string               // cron expression of the times to make archives while running.
+	KeepHourly      int                  // number of last hours to keep an archive of. Without any rule, all are kept.
+	KeepDaily       int                  // number of last days to keep an archive of.
+	KeepWeekly      int                  // number of last weeks to keep an archive of.
+	ArchiveFormat   string               // format (zip, tar.gz or tar.zst) of archives. Default to zip.
+	ArchiveStream   bool                 // visit backup files one by one without keeping their list while archiving.
+}
+
+// extension returns the extension of backup files.
+func (o Options) extension() string {
+	if o.Extension == "" {
+		return backupFileExtension
+	}
+	return o.Extension
+}
+
+// deletePrefix returns the prefix of file names requesting a deletion.
+func (o Options) deletePrefix() string {
+	if o.DeletePrefix == "" {
+		return deleteRequestPrefix
+	}
+	return o.DeletePrefix
+}
+
+// deleteInterval returns the delay between checks of scheduled deletions.
+func (o Options) deleteInterval() time.Duration {
+	if o.DeleteInterval <= 0 {
+		return deleteCheckInterval
+	}
+	return o.DeleteInterval
+}
+
+// copyRetries returns the number of copies retried of a file changed meanwhile.
+func (o Options) copyRetries() int {
+	if o.CopyRetries <= 0 {
+		return copyRetries
+	}
+	return o.CopyRetries
+}
+
+// archiveFormat returns the format of archives of the backup folder.
+func (o Options) archiveFormat() string {
+	if o.ArchiveFormat == "" {
+		return archive.Zip
+	}
+	return o.ArchiveFormat
+}
+
+// retainsArchives reports whether any rule limits the archives to keep.
+func (o Options) retainsArchives() bool {
+	return o.KeepHourly > 0 || o.KeepDaily > 0 || o.KeepWeekly > 0
+}
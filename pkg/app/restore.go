@@ -0,0 +1,382 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/compression"
+	"github.com/jeamon/gobackup/pkg/encryption"
+	"github.com/jeamon/gobackup/pkg/metadata"
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/utils"
+)
+
+// RestoreOptions defines what to restore and where to restore it.
+type RestoreOptions struct {
+	From    string // backup folder or archive (zip, tar.gz or tar.zst) to restore from.
+	To      string // folder where to restore files (original source or alternate target).
+	Pattern string // relative path, folder or glob of files to restore. Empty means all.
+	DryRun  bool   // only report what would be restored.
+	Force   bool   // overwrite existing files even if they are newer.
+	// key file or passphrase to decrypt encrypted backup files.
+	KeyFile    string
+	Passphrase string
+	Extension  string // extension of backup files. Default to `.bak`.
+}
+
+// zipCreatorUnix is the creator of zip entries whose headers hold unix modes.
+const zipCreatorUnix = 3
+
+// backupEntry represents a backed up file which could be restored.
+type backupEntry struct {
+	name    string             // slash-separated path relative to the backup root without extension.
+	modTime time.Time          // last modification time of the backup file.
+	meta    *metadata.Metadata // metadata of the source file if recorded.
+	enc     *Encoding          // how the content is stored if recorded.
+	open    func() (io.ReadCloser, error)
+}
+
+// isArchive checks if a path points to an archive of any format
+// produced by `SaveAsZipFile`.
+func isArchive(path string) bool {
+	_, ok := archive.FormatOf(path)
+	return ok
+}
+
+// archiveContent holds the backup files of an archive to restore or verify.
+type archiveContent struct {
+	entries []backupEntry
+	// manifest loads the checksums manifest of the archive which is
+	// decrypted with the cipher `c` if set.
+	manifest func(c *encryption.Cipher) (map[string]ManifestEntry, error)
+	close    func() error
+}
+
+// openArchive loads the backup files with extension `ext` of the archive at
+// `path`. Zip archives are read in place. Tarballs are read in order without
+// being extracted: a first pass lists their files and keeps the manifest,
+// then entries opened in the same order are read in a second pass.
+func openArchive(path, ext string) (*archiveContent, error) {
+	format, _ := archive.FormatOf(path)
+	if !archive.IsTar(format) {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open archive: %w", err)
+		}
+		return &archiveContent{
+			entries:  loadZipEntries(&zr.Reader, ext),
+			manifest: func(c *encryption.Cipher) (map[string]ManifestEntry, error) { return loadZipManifest(&zr.Reader, c) },
+			close:    zr.Close,
+		}, nil
+	}
+
+	tr, err := archive.OpenTar(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open archive: %w", err)
+	}
+	var entries []backupEntry
+	var manifest []byte
+	err = tr.Walk(func(hdr archive.Header, r io.Reader) error {
+		if hdr.Name == manifestFile {
+			var err error
+			manifest, err = io.ReadAll(r)
+			return err
+		}
+		if name, ok := entryName(hdr.Name, ext); ok {
+			entries = append(entries, tarEntry(tr, name, hdr))
+		}
+		return nil
+	})
+	if err != nil {
+		tr.Close()
+		return nil, fmt.Errorf("cannot open archive: %w", err)
+	}
+	return &archiveContent{
+		entries: entries,
+		manifest: func(c *encryption.Cipher) (map[string]ManifestEntry, error) {
+			if manifest == nil {
+				return nil, fmt.Errorf("missing checksums manifest %s into archive", manifestFile)
+			}
+			return loadSealedManifest(bytes.NewReader(manifest), c)
+		},
+		close: tr.Close,
+	}, nil
+}
+
+// tarEntry provides the entry of the file `name` of the tarball `tr` with the
+// mode and modification time recorded into its header `hdr`.
+func tarEntry(tr *archive.TarReader, name string, hdr archive.Header) backupEntry {
+	return backupEntry{
+		name:    name,
+		modTime: hdr.ModTime,
+		meta:    &metadata.Metadata{Mode: hdr.Mode, ModTime: hdr.ModTime},
+		open: func() (io.ReadCloser, error) {
+			r, err := tr.Open(hdr.Name)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(r), nil
+		},
+	}
+}
+
+// entryName converts the slash-separated relative path of a backup file with
+// extension `ext` into the relative path of its original file. It reports false
+// for any path that is not a backup file (revisions, trashed and foreign files) or that
+// would escape the restore folder.
+func entryName(rel, ext string) (string, bool) {
+	if !strings.HasSuffix(rel, ext) {
+		return "", false
+	}
+	name := strings.TrimSuffix(rel, ext)
+	if name == "" || strings.HasSuffix(name, "/") {
+		return "", false
+	}
+	name = path.Clean(name)
+	if name == ".." || path.IsAbs(name) || strings.HasPrefix(name, "../") || isTrashName(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// openFolderStorage provides the storage matching the layout of
+// the backup folder `root` (deduplicating store or plain copies).
+func openFolderStorage(root string) Storage {
+	if storage.IsDedup(root) {
+		return storage.NewDedup(root)
+	}
+	return storage.NewLocal(root)
+}
+
+// loadStorageEntries lists all backup files with extension `ext` from the storage `st`.
+func loadStorageEntries(st Storage, ext string) ([]backupEntry, error) {
+	var entries []backupEntry
+	names, err := st.List("")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		ename, ok := entryName(name, ext)
+		if !ok {
+			continue
+		}
+		fi, err := st.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		sname := name
+		entries = append(entries, backupEntry{
+			name:    ename,
+			modTime: fi.ModTime(),
+			open:    func() (io.ReadCloser, error) { return st.Get(sname) },
+		})
+	}
+	return entries, nil
+}
+
+// loadZipEntries lists all backup files with extension `ext` from the zip archive `zr`
+// along with the mode and modification time recorded into their headers.
+func loadZipEntries(zr *zip.Reader, ext string) []backupEntry {
+	var entries []backupEntry
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		name, ok := entryName(zf.Name, ext)
+		if !ok {
+			continue
+		}
+		entry := backupEntry{
+			name:    name,
+			modTime: zf.Modified,
+			open:    zf.Open,
+		}
+		// modes are only recorded by unix creators like our own archives.
+		if zf.CreatorVersion>>8 == zipCreatorUnix {
+			entry.meta = &metadata.Metadata{Mode: zf.Mode(), ModTime: zf.Modified}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// attachMetadata sets on each entry the metadata of its source file recorded
+// into the manifest `checksums`. Its modification time becomes the one of
+// the source file. Entries recorded without metadata are left as is.
+func attachMetadata(entries []backupEntry, checksums map[string]ManifestEntry, ext string) {
+	for i := range entries {
+		e, ok := checksums[entries[i].name+ext]
+		if !ok || e.Mode == 0 {
+			continue
+		}
+		md := e.Metadata
+		entries[i].meta = &md
+		entries[i].modTime = md.ModTime
+	}
+}
+
+// attachEncodings sets on each entry the encoding of its backup file recorded
+// into the manifest `checksums`. Entries of `archived` files are decompressed
+// when added to archives unless encrypted.
+func attachEncodings(entries []backupEntry, checksums map[string]ManifestEntry, ext string, archived bool) {
+	for i := range entries {
+		enc := checksums[entries[i].name+ext].Encoding
+		if enc != nil && archived && !enc.Encrypted {
+			enc = &Encoding{}
+		}
+		entries[i].enc = enc
+	}
+}
+
+// decodeEntries makes each entry transparently decrypted with the cipher
+// `c`, which may be nil, then decompressed on open based on its recorded
+// encoding. Entries recorded as encrypted are never read as plain ones.
+func decodeEntries(entries []backupEntry, c *encryption.Cipher) {
+	for i := range entries {
+		open, enc := entries[i].open, entries[i].enc
+		entries[i].open = func() (io.ReadCloser, error) {
+			rc, err := open()
+			if err != nil {
+				return nil, err
+			}
+			zr, err := decode(rc, enc, c)
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			return &multiCloser{zr, []io.Closer{zr, rc}}, nil
+		}
+	}
+}
+
+// decode returns a reader of the plain content read from `r` stored with
+// the encoding `enc` and decrypted with the cipher `c`, which may be nil.
+// Without recorded encoding, like for backup files of previous versions,
+// the encoding is guessed from the content.
+func decode(r io.Reader, enc *Encoding, c *encryption.Cipher) (io.ReadCloser, error) {
+	if enc == nil {
+		er, err := encryption.Open(c, r)
+		if err != nil {
+			return nil, err
+		}
+		return compression.Open(er)
+	}
+	if enc.Encrypted {
+		if c == nil {
+			return nil, encryption.ErrKeyRequired
+		}
+		var err error
+		if r, err = c.Decrypt(r); err != nil {
+			return nil, err
+		}
+	}
+	if enc.Compressed {
+		return compression.Decompress(r)
+	}
+	return io.NopCloser(r), nil
+}
+
+// multiCloser is a reader which closes all underlying readers.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes all readers and returns the first error.
+func (m *multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// matchEntry reports whether the relative path `name` is selected by the
+// `pattern`. It matches an exact file path, a glob or a whole folder.
+func matchEntry(pattern, name string) bool {
+	pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+	if pattern == "" || pattern == name {
+		return true
+	}
+	if ok, err := path.Match(pattern, name); err == nil && ok {
+		return true
+	}
+	return strings.HasPrefix(name, pattern+"/")
+}
+
+// restoreEntry writes the content of a backup entry to `target`. It refuses to
+// overwrite a file newer than the backup unless `force` is set. It returns a
+// short description of the action to report.
+func restoreEntry(entry backupEntry, target string, dryRun, force bool) (string, error) {
+	if fi, err := os.Stat(target); err == nil {
+		if fi.IsDir() {
+			return "", fmt.Errorf("target is a folder")
+		}
+		if !force && fi.ModTime().After(entry.modTime) {
+			return "skipped (target is newer)", nil
+		}
+	}
+
+	if dryRun {
+		return "would restore", nil
+	}
+
+	if err := utils.CreateFolder(filepath.Dir(target)); err != nil {
+		return "", err
+	}
+
+	r, err := entry.open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	if entry.meta != nil {
+		if err = metadata.Apply(target, *entry.meta); err != nil {
+			return "", err
+		}
+	}
+	return "restored", nil
+}
+
+// restore restores all entries matching the options and reports each
+// action into `out`. It returns the number of failures.
+func restore(out io.Writer, entries []backupEntry, opts RestoreOptions) int {
+	fails := 0
+	for _, entry := range entries {
+		if !matchEntry(opts.Pattern, entry.name) {
+			continue
+		}
+		target := filepath.Join(opts.To, filepath.FromSlash(entry.name))
+		status, err := restoreEntry(entry, target, opts.DryRun, opts.Force)
+		if err != nil {
+			fails++
+			fmt.Fprintf(out, "failed: %s: %v\n", target, err)
+			continue
+		}
+		fmt.Fprintf(out, "%s: %s\n", status, target)
+	}
+	return fails
+}
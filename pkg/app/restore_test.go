@@ -0,0 +1,344 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/compression"
+	"github.com/jeamon/gobackup/pkg/encryption"
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchEntry(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{"", "a/report.txt", true},
+		{"a/report.txt", "a/report.txt", true},
+		{"a/*.txt", "a/report.txt", true},
+		{"*.txt", "a/report.txt", false},
+		{"a", "a/report.txt", true},
+		{"a/", "a/b/report.txt", true},
+		{"ab", "a/report.txt", false},
+		{"b/report.txt", "a/report.txt", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pattern+"|"+tc.name, func(t *testing.T) {
+			got := matchEntry(tc.pattern, tc.name)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	cases := []struct {
+		rel   string
+		name  string
+		match bool
+	}{
+		{"a/report.txt.bak", "a/report.txt", true},
+		{"report.txt.bak.20230814T100000.000000000Z", "", false},
+		{"../report.txt.bak", "", false},
+		{"a/.bak", "", false},
+		{"report.txt", "", false},
+		{".trash/a/report.txt.bak", "", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.rel, func(t *testing.T) {
+			name, ok := entryName(tc.rel, backupFileExtension)
+			assert.Equal(t, tc.match, ok)
+			assert.Equal(t, tc.name, name)
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	folder, err := os.MkdirTemp("", "folder")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(filepath.Join(dst, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "a", "report.txt.bak"), []byte("report"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "notes.txt.bak"), []byte("notes"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "notes.txt.bak.20230814T100000.000000000Z"), []byte("old"), 0o644))
+
+	t.Run("whole tree from folder", func(t *testing.T) {
+		target := filepath.Join(folder, "all")
+		out := bytes.NewBuffer(nil)
+		code, err := Restore(out, RestoreOptions{From: dst, To: target})
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		data, err := os.ReadFile(filepath.Join(target, "a", "report.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "report", string(data))
+		data, err = os.ReadFile(filepath.Join(target, "notes.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "notes", string(data))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		target := filepath.Join(folder, "dryrun")
+		out := bytes.NewBuffer(nil)
+		code, err := Restore(out, RestoreOptions{From: dst, To: target, Pattern: "a/*.txt", DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		assert.NoDirExists(t, target)
+		assert.Equal(t, "would restore: "+filepath.Join(target, "a", "report.txt")+"\n", out.String())
+	})
+
+	t.Run("keep newer file unless forced", func(t *testing.T) {
+		target := filepath.Join(folder, "newer")
+		require.NoError(t, os.MkdirAll(target, 0o755))
+		tpath := filepath.Join(target, "notes.txt")
+		require.NoError(t, os.WriteFile(tpath, []byte("newer notes"), 0o644))
+		future := time.Now().Add(1 * time.Hour)
+		require.NoError(t, os.Chtimes(tpath, future, future))
+
+		out := bytes.NewBuffer(nil)
+		_, err := Restore(out, RestoreOptions{From: dst, To: target, Pattern: "notes.txt"})
+		require.NoError(t, err)
+		data, err := os.ReadFile(tpath)
+		require.NoError(t, err)
+		assert.Equal(t, "newer notes", string(data))
+
+		_, err = Restore(out, RestoreOptions{From: dst, To: target, Pattern: "notes.txt", Force: true})
+		require.NoError(t, err)
+		data, err = os.ReadFile(tpath)
+		require.NoError(t, err)
+		assert.Equal(t, "notes", string(data))
+	})
+
+	t.Run("single file from archive", func(t *testing.T) {
+		app := &App{dstFolder: dst, storage: storage.NewLocal(dst)}
+		_, _, _, zpath, err := app.save("20230814.100000.1111")
+		require.NoError(t, err)
+		target := filepath.Join(folder, "fromzip")
+		out := bytes.NewBuffer(nil)
+		code, err := Restore(out, RestoreOptions{From: zpath, To: target, Pattern: "a/report.txt"})
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		data, err := os.ReadFile(filepath.Join(target, "a", "report.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "report", string(data))
+		assert.NoFileExists(t, filepath.Join(target, "notes.txt"))
+	})
+
+	t.Run("invalid sources", func(t *testing.T) {
+		code, err := Restore(bytes.NewBuffer(nil), RestoreOptions{From: filepath.Join(folder, "noexist"), To: folder})
+		assert.Equal(t, 1, code)
+		assert.Error(t, err)
+		code, err = Restore(bytes.NewBuffer(nil), RestoreOptions{From: filepath.Join(folder, "noexist.zip"), To: folder})
+		assert.Equal(t, 1, code)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadZipEntries(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"a/report.txt.bak", "a/", "../evil.bak", "report.txt.bak.20230814T100000.000000000Z"} {
+		_, err := zw.Create(name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	entries := loadZipEntries(zr, backupFileExtension)
+	require.Equal(t, 1, len(entries))
+	assert.Equal(t, "a/report.txt", entries[0].name)
+}
+
+func TestRestore_Encrypted(t *testing.T) {
+	folder, err := os.MkdirTemp("", "folder")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	src := filepath.Join(folder, "source")
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(src, 0o755))
+	require.NoError(t, os.MkdirAll(dst, 0o755))
+	keyFile := filepath.Join(folder, "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0o600))
+
+	cipher, err := newCipher(keyFile, "")
+	require.NoError(t, err)
+	checksums, err := openManifest(dst, nil, nil)
+	require.NoError(t, err)
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), cipher: cipher, manifest: checksums}
+	spath := filepath.Join(src, "secret.txt")
+	require.NoError(t, os.WriteFile(spath, []byte("top secret"), 0o644))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+	require.NoError(t, app.manifest.close())
+
+	data, err := os.ReadFile(filepath.Join(dst, "secret.txt.bak"))
+	require.NoError(t, err)
+	assert.True(t, encryption.IsEncrypted(data))
+	assert.NotContains(t, string(data), "top secret")
+	_, _, _, zpath, err := app.save("20230814.100000.1111")
+	require.NoError(t, err)
+	app.opts.ArchiveFormat = archive.TarGzip
+	_, _, _, tpath, err := app.save("20230814.100000.2222")
+	require.NoError(t, err)
+
+	for _, from := range []string{dst, zpath, tpath} {
+		t.Run(filepath.Base(from), func(t *testing.T) {
+			if from != dst {
+				// the manifest leaves the backup folder encrypted too.
+				_, err := Verify(bytes.NewBuffer(nil), VerifyOptions{From: from})
+				assert.ErrorIs(t, err, encryption.ErrKeyRequired)
+				code, err := Verify(bytes.NewBuffer(nil), VerifyOptions{From: from, KeyFile: keyFile})
+				require.NoError(t, err)
+				assert.Equal(t, 0, code)
+			}
+
+			target := filepath.Join(folder, "restored-"+filepath.Base(from))
+			out := bytes.NewBuffer(nil)
+			code, err := Restore(out, RestoreOptions{From: from, To: target})
+			assert.Equal(t, 1, code)
+			assert.Error(t, err)
+			assert.Contains(t, out.String(), encryption.ErrKeyRequired.Error())
+
+			code, err = Restore(out, RestoreOptions{From: from, To: target, KeyFile: keyFile, Force: true})
+			require.NoError(t, err)
+			assert.Equal(t, 0, code)
+			data, err := os.ReadFile(filepath.Join(target, "secret.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, "top secret", string(data))
+		})
+	}
+
+	t.Run("plain content recorded as encrypted", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "secret.txt.bak"), []byte("top secret"), 0o644))
+		target := filepath.Join(folder, "restored-plain")
+		out := bytes.NewBuffer(nil)
+		code, err := Restore(out, RestoreOptions{From: dst, To: target, KeyFile: keyFile})
+		assert.Equal(t, 1, code)
+		assert.Error(t, err)
+		assert.Contains(t, out.String(), "not encrypted content")
+		assert.NoFileExists(t, filepath.Join(target, "secret.txt"))
+	})
+}
+
+func TestRestore_Compressed(t *testing.T) {
+	folder, err := os.MkdirTemp("", "folder")
+	require.NoError(t, err)
+	defer os.RemoveAll(folder)
+	src := filepath.Join(folder, "source")
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(src, 0o755))
+	require.NoError(t, os.MkdirAll(dst, 0o755))
+	keyFile := filepath.Join(folder, "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)), 0o600))
+	content := strings.Repeat("text-heavy line\n", 1000)
+	raw := string([]byte{0x1f, 0x8b, 0x08, 0x00}) + content
+
+	compressor, err := compression.New(compression.Zstd, 3)
+	require.NoError(t, err)
+	cipher, err := newCipher(keyFile, "")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		cipher *encryption.Cipher
+	}{{"plain", nil}, {"encrypted", cipher}} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := filepath.Join(dst, tc.name)
+			require.NoError(t, os.MkdirAll(dst, 0o755))
+			checksums, err := openManifest(dst, nil, nil)
+			require.NoError(t, err)
+			app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), cipher: tc.cipher, compress: compressor, manifest: checksums}
+			// stored as is but starts like compressed content.
+			lookalike := "GOBAKCMP\x01" + content
+			files := map[string]string{"notes.txt": content, "logs.gz": raw, "packed.gz": lookalike}
+			for name, data := range files {
+				spath := filepath.Join(src, name)
+				require.NoError(t, os.WriteFile(spath, []byte(data), 0o644))
+				require.NoError(t, app.UpdateBackupFileContent(spath))
+			}
+			require.NoError(t, app.manifest.close())
+
+			data, err := os.ReadFile(filepath.Join(dst, "notes.txt.bak"))
+			require.NoError(t, err)
+			assert.Less(t, len(data), len(content)/10)
+			if tc.cipher == nil {
+				// already compressed files are stored as is.
+				data, err = os.ReadFile(filepath.Join(dst, "logs.gz.bak"))
+				require.NoError(t, err)
+				assert.Equal(t, raw, string(data))
+			}
+
+			_, _, _, zpath, err := app.save("20230814.100000.1111")
+			require.NoError(t, err)
+			if tc.cipher == nil {
+				zr, err := zip.OpenReader(zpath)
+				require.NoError(t, err)
+				defer zr.Close()
+				f, err := zr.Open("notes.txt.bak")
+				require.NoError(t, err)
+				data, err := io.ReadAll(f)
+				f.Close()
+				require.NoError(t, err)
+				assert.Equal(t, content, string(data))
+			}
+
+			for _, from := range []string{dst, zpath} {
+				target := filepath.Join(folder, "restored", tc.name, filepath.Base(from))
+				code, err := Restore(bytes.NewBuffer(nil), RestoreOptions{From: from, To: target, KeyFile: keyFile})
+				require.NoError(t, err)
+				assert.Equal(t, 0, code)
+				for name, expected := range files {
+					data, err := os.ReadFile(filepath.Join(target, name))
+					require.NoError(t, err)
+					assert.Equal(t, expected, string(data))
+				}
+			}
+		})
+	}
+}
+
+func TestRestore_Metadata(t *testing.T) {
+	folder := t.TempDir()
+	src := filepath.Join(folder, "source")
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(src, 0o755))
+	require.NoError(t, os.MkdirAll(dst, 0o755))
+	checksums, err := openManifest(dst, nil, nil)
+	require.NoError(t, err)
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), manifest: checksums}
+
+	spath := filepath.Join(src, "run.sh")
+	require.NoError(t, os.WriteFile(spath, []byte("#!/bin/sh"), 0o644))
+	require.NoError(t, os.Chmod(spath, os.ModeSetuid|0o750))
+	mtime := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(spath, mtime, mtime))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+	require.NoError(t, app.manifest.close())
+
+	// backup files keep the permissions and times without setuid bit.
+	fi, err := os.Stat(filepath.Join(dst, "run.sh.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), fi.Mode()&(os.ModePerm|os.ModeSetuid))
+	assert.True(t, mtime.Equal(fi.ModTime()))
+
+	target := filepath.Join(folder, "restored")
+	code, err := Restore(bytes.NewBuffer(nil), RestoreOptions{From: dst, To: target})
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	fi, err = os.Stat(filepath.Join(target, "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750)|os.ModeSetuid, fi.Mode()&(os.ModePerm|os.ModeSetuid))
+	assert.True(t, mtime.Equal(fi.ModTime()))
+}
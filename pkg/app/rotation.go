@@ -0,0 +1,198 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/cron"
+)
+
+// newArchiveSchedule parses the cron expression `expr` of the times to make
+// archives. It returns nil when not set. It cannot be combined with
+// an archive interval `interval`.
+func newArchiveSchedule(expr string, interval time.Duration) (*cron.Schedule, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if interval > 0 {
+		return nil, fmt.Errorf("archive interval and cron cannot be combined")
+	}
+	return cron.Parse(expr)
+}
+
+// nextArchive returns the time of the next scheduled archive after
+// `now`. It returns the zero time if archives are not scheduled.
+func (app *App) nextArchive(now time.Time) time.Time {
+	switch {
+	case app.archiveCron != nil:
+		return app.archiveCron.Next(now)
+	case app.opts.ArchiveInterval > 0:
+		return now.Add(app.opts.ArchiveInterval)
+	}
+	return time.Time{}
+}
+
+// startArchiveWorker saves the backup folder state into an archive at
+// each scheduled time if any and on each signal received on `trigger`
+// like SIGUSR1 so a crash does not lose all archives.
+func (app *App) startArchiveWorker(trigger chan os.Signal) {
+	if len(archiveSignals) > 0 {
+		signal.Notify(trigger, archiveSignals...)
+	}
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		defer signal.Stop(trigger)
+		next := app.nextArchive(time.Now())
+		for {
+			var due <-chan time.Time
+			if !next.IsZero() {
+				due = time.After(time.Until(next))
+			}
+			select {
+			case <-due:
+				_ = app.saveArchive(time.Now())
+				next = app.nextArchive(time.Now())
+			case <-trigger:
+				_ = app.saveArchive(time.Now())
+			case <-app.stop:
+				log.Println("stopped archive worker")
+				return
+			}
+		}
+	}()
+}
+
+// saveArchive saves the backup folder state into an archive then
+// removes the archives no longer retained once it succeeded.
+func (app *App) saveArchive(now time.Time) error {
+	if err := app.SaveAsZipFile(now.UTC()); err != nil {
+		return err
+	}
+	app.pruneArchives()
+	return nil
+}
+
+// archiveFile is an archive of the backup folder.
+type archiveFile struct {
+	path string
+	at   time.Time // creation time from its ID.
+}
+
+// listArchives returns the archives of the backup folder of any format
+// named like `<backup>.<zipID>.<format>` from the newest to the oldest.
+func (app *App) listArchives() ([]archiveFile, error) {
+	dir, base := filepath.Dir(app.dstFolder), filepath.Base(app.dstFolder)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var archives []archiveFile
+	for _, e := range entries {
+		id, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok || e.IsDir() {
+			continue
+		}
+		format, ok := archive.FormatOf(id)
+		if !ok {
+			continue
+		}
+		if id, ok = strings.CutSuffix(id, archive.Extension(format)); !ok {
+			continue
+		}
+		if at, ok := parseZipID(id); ok {
+			archives = append(archives, archiveFile{path: filepath.Join(dir, e.Name()), at: at})
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].at.After(archives[j].at)
+	})
+	return archives, nil
+}
+
+// parseZipID returns the datetime of the archive ID `id` built by `getZipID`
+// with the `-<n>` suffix of archives made within a same second if any. The
+// suffix is added as nanoseconds so these archives keep their order.
+func parseZipID(id string) (time.Time, bool) {
+	i := strings.LastIndexByte(id, '.')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	pid, n := id[i+1:], 0
+	if j := strings.IndexByte(pid, '-'); j >= 0 {
+		var err error
+		if n, err = strconv.Atoi(pid[j+1:]); err != nil || n < 2 {
+			return time.Time{}, false
+		}
+		pid = pid[:j]
+	}
+	if _, err := strconv.Atoi(pid); err != nil {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(zipTimeLayout, id[:i])
+	return at.Add(time.Duration(n)), err == nil
+}
+
+// retainedArchives returns the paths of `archives`, sorted from the newest,
+// to keep. The newest archive of each of the last `KeepHourly` hours,
+// `KeepDaily` days and `KeepWeekly` weeks having archives is retained.
+func retainedArchives(archives []archiveFile, opts Options) map[string]bool {
+	rules := []struct {
+		count  int
+		bucket func(time.Time) string
+	}{
+		{opts.KeepHourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{opts.KeepDaily, func(t time.Time) string { return t.Format("20060102") }},
+		{opts.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+	}
+	retained := make(map[string]bool)
+	for _, r := range rules {
+		last := ""
+		for _, a := range archives {
+			if r.count <= 0 {
+				break
+			}
+			if b := r.bucket(a.at); b != last {
+				retained[a.path] = true
+				last = b
+				r.count--
+			}
+		}
+	}
+	return retained
+}
+
+// pruneArchives removes the archives of the backup folder which
+// are not retained. All archives are kept without retention rule.
+func (app *App) pruneArchives() {
+	if !app.opts.retainsArchives() {
+		return
+	}
+	archives, err := app.listArchives()
+	if err != nil {
+		app.log.Error("failed: load archive files", PRUNE, app.dstFolder, err)
+		return
+	}
+	retained := retainedArchives(archives, app.opts)
+	for _, a := range archives {
+		if retained[a.path] {
+			continue
+		}
+		if err := os.Remove(a.path); err != nil {
+			app.log.Error("failed: remove archive file", PRUNE, a.path, err)
+			continue
+		}
+		app.log.Info("success: remove archive file", PRUNE, a.path)
+	}
+}
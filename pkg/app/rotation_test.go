@@ -0,0 +1,176 @@
+package app
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewArchiveSchedule(t *testing.T) {
+	s, err := newArchiveSchedule("", time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = newArchiveSchedule("@daily", 0)
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	app := &App{archiveCron: s}
+	now := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2023, 8, 15, 0, 0, 0, 0, time.UTC), app.nextArchive(now))
+
+	_, err = newArchiveSchedule("@daily", time.Hour)
+	assert.EqualError(t, err, "archive interval and cron cannot be combined")
+	_, err = newArchiveSchedule("61 * * * *", 0)
+	assert.Error(t, err)
+}
+
+func TestNextArchive(t *testing.T) {
+	now := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	app := &App{}
+	assert.True(t, app.nextArchive(now).IsZero())
+	app.opts.ArchiveInterval = 30 * time.Minute
+	assert.Equal(t, now.Add(30*time.Minute), app.nextArchive(now))
+}
+
+func TestParseZipID(t *testing.T) {
+	at, ok := parseZipID("20230814.100000.1111")
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC), at)
+	next, ok := parseZipID("20230814.100000.1111-2")
+	require.True(t, ok)
+	assert.True(t, next.After(at))
+	assert.Equal(t, at.Truncate(time.Second), next.Truncate(time.Second))
+	for _, id := range []string{"20230814.100000", "20230814.100000.pid", "v2.20230814.100000.1111", "latest", "20230814.100000.1111-", "20230814.100000.1111-1", "20230814.100000.1111-x"} {
+		_, ok := parseZipID(id)
+		assert.False(t, ok, id)
+	}
+}
+
+func TestRetainedArchives(t *testing.T) {
+	at := func(s string) archiveFile {
+		ts, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return archiveFile{path: s, at: ts}
+	}
+	// from the newest to the oldest.
+	archives := []archiveFile{
+		at("2023-08-14T10:30:00Z"),
+		at("2023-08-14T10:00:00Z"),
+		at("2023-08-14T09:00:00Z"),
+		at("2023-08-14T08:00:00Z"),
+		at("2023-08-13T23:00:00Z"),
+		at("2023-08-13T12:00:00Z"),
+		at("2023-08-12T12:00:00Z"),
+		at("2023-08-06T12:00:00Z"),
+		at("2023-07-30T12:00:00Z"),
+	}
+	paths := func(m map[string]bool) []string {
+		var got []string
+		for _, a := range archives {
+			if m[a.path] {
+				got = append(got, a.path)
+			}
+		}
+		return got
+	}
+
+	got := retainedArchives(archives, Options{KeepHourly: 3})
+	assert.Equal(t, []string{"2023-08-14T10:30:00Z", "2023-08-14T09:00:00Z", "2023-08-14T08:00:00Z"}, paths(got))
+
+	got = retainedArchives(archives, Options{KeepDaily: 2})
+	assert.Equal(t, []string{"2023-08-14T10:30:00Z", "2023-08-13T23:00:00Z"}, paths(got))
+
+	// 2023-08-14 is a monday so the 13th belongs to the previous week.
+	got = retainedArchives(archives, Options{KeepWeekly: 3})
+	assert.Equal(t, []string{"2023-08-14T10:30:00Z", "2023-08-13T23:00:00Z", "2023-08-06T12:00:00Z"}, paths(got))
+
+	got = retainedArchives(archives, Options{KeepHourly: 1, KeepDaily: 3, KeepWeekly: 10})
+	assert.Equal(t, []string{
+		"2023-08-14T10:30:00Z", "2023-08-13T23:00:00Z", "2023-08-12T12:00:00Z",
+		"2023-08-06T12:00:00Z", "2023-07-30T12:00:00Z",
+	}, paths(got))
+}
+
+func TestPruneArchives(t *testing.T) {
+	folder := t.TempDir()
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.Mkdir(dst, 0o755))
+	names := []string{
+		"backup.20230814.103000.1111.zip",
+		"backup.20230814.100000.1111.tar.gz",
+		"backup.20230813.120000.2222.tar.zst",
+		"backup.20230812.120000.2222.zip",
+	}
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join(folder, name), nil, 0o644))
+	}
+	// not archives of the backup folder.
+	others := []string{"backup.v2.20230801.120000.1111.zip", "backup.20230801.120000.1111.tar", "notes.zip"}
+	for _, name := range others {
+		require.NoError(t, os.WriteFile(filepath.Join(folder, name), nil, 0o644))
+	}
+
+	app := New(0, 1111, "", dst, nil, nil, testhelpers.NewTestLogger(t, io.Discard))
+	app.pruneArchives()
+	// all archives are kept without retention rule.
+	for _, name := range append(names, others...) {
+		assert.FileExists(t, filepath.Join(folder, name))
+	}
+
+	app.opts.KeepDaily = 2
+	app.pruneArchives()
+	for _, name := range []string{names[0], names[2]} {
+		assert.FileExists(t, filepath.Join(folder, name))
+	}
+	for _, name := range []string{names[1], names[3]} {
+		assert.NoFileExists(t, filepath.Join(folder, name))
+	}
+	for _, name := range others {
+		assert.FileExists(t, filepath.Join(folder, name))
+	}
+}
+
+func TestStartArchiveWorker(t *testing.T) {
+	folder := t.TempDir()
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.Mkdir(dst, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "report.txt.bak"), []byte("report"), 0o644))
+
+	zips := func() []string {
+		matches, err := filepath.Glob(filepath.Join(folder, "backup.*.zip"))
+		require.NoError(t, err)
+		return matches
+	}
+
+	t.Run("on demand", func(t *testing.T) {
+		app := New(0, 1111, "", dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+		trigger := make(chan os.Signal, 1)
+		app.startArchiveWorker(trigger)
+		trigger <- os.Interrupt
+		assert.Eventually(t, func() bool { return len(zips()) == 1 }, 2*time.Second, 10*time.Millisecond)
+		close(app.stop)
+		app.wg.Wait()
+		for _, zip := range zips() {
+			require.NoError(t, os.Remove(zip))
+		}
+	})
+
+	t.Run("scheduled", func(t *testing.T) {
+		app := New(0, 2222, "", dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+		app.opts.ArchiveInterval = 100 * time.Millisecond
+		// only the newest archive of the hour is retained.
+		app.opts.KeepHourly = 1
+		app.startArchiveWorker(make(chan os.Signal, 1))
+		time.Sleep(1500 * time.Millisecond)
+		close(app.stop)
+		app.wg.Wait()
+		assert.Len(t, zips(), 1)
+	})
+}
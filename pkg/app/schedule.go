@@ -0,0 +1,231 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/utils"
+)
+
+// scheduleFile is the journal of scheduled deletions into the backup folder.
+const scheduleFile = ".schedule.json"
+
+// scheduleLockFile is locked while the journal is loaded, changed and saved
+// so the monitor and the schedule command never overwrite each other.
+const scheduleLockFile = ".schedule.lock"
+
+// ScheduledDeletion is a file to delete once its time is reached.
+type ScheduledDeletion struct {
+	Path string    `json:"path"`
+	At   time.Time `json:"at"`
+}
+
+// readSchedule returns the content of the journal of scheduled deletions
+// of the backup folder `folder`. A missing journal is empty.
+func readSchedule(folder string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(folder, scheduleFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// parseSchedule decodes the journal content `data`. An empty
+// journal means nothing is scheduled.
+func parseSchedule(data []byte) ([]ScheduledDeletion, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var entries []ScheduledDeletion
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid schedule journal: %v", err)
+	}
+	return entries, nil
+}
+
+// loadSchedule reads the journal of scheduled deletions of the backup
+// folder `folder`. A missing journal means nothing is scheduled.
+func loadSchedule(folder string) ([]ScheduledDeletion, error) {
+	data, err := readSchedule(folder)
+	if err != nil {
+		return nil, err
+	}
+	return parseSchedule(data)
+}
+
+// lockSchedule waits for the lock of the journal of the backup folder
+// `folder`. It returns the function releasing it.
+func lockSchedule(folder string) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(folder, scheduleLockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// lockSchedule takes the lock of the journal shared with the schedule
+// command. The caller must hold the store mutex. The journal is still
+// used if the lock could not be taken. It returns the function releasing it.
+func (app *App) lockSchedule() func() {
+	if app.dstFolder == "" {
+		return func() {}
+	}
+	unlock, err := lockSchedule(app.dstFolder)
+	if err != nil {
+		app.log.Error("failed: lock scheduled deletions", string(events.DELETE), app.dstFolder, err)
+		return func() {}
+	}
+	return unlock
+}
+
+// saveSchedule replaces the journal of the backup folder `folder` with
+// `entries` sorted by time then path. It returns the content written.
+func saveSchedule(folder string, entries []ScheduledDeletion) ([]byte, error) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].At.Equal(entries[j].At) {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].At.Before(entries[j].At)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return data, storage.WriteFileAtomic(filepath.Join(folder, scheduleFile), data)
+}
+
+// persistSchedule writes the store into the journal so scheduled deletions
+// survive a restart. The caller must hold the store mutex and the journal
+// lock since it restored the journal so changes made by the schedule
+// command are kept. Nothing is saved without backup folder.
+func (app *App) persistSchedule() {
+	if app.dstFolder == "" {
+		return
+	}
+	entries := make([]ScheduledDeletion, 0, len(app.store))
+	for path, at := range app.store {
+		entries = append(entries, ScheduledDeletion{Path: path, At: at})
+	}
+	data, err := saveSchedule(app.dstFolder, entries)
+	if err != nil {
+		app.log.Error("failed: save scheduled deletions", string(events.DELETE), app.dstFolder, err)
+		return
+	}
+	app.scheduleSum = sha256.Sum256(data)
+}
+
+// restoreSchedule loads the journal into the store when its content changed
+// since it was last written or read like on startup or after a cancellation
+// by the schedule command. Changes are found from the checksum of the content
+// since modification times may be too coarse. The caller must hold the store
+// mutex and the journal lock until the store is persisted.
+func (app *App) restoreSchedule() {
+	if app.dstFolder == "" {
+		return
+	}
+	data, err := readSchedule(app.dstFolder)
+	if err != nil {
+		app.log.Error("failed: load scheduled deletions", string(events.DELETE), app.dstFolder, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	if sum == app.scheduleSum {
+		return
+	}
+	entries, err := parseSchedule(data)
+	if err != nil {
+		app.log.Error("failed: load scheduled deletions", string(events.DELETE), app.dstFolder, err)
+		return
+	}
+	app.scheduleSum = sum
+	app.store = make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		app.store[e.Path] = e.At
+	}
+}
+
+// ListSchedule displays into `out` the deletions scheduled into the
+// journal of the backup folder `folder` ordered by time.
+func ListSchedule(out io.Writer, folder string) (int, error) {
+	if !utils.IsDirPath(folder) {
+		return 1, fmt.Errorf("invalid backup folder path. run --help for usage")
+	}
+	entries, err := loadSchedule(folder)
+	if err != nil {
+		return 1, err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "no scheduled deletion")
+		return 0, nil
+	}
+	for _, e := range entries {
+		fmt.Fprintf(out, "%s %s\n", e.At.Format(time.RFC3339), e.Path)
+	}
+	return 0, nil
+}
+
+// CancelSchedule removes from the journal of the backup folder `folder` the
+// deletions of the file `path` and/or those scheduled at the time `at`. The
+// monitor watching that folder reloads the journal. Each cancellation is
+// reported into `out`.
+func CancelSchedule(out io.Writer, folder, path, at string) (int, error) {
+	if !utils.IsDirPath(folder) {
+		return 1, fmt.Errorf("invalid backup folder path. run --help for usage")
+	}
+	if path == "" && at == "" {
+		return 1, fmt.Errorf("missing path or time of the deletions to cancel")
+	}
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	var when time.Time
+	if at != "" {
+		var err error
+		if when, err = time.Parse(time.RFC3339, at); err != nil {
+			return 1, fmt.Errorf("invalid time: %v", err)
+		}
+	}
+	unlock, err := lockSchedule(folder)
+	if err != nil {
+		return 1, fmt.Errorf("failed to lock scheduled deletions: %v", err)
+	}
+	defer unlock()
+	entries, err := loadSchedule(folder)
+	if err != nil {
+		return 1, err
+	}
+
+	kept := make([]ScheduledDeletion, 0, len(entries))
+	for _, e := range entries {
+		if (path == "" || e.Path == path) && (at == "" || e.At.Equal(when)) {
+			fmt.Fprintf(out, "canceled: %s %s\n", e.At.Format(time.RFC3339), e.Path)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == len(entries) {
+		return 1, fmt.Errorf("no matching scheduled deletion")
+	}
+	if _, err = saveSchedule(folder, kept); err != nil {
+		return 1, fmt.Errorf("failed to save scheduled deletions: %v", err)
+	}
+	return 0, nil
+}
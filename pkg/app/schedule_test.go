@@ -0,0 +1,138 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulePersistence(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	overdue := filepath.Join(src, "overdue.txt")
+	pending := filepath.Join(src, "pending.txt")
+	require.NoError(t, os.WriteFile(overdue, nil, 0o644))
+	require.NoError(t, os.WriteFile(pending, nil, 0o644))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+	app.ScheduleDeleteRequests(now.Add(time.Second), overdue)
+	app.ScheduleDeleteRequests(now.Add(time.Hour), pending)
+
+	entries, err := loadSchedule(dst)
+	require.NoError(t, err)
+	assert.Equal(t, []ScheduledDeletion{{Path: overdue, At: now.Add(time.Second)}, {Path: pending, At: now.Add(time.Hour)}}, entries)
+
+	// a new instance resumes the schedule and runs overdue deletions.
+	app = New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+	app.deleteDue(now.Add(time.Minute))
+	assert.NoFileExists(t, overdue)
+	assert.FileExists(t, pending)
+	assert.Equal(t, map[string]time.Time{pending: now.Add(time.Hour)}, app.store)
+
+	entries, err = loadSchedule(dst)
+	require.NoError(t, err)
+	assert.Equal(t, []ScheduledDeletion{{Path: pending, At: now.Add(time.Hour)}}, entries)
+}
+
+func TestCancelSchedule(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	at := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := filepath.Join(src, "file.txt")
+	request := filepath.Join(src, "delete_2030-01-02T03-04-05Z_file.txt")
+	other := filepath.Join(src, "other.txt")
+	_, err := saveSchedule(dst, []ScheduledDeletion{
+		{Path: file, At: at}, {Path: request, At: at}, {Path: other, At: at.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+
+	app := New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+	app.deleteDue(time.Now())
+	require.Len(t, app.store, 3)
+
+	out := bytes.NewBuffer(nil)
+	code, err := ListSchedule(out, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "2030-01-02T03:04:05Z "+request+"\n2030-01-02T03:04:05Z "+file+"\n2030-01-02T04:04:05Z "+other+"\n", out.String())
+
+	t.Run("by time", func(t *testing.T) {
+		out.Reset()
+		code, err := CancelSchedule(out, dst, "", "2030-01-02T03:04:05Z")
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "canceled: 2030-01-02T03:04:05Z "+request+"\ncanceled: 2030-01-02T03:04:05Z "+file+"\n", out.String())
+
+		// the running instance reloads the journal.
+		time.Sleep(10 * time.Millisecond)
+		app.deleteDue(time.Now())
+		assert.Equal(t, map[string]time.Time{other: at.Add(time.Hour)}, app.store)
+	})
+
+	t.Run("by path", func(t *testing.T) {
+		code, err := CancelSchedule(io.Discard, dst, other, "")
+		require.NoError(t, err)
+		assert.Equal(t, 0, code)
+		out.Reset()
+		_, err = ListSchedule(out, dst)
+		require.NoError(t, err)
+		assert.Equal(t, "no scheduled deletion\n", out.String())
+	})
+
+	t.Run("schedule after cancel", func(t *testing.T) {
+		app.ScheduleDeleteRequests(at, other)
+		jpath := filepath.Join(dst, scheduleFile)
+		fi, err := os.Stat(jpath)
+		require.NoError(t, err)
+		_, err = CancelSchedule(io.Discard, dst, other, "")
+		require.NoError(t, err)
+		// the journal keeps its modification time on coarse filesystems.
+		require.NoError(t, os.Chtimes(jpath, fi.ModTime(), fi.ModTime()))
+
+		app.ScheduleDeleteRequests(at, file)
+		entries, err := loadSchedule(dst)
+		require.NoError(t, err)
+		assert.Equal(t, []ScheduledDeletion{{Path: file, At: at}}, entries)
+	})
+
+	t.Run("locked journal", func(t *testing.T) {
+		app.ScheduleDeleteRequests(at, other)
+		unlock, err := lockSchedule(dst)
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := CancelSchedule(io.Discard, dst, other, "")
+			done <- err
+		}()
+
+		// the cancellation waits for the monitor to save the journal.
+		select {
+		case <-done:
+			t.Fatal("journal changed while locked")
+		case <-time.After(100 * time.Millisecond):
+		}
+		unlock()
+		require.NoError(t, <-done)
+		entries, err := loadSchedule(dst)
+		require.NoError(t, err)
+		assert.Equal(t, []ScheduledDeletion{{Path: file, At: at}}, entries)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := CancelSchedule(io.Discard, dst, other, "")
+		assert.EqualError(t, err, "no matching scheduled deletion")
+		_, err = CancelSchedule(io.Discard, dst, "", "")
+		assert.EqualError(t, err, "missing path or time of the deletions to cancel")
+		_, err = CancelSchedule(io.Discard, dst, "", "tomorrow")
+		assert.Error(t, err)
+		_, err = ListSchedule(io.Discard, filepath.Join(dst, "noexist"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import (
+	"os"
+	"syscall"
+)
+
+// archiveSignals are the signals requesting an archive of the backup folder.
+var archiveSignals = []os.Signal{syscall.SIGUSR1}
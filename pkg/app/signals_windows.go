@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package app
+
+import "os"
+
+// archiveSignals is empty since no signal could request an archive on windows.
+var archiveSignals []os.Signal
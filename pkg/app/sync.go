@@ -0,0 +1,235 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/jeamon/gobackup/pkg/events"
+)
+
+// defines ops name for the startup reconciliation.
+const SYNC string = "SYNC"
+
+// policies applied to backup files whose source file no longer exists.
+const (
+	OrphansKeep   = "keep"   // only report them.
+	OrphansFlag   = "flag"   // rename them with the orphanSuffix.
+	OrphansRemove = "remove" // delete them. Their revisions are kept.
+)
+
+// orphanSuffix is appended to the name of flagged orphan backup files
+// so they are no longer considered as backup files nor restored.
+const orphanSuffix = ".orphan"
+
+// checkOrphans ensures `policy` is a known orphans policy.
+func checkOrphans(policy string) error {
+	switch policy {
+	case "", OrphansKeep, OrphansFlag, OrphansRemove:
+		return nil
+	}
+	return fmt.Errorf("invalid orphans policy %q: expected keep, flag or remove", policy)
+}
+
+// startSync reconciles in background the source and backup folders when
+// enabled. It returns a channel closed once the reconciliation is done.
+func (app *App) startSync() <-chan struct{} {
+	done := make(chan struct{})
+	if !app.opts.Sync {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		app.reconcile()
+	}()
+	return done
+}
+
+// reconcile walks the source folder and queues a MODIFY event for each file
+// whose backup file is missing or stale so workers copy it like any change.
+// Then it applies the orphans policy to backup files without source file.
+func (app *App) reconcile() {
+	stale, err := app.syncSource()
+	if err != nil {
+		app.log.Error("failed: sync source folder", SYNC, app.srcFolder, err)
+	}
+	orphans, err := app.syncOrphans()
+	if err != nil {
+		app.log.Error("failed: sync backup folder", SYNC, app.dstFolder, err)
+	}
+	app.log.Info(fmt.Sprintf("success: sync folders [stale/orphans: %d/%d]", stale, orphans), SYNC, app.srcFolder)
+}
+
+// syncSource queues the files of the source folder which need a backup
+// and returns their number. It stops early once the App is stopped.
+func (app *App) syncSource() (int, error) {
+	_, folders := app.storage.(folderCreator)
+	count := 0
+	err := filepath.WalkDir(app.srcFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-app.stop:
+			return filepath.SkipAll
+		default:
+		}
+		if path == app.srcFolder {
+			return nil
+		}
+		if d.IsDir() {
+			if app.rules.Ignored(path, true) {
+				return filepath.SkipDir
+			}
+			if folders {
+				app.syncFolder(path)
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || app.IsImmediateDelete(filepath.Base(path)) || app.rules.Ignored(path, false) {
+			return nil
+		}
+		if app.syncFile(path) {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// syncFolder queues the creation of the backup folder of `path` if missing.
+func (app *App) syncFolder(path string) {
+	if _, err := app.storage.Stat(filepath.ToSlash(app.relativePath(path))); err == nil {
+		return
+	}
+	app.jobs <- &events.Change{Path: path, Ops: events.CREATE}
+}
+
+// syncFile queues the backup of the file `path` if it is missing or stale.
+func (app *App) syncFile(path string) bool {
+	stale, err := app.isStale(path)
+	if err != nil {
+		app.log.Error("failed: check backup file", SYNC, path, err)
+		return false
+	}
+	if !stale {
+		return false
+	}
+	app.jobs <- &events.Change{Path: path, Ops: events.MODIFY}
+	return true
+}
+
+// isStale reports whether the backup file of `path` is missing, has no
+// checksum into the manifest or is older than the file. The size and the
+// modification time of the file are compared with the ones recorded into
+// the manifest whatever the encoding of the backup file, or without
+// manifest the sizes of plain backup files. Contents are compared when
+// hashing is enabled.
+func (app *App) isStale(path string) (bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	name := app.backupName(path)
+	bfi, err := app.storage.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !app.manifest.has(name) || bfi.ModTime().Before(fi.ModTime()) {
+		return true, nil
+	}
+	if e, ok := app.manifest.get(name); ok {
+		if e.Size != fi.Size() || (!e.ModTime.IsZero() && !e.ModTime.Equal(fi.ModTime())) {
+			return true, nil
+		}
+	} else if app.encodingOf(path) == (Encoding{}) && bfi.Size() != fi.Size() {
+		return true, nil
+	}
+	if !app.opts.SyncHash {
+		return false, nil
+	}
+	same, err := app.sameContent(path, name)
+	return !same, err
+}
+
+// sameContent compares the SHA-256 checksums of the file `path`
+// and of the decoded content of its backup file `name`.
+func (app *App) sameContent(path, name string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	want := sha256.New()
+	if _, err = io.Copy(want, f); err != nil {
+		return false, err
+	}
+
+	rc, err := app.storage.Get(name)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	zr, err := decode(rc, app.manifest.encoding(name), app.cipher)
+	if err != nil {
+		return false, err
+	}
+	defer zr.Close()
+	got := sha256.New()
+	if _, err = io.Copy(got, zr); err != nil {
+		return false, err
+	}
+	return bytes.Equal(want.Sum(nil), got.Sum(nil)), nil
+}
+
+// syncOrphans applies the orphans policy to each backup file whose source
+// file does not exist anymore and returns their number.
+func (app *App) syncOrphans() (int, error) {
+	names, err := app.storage.List("")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, name := range names {
+		rel, ok := entryName(name, app.opts.extension())
+		if !ok {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(app.srcFolder, filepath.FromSlash(rel))); !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		count++
+		app.handleOrphan(name)
+	}
+	return count, nil
+}
+
+// handleOrphan keeps, flags or removes the orphan backup file `name`.
+func (app *App) handleOrphan(name string) {
+	location := app.backupLocation(name)
+	switch app.opts.Orphans {
+	case OrphansFlag:
+		if err := app.moveBackupFile(name, name+orphanSuffix); err != nil {
+			app.log.Error("failed: flag orphan backup file", SYNC, location, err)
+			return
+		}
+		app.log.Info("success: flag orphan backup file", SYNC, location)
+	case OrphansRemove:
+		if err := app.removeBackupFile(name); err != nil {
+			app.log.Error("failed: delete orphan backup file", SYNC, location, err)
+			return
+		}
+		app.log.Info("success: delete orphan backup file", SYNC, location)
+	default:
+		app.log.Info("ignored: keep orphan backup file", SYNC, location)
+	}
+}
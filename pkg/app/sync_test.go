@@ -0,0 +1,170 @@
+package app
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/ignore"
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSyncApp provides an App reconciling `src` into `dst`
+// with a jobs queue large enough to hold all queued events.
+func newTestSyncApp(t *testing.T, src, dst string, opts Options) *App {
+	t.Helper()
+	app := New(100, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+	rules, err := ignore.New(src, nil, []string{"*.tmp"})
+	require.NoError(t, err)
+	app.rules = rules
+	app.opts = opts
+	return app
+}
+
+// queued returns the events queued by the reconciliation sorted by path.
+func queued(app *App) []events.Change {
+	app.CloseQueue()
+	var got []events.Change
+	for ce := range app.jobs {
+		got = append(got, *ce)
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Path < got[j].Path })
+	return got
+}
+
+func TestReconcile(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	write := func(path, content string, mtime time.Time) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	past := time.Now().Add(-time.Hour)
+	write(filepath.Join(src, "missing.txt"), "missing", past)
+	write(filepath.Join(src, "docs", "older.txt"), "older", time.Now())
+	write(filepath.Join(dst, "docs", "older.txt.bak"), "older", past)
+	write(filepath.Join(src, "resized.txt"), "resized", past)
+	write(filepath.Join(dst, "resized.txt.bak"), "size", time.Now())
+	write(filepath.Join(src, "same.txt"), "same", past)
+	write(filepath.Join(dst, "same.txt.bak"), "same", time.Now())
+	write(filepath.Join(src, "changed.txt"), "changed", past)
+	write(filepath.Join(dst, "changed.txt.bak"), "chAnged", time.Now())
+	write(filepath.Join(src, "draft.tmp"), "ignored", past)
+	write(filepath.Join(src, "delete_same.txt"), "", past)
+	write(filepath.Join(dst, "gone.txt.bak"), "gone", past)
+	write(filepath.Join(dst, "gone.txt.bak.20240102T030405.000000000Z"), "revision", past)
+
+	t.Run("sizes and times", func(t *testing.T) {
+		app := newTestSyncApp(t, src, dst, Options{Sync: true})
+		<-app.startSync()
+		assert.Equal(t, []events.Change{
+			{Path: filepath.Join(src, "docs", "older.txt"), Ops: events.MODIFY},
+			{Path: filepath.Join(src, "missing.txt"), Ops: events.MODIFY},
+			{Path: filepath.Join(src, "resized.txt"), Ops: events.MODIFY},
+		}, queued(app))
+		assert.FileExists(t, filepath.Join(dst, "gone.txt.bak"))
+	})
+
+	t.Run("contents", func(t *testing.T) {
+		app := newTestSyncApp(t, src, dst, Options{Sync: true, SyncHash: true})
+		<-app.startSync()
+		assert.Equal(t, []events.Change{
+			{Path: filepath.Join(src, "changed.txt"), Ops: events.MODIFY},
+			{Path: filepath.Join(src, "docs", "older.txt"), Ops: events.MODIFY},
+			{Path: filepath.Join(src, "missing.txt"), Ops: events.MODIFY},
+			{Path: filepath.Join(src, "resized.txt"), Ops: events.MODIFY},
+		}, queued(app))
+	})
+
+	t.Run("missing folder", func(t *testing.T) {
+		require.NoError(t, os.Mkdir(filepath.Join(src, "empty"), 0o755))
+		defer os.Remove(filepath.Join(src, "empty"))
+		app := newTestSyncApp(t, src, dst, Options{Sync: true})
+		<-app.startSync()
+		assert.Contains(t, queued(app), events.Change{Path: filepath.Join(src, "empty"), Ops: events.CREATE})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		app := newTestSyncApp(t, src, dst, Options{})
+		<-app.startSync()
+		assert.Empty(t, queued(app))
+	})
+}
+
+func TestReconcile_Orphans(t *testing.T) {
+	for _, policy := range []string{OrphansKeep, OrphansFlag, OrphansRemove} {
+		t.Run(policy, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dst, "gone.txt.bak"), []byte("gone"), 0o644))
+			require.NoError(t, os.WriteFile(filepath.Join(dst, scheduleFile), []byte("[]"), 0o644))
+
+			app := newTestSyncApp(t, src, dst, Options{Sync: true, Orphans: policy})
+			count, err := app.syncOrphans()
+			require.NoError(t, err)
+			assert.Equal(t, 1, count)
+			assert.FileExists(t, filepath.Join(dst, scheduleFile))
+
+			switch policy {
+			case OrphansKeep:
+				assert.FileExists(t, filepath.Join(dst, "gone.txt.bak"))
+			case OrphansFlag:
+				assert.NoFileExists(t, filepath.Join(dst, "gone.txt.bak"))
+				assert.FileExists(t, filepath.Join(dst, "gone.txt.bak"+orphanSuffix))
+			case OrphansRemove:
+				assert.NoFileExists(t, filepath.Join(dst, "gone.txt.bak"))
+			}
+		})
+	}
+}
+
+func TestCheckOrphans(t *testing.T) {
+	for _, policy := range []string{"", OrphansKeep, OrphansFlag, OrphansRemove} {
+		assert.NoError(t, checkOrphans(policy))
+	}
+	assert.EqualError(t, checkOrphans("drop"), `invalid orphans policy "drop": expected keep, flag or remove`)
+}
+
+func TestIsStale_Encoded(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	app := newTestSyncApp(t, src, dst, Options{Sync: true})
+	compressor, err := newCompressor("gzip", 0)
+	require.NoError(t, err)
+	app.compress = compressor
+	app.manifest, err = openManifest(dst, nil, nil)
+	require.NoError(t, err)
+	defer app.manifest.close()
+
+	past := time.Now().Add(-time.Hour)
+	spath := filepath.Join(src, "file.txt")
+	require.NoError(t, os.WriteFile(spath, []byte("content"), 0o644))
+	require.NoError(t, os.Chtimes(spath, past, past))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+	stale, err := app.isStale(spath)
+	require.NoError(t, err)
+	assert.False(t, stale)
+
+	// the backup file is compressed so the recorded sizes and times are compared.
+	for _, tc := range []struct {
+		name    string
+		content string
+		mtime   time.Time
+	}{
+		{"resized", "resized content", past},
+		{"touched", "CONTENT", past.Add(-time.Minute)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(spath, []byte(tc.content), 0o644))
+			require.NoError(t, os.Chtimes(spath, tc.mtime, tc.mtime))
+			stale, err := app.isStale(spath)
+			require.NoError(t, err)
+			assert.True(t, stale)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jeamon/gobackup/pkg/encryption"
+	"github.com/jeamon/gobackup/pkg/utils"
+)
+
+// VerifyOptions defines the backup to check and how to decode it.
+type VerifyOptions struct {
+	From string // backup folder or archive (zip, tar.gz or tar.zst) to verify.
+	// key file or passphrase to decrypt encrypted backup files.
+	KeyFile    string
+	Passphrase string
+	Extension  string // extension of backup files. Default to `.bak`.
+}
+
+// verifyReport counts the problems found by a verification.
+type verifyReport struct {
+	checked, missing, extra, corrupted int
+}
+
+// Verify re-hashes each backup file of a backup folder or archive and
+// compares it with the checksums manifest. Missing, extra and corrupted
+// files are reported into `out`. Any mismatch makes it fail.
+func Verify(out io.Writer, opts VerifyOptions) (int, error) {
+	ext := Options{Extension: opts.Extension}.extension()
+	cipher, err := newCipher(opts.KeyFile, opts.Passphrase)
+	if err != nil {
+		return 1, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	var entries []backupEntry
+	var checksums map[string]ManifestEntry
+	if isArchive(opts.From) {
+		content, err := openArchive(opts.From, ext)
+		if err != nil {
+			return 1, err
+		}
+		defer content.close()
+		entries = content.entries
+		if checksums, err = content.manifest(cipher); err != nil {
+			return 1, err
+		}
+	} else {
+		if !utils.IsDirPath(opts.From) {
+			return 1, fmt.Errorf("invalid backup folder path. run --help for usage")
+		}
+		var err error
+		if entries, err = loadStorageEntries(openFolderStorage(opts.From), ext); err != nil {
+			return 1, fmt.Errorf("cannot load backup files: %w", err)
+		}
+		if checksums, err = loadFolderManifest(opts.From); err != nil {
+			return 1, err
+		}
+	}
+
+	attachEncodings(entries, checksums, ext, isArchive(opts.From))
+	decodeEntries(entries, cipher)
+
+	r := verify(out, entries, checksums, ext)
+	fmt.Fprintf(out, "checked %d file(s): %d missing, %d extra, %d corrupted\n", r.checked, r.missing, r.extra, r.corrupted)
+	if r.missing+r.extra+r.corrupted > 0 {
+		return 1, fmt.Errorf("integrity check failed")
+	}
+	return 0, nil
+}
+
+// loadFolderManifest reads the checksums manifest of the backup folder `folder`.
+func loadFolderManifest(folder string) (map[string]ManifestEntry, error) {
+	path := filepath.Join(folder, manifestFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("missing checksums manifest %s", path)
+	}
+	return loadManifestFile(path)
+}
+
+// loadZipManifest reads the checksums manifest of the zip archive `zr`
+// and decrypts it with the cipher `c` if set.
+func loadZipManifest(zr *zip.Reader, c *encryption.Cipher) (map[string]ManifestEntry, error) {
+	f, err := zr.Open(manifestFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("missing checksums manifest %s into archive", manifestFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadSealedManifest(f, c)
+}
+
+// verify compares the checksum of each entry with the manifest
+// `checksums` then reports backup files listed but not found.
+func verify(out io.Writer, entries []backupEntry, checksums map[string]ManifestEntry, ext string) verifyReport {
+	var r verifyReport
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name := entry.name + ext
+		seen[name] = true
+		r.checked++
+		want, ok := checksums[name]
+		if !ok {
+			r.extra++
+			fmt.Fprintf(out, "extra: %s\n", name)
+			continue
+		}
+		if err := checkEntry(entry, want); err != nil {
+			r.corrupted++
+			fmt.Fprintf(out, "corrupted: %s: %v\n", name, err)
+		}
+	}
+
+	var missing []string
+	for name := range checksums {
+		if _, ok := entryName(name, ext); ok && !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		r.missing++
+		fmt.Fprintf(out, "missing: %s\n", name)
+	}
+	return r
+}
+
+// checkEntry hashes the decoded content of the entry and
+// compares it with its manifest entry `want`.
+func checkEntry(entry backupEntry, want ManifestEntry) error {
+	rc, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	sum := newDigest()
+	if _, err = io.Copy(sum, rc); err != nil {
+		return err
+	}
+	if sum.size != want.Size {
+		return fmt.Errorf("size %d instead of %d", sum.size, want.Size)
+	}
+	if got := sum.String(); got != want.SHA256 {
+		return fmt.Errorf("checksum %s instead of %s", got, want.SHA256)
+	}
+	return nil
+}
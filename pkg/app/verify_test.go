@@ -0,0 +1,80 @@
+package app
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	folder := t.TempDir()
+	src := filepath.Join(folder, "source")
+	dst := filepath.Join(folder, "backup")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "docs"), 0o755))
+	require.NoError(t, os.MkdirAll(dst, 0o755))
+
+	compressor, err := newCompressor("gzip", 0)
+	require.NoError(t, err)
+	checksums, err := openManifest(dst, nil, nil)
+	require.NoError(t, err)
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), compress: compressor, manifest: checksums}
+	for _, name := range []string{"report.txt", "notes.txt", filepath.Join("docs", "plan.txt")} {
+		spath := filepath.Join(src, name)
+		require.NoError(t, os.WriteFile(spath, []byte("content of "+name), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+	}
+	require.NoError(t, app.manifest.close())
+	froms := []string{dst}
+	for _, format := range []string{archive.Zip, archive.TarGzip, archive.TarZstd} {
+		app.opts.ArchiveFormat = format
+		_, _, _, path, err := app.save("20230814.100000.1111")
+		require.NoError(t, err)
+		froms = append(froms, path)
+	}
+
+	for _, from := range froms {
+		t.Run("valid "+filepath.Base(from), func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			code, err := Verify(out, VerifyOptions{From: from})
+			require.NoError(t, err)
+			assert.Equal(t, 0, code)
+			assert.Equal(t, "checked 3 file(s): 0 missing, 0 extra, 0 corrupted\n", out.String())
+		})
+	}
+
+	t.Run("mismatches", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(dst, "notes.txt.bak")))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "docs", "plan.txt.bak"), []byte("altered"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dst, "extra.txt.bak"), nil, 0o644))
+
+		out := bytes.NewBuffer(nil)
+		code, err := Verify(out, VerifyOptions{From: dst})
+		assert.EqualError(t, err, "integrity check failed")
+		assert.Equal(t, 1, code)
+		expected := "corrupted: docs/plan.txt.bak: not compressed content\n" +
+			"extra: extra.txt.bak\n" +
+			"missing: notes.txt.bak\n" +
+			"checked 3 file(s): 1 missing, 1 extra, 1 corrupted\n"
+		assert.Equal(t, expected, out.String())
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		code, err := Verify(bytes.NewBuffer(nil), VerifyOptions{From: src})
+		assert.ErrorContains(t, err, "missing checksums manifest")
+		assert.Equal(t, 1, code)
+
+		require.NoError(t, os.Remove(filepath.Join(dst, manifestFile)))
+		app.opts.ArchiveFormat = archive.TarGzip
+		_, _, _, path, err := app.save("20230814.110000.1111")
+		require.NoError(t, err)
+		code, err = Verify(bytes.NewBuffer(nil), VerifyOptions{From: path})
+		assert.EqualError(t, err, "missing checksums manifest .manifest.jsonl into archive")
+		assert.Equal(t, 1, code)
+	})
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/storage"
+)
+
+const (
+	// defines ops name for revisions pruning.
+	PRUNE string = "PRUNE"
+	// format of the timestamp suffix of each revision filename.
+	revisionTimeLayout = "20060102T150405.000000000Z"
+	// interval between two runs of the revisions pruning worker.
+	pruneInterval = 1 * time.Minute
+)
+
+// revision represents a previous version of a backup file.
+type revision struct {
+	name string
+	at   time.Time
+}
+
+// revisionName builds the name of a revision of the backup file `name`
+// by appending the UTC timestamp `at` to it (`name.bak.<timestamp>`).
+func revisionName(name string, at time.Time) string {
+	return name + "." + at.UTC().Format(revisionTimeLayout)
+}
+
+// parseRevisionName checks if a name is a revision of a backup file.
+// If so, it returns the backup file name and the revision timestamp.
+func parseRevisionName(name string) (string, time.Time, bool) {
+	// timestamps have a fixed length since the layout pads each field.
+	idx := len(name) - len(revisionTimeLayout) - 1
+	if idx <= 0 || name[idx] != '.' {
+		return "", time.Time{}, false
+	}
+	bname := name[:idx]
+	at, err := time.Parse(revisionTimeLayout, name[idx+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return bname, at, true
+}
+
+// SaveRevision copies the current content of the backup file `name` into
+// a timestamped revision before it gets overwritten. The backup file stays
+// in place until then so a failed copy of the new content loses nothing.
+// Missing or empty backup files have nothing worth keeping so they are
+// ignored, like backup files of a remote host which cannot be reached so
+// the update is spooled rather than lost. It returns the name of the
+// revision saved if any.
+func (app *App) SaveRevision(name string, at time.Time) (string, error) {
+	fi, err := app.storage.Stat(name)
+	if errors.Is(err, storage.ErrUnreachable) {
+		app.log.Error("failed: save revision", string(events.MODIFY), app.backupLocation(name), err)
+		return "", nil
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	if fi.IsDir() || fi.Size() == 0 {
+		return "", nil
+	}
+	rname := revisionName(name, at)
+	if err = app.copyBackupFile(name, rname); err != nil {
+		return "", err
+	}
+	return rname, nil
+}
+
+// revisionsOf returns the names of the revisions of the backup file `name`.
+func (app *App) revisionsOf(name string) ([]string, error) {
+	names, err := app.storage.List(name + ".")
+	if err != nil {
+		return nil, err
+	}
+	var revisions []string
+	for _, rname := range names {
+		if bname, _, ok := parseRevisionName(rname); ok && bname == name {
+			revisions = append(revisions, rname)
+		}
+	}
+	return revisions, nil
+}
+
+// moveRevisions moves the revisions of the backup file `from` so they
+// become the revisions of the backup file `to`.
+func (app *App) moveRevisions(from, to string) error {
+	names, err := app.revisionsOf(from)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, at, _ := parseRevisionName(name)
+		if err = app.moveBackupFile(name, revisionName(to, at)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listRevisions loads all files from the storage and groups the revisions
+// by their backup file name. Each group is sorted from newest to oldest.
+func (app *App) listRevisions() (map[string][]revision, error) {
+	revisions := make(map[string][]revision)
+	names, err := app.storage.List("")
+	for _, name := range names {
+		if bname, at, ok := parseRevisionName(name); ok {
+			revisions[bname] = append(revisions[bname], revision{name: name, at: at})
+		}
+	}
+	for _, revs := range revisions {
+		sort.Slice(revs, func(i, j int) bool { return revs[i].at.After(revs[j].at) })
+	}
+	return revisions, err
+}
+
+// PruneRevisions deletes revisions exceeding the maximum number of revisions
+// per file or older than the maximum age compared to `now`.
+func (app *App) PruneRevisions(now time.Time) {
+	revisions, err := app.listRevisions()
+	if err != nil {
+		app.log.Error("failed: load revisions", PRUNE, app.dstFolder, err)
+		return
+	}
+
+	for _, revs := range revisions {
+		for i, rev := range revs {
+			tooMany := app.opts.MaxRevisions > 0 && i >= app.opts.MaxRevisions
+			tooOld := app.opts.MaxAge > 0 && now.Sub(rev.at) > app.opts.MaxAge
+			if !tooMany && !tooOld {
+				continue
+			}
+			if err := app.removeBackupFile(rev.name); err != nil {
+				app.log.Error("failed: delete revision", PRUNE, app.backupLocation(rev.name), err)
+				continue
+			}
+			app.log.Info("success: delete revision", PRUNE, app.backupLocation(rev.name))
+		}
+	}
+}
+
+// startPruneWorker starts a goroutine which periodically removes
+// the revisions which are no longer needed based on app options.
+func (app *App) startPruneWorker() {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		for {
+			select {
+			case <-time.After(pruneInterval):
+				app.PruneRevisions(time.Now())
+			case <-app.stop:
+				log.Println("stopped prune worker")
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,213 @@
+package app
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/storage"
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRevisionPath(t *testing.T) {
+	at, err := time.Parse(time.RFC3339Nano, "2023-08-14T10:00:00.123456789Z")
+	require.NoError(t, err)
+	dpath := "backup/report.txt.bak"
+
+	cases := []struct {
+		name  string
+		path  string
+		dpath string
+		at    time.Time
+		match bool
+	}{
+		{"revision", revisionName(dpath, at), dpath, at, true},
+		{"backup file", dpath, "", time.Time{}, false},
+		{"invalid timestamp", dpath + ".20230814", "", time.Time{}, false},
+		{"backup of a bak file", "backup/x.bak.old.bak", "", time.Time{}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, dt, ok := parseRevisionName(tc.path)
+			assert.Equal(t, tc.match, ok)
+			assert.Equal(t, tc.dpath, d)
+			assert.Equal(t, tc.at, dt)
+		})
+	}
+}
+
+func TestUpdateBackupFileContent_Versioning(t *testing.T) {
+	src, err := os.MkdirTemp("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), opts: Options{Versioning: true}}
+	spath := filepath.Join(src, "file")
+	require.NoError(t, app.CreateBackupFile(spath))
+	for _, content := range []string{"first", "second", "third"} {
+		require.NoError(t, os.WriteFile(spath, []byte(content), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "third", string(data))
+
+	revisions, err := app.listRevisions()
+	require.NoError(t, err)
+	revs := revisions["file.bak"]
+	// the empty backup file made on creation is not kept.
+	require.Equal(t, 2, len(revs))
+	data, err = os.ReadFile(filepath.Join(dst, revs[0].name))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	data, err = os.ReadFile(filepath.Join(dst, revs[1].name))
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+}
+
+func TestPruneRevisions(t *testing.T) {
+	dst, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	now := time.Now().UTC()
+	dpath := filepath.Join(dst, "file.bak")
+	var paths []string
+	for i := 1; i <= 4; i++ {
+		path := revisionName(dpath, now.Add(-time.Duration(i)*time.Hour))
+		require.NoError(t, os.WriteFile(path, []byte("content"), 0o644))
+		paths = append(paths, path)
+	}
+
+	t.Run("max revisions", func(t *testing.T) {
+		app := &App{dstFolder: dst, storage: storage.NewLocal(dst), log: testhelpers.NewTestLogger(t, io.Discard), opts: Options{MaxRevisions: 3}}
+		app.PruneRevisions(now)
+		assert.FileExists(t, paths[0])
+		assert.FileExists(t, paths[1])
+		assert.FileExists(t, paths[2])
+		assert.NoFileExists(t, paths[3])
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		app := &App{dstFolder: dst, storage: storage.NewLocal(dst), log: testhelpers.NewTestLogger(t, io.Discard), opts: Options{MaxAge: 90 * time.Minute}}
+		app.PruneRevisions(now)
+		assert.FileExists(t, paths[0])
+		assert.NoFileExists(t, paths[1])
+		assert.NoFileExists(t, paths[2])
+	})
+}
+
+func TestUpdateBackupFileContent_VersioningWithoutRename(t *testing.T) {
+	src, err := os.MkdirTemp("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	var store Storage = testhelpers.NewMemoryStorage()
+	app := &App{srcFolder: src, storage: store, opts: Options{Versioning: true}}
+	spath := filepath.Join(src, "file")
+	for _, content := range []string{"first", "second"} {
+		require.NoError(t, os.WriteFile(spath, []byte(content), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+	}
+
+	names, err := store.List("")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(names))
+	assert.Equal(t, "file.bak", names[0])
+	_, _, ok := parseRevisionName(names[1])
+	assert.Equal(t, true, ok)
+}
+
+func TestCreateBackupFile_Versioning(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), opts: Options{Versioning: true}}
+	spath := filepath.Join(src, "file")
+	require.NoError(t, os.WriteFile(spath, []byte("old precious"), 0o644))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+
+	// the file is deleted then created again.
+	require.NoError(t, os.WriteFile(spath, []byte("new"), 0o644))
+	require.NoError(t, app.CreateBackupFile(spath))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+
+	data, err := os.ReadFile(filepath.Join(dst, "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	revisions, err := app.listRevisions()
+	require.NoError(t, err)
+	revs := revisions["file.bak"]
+	require.Equal(t, 1, len(revs))
+	data, err = os.ReadFile(filepath.Join(dst, revs[0].name))
+	require.NoError(t, err)
+	assert.Equal(t, "old precious", string(data))
+}
+
+func TestUpdateBackupFileContent_VersioningFailedCopy(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	app := &App{srcFolder: src, dstFolder: dst, storage: storage.NewLocal(dst), opts: Options{Versioning: true}}
+	spath := filepath.Join(src, "file")
+	require.NoError(t, os.WriteFile(spath, []byte("content"), 0o644))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+
+	require.NoError(t, os.Remove(spath))
+	assert.Error(t, app.UpdateBackupFileContent(spath))
+
+	// the backup file is kept and no revision duplicates it.
+	data, err := os.ReadFile(filepath.Join(dst, "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+	revisions, err := app.listRevisions()
+	require.NoError(t, err)
+	assert.Empty(t, revisions["file.bak"])
+}
+
+func TestUpdateBackupFileContent_VersioningUnreachable(t *testing.T) {
+	src, dir := t.TempDir(), t.TempDir()
+	server, err := testhelpers.NewSSHServer(dir)
+	require.NoError(t, err)
+	defer server.Close()
+	root := filepath.Join(dir, "remote")
+	store, err := storage.NewSFTP(storage.SFTPOptions{
+		Address:        server.Addr,
+		User:           server.User,
+		KeyFile:        server.KeyFile,
+		KnownHostsFile: server.KnownHostsFile,
+		Root:           filepath.ToSlash(root),
+		SpoolFolder:    filepath.Join(dir, "spool"),
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer store.Close()
+
+	out := &testhelpers.SyncBuffer{}
+	app := &App{srcFolder: src, dstFolder: dir, storage: store, log: testhelpers.NewTestLogger(t, out), opts: Options{Versioning: true}}
+	spath := filepath.Join(src, "file")
+	require.NoError(t, os.WriteFile(spath, []byte("first"), 0o644))
+	require.NoError(t, app.UpdateBackupFileContent(spath))
+	require.Eventually(t, func() bool { return store.Pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// updates go on without revision while the remote host is down.
+	server.Stop()
+	for _, content := range []string{"second", "third"} {
+		require.NoError(t, os.WriteFile(spath, []byte(content), 0o644))
+		require.NoError(t, app.UpdateBackupFileContent(spath))
+	}
+	assert.Contains(t, string(out.Bytes()), "failed: save revision")
+
+	require.NoError(t, server.Start())
+	require.Eventually(t, func() bool { return store.Pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(filepath.Join(root, "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "third", string(data))
+}
@@ -8,101 +8,105 @@ import (
 	"time"
 
 	"github.com/jeamon/gobackup/pkg/events"
-	"github.com/jeamon/gobackup/pkg/utils"
+	"github.com/jeamon/gobackup/pkg/ignore"
 )
 
-// backupWorker processes each event that comes in the `jobs` queue.
-// It only handles directory or regular file associated to an event.
-// WATCH and RDELETE events do not trigger any actions. Those are added
-// to avoid linters warnings.
-func (app *App) backupWorker(id int) {
-	defer app.wg.Done()
-	for {
-		select {
-		case ce := <-app.jobs:
-			switch ce.Ops {
-			case events.CREATE:
-				fi, err := os.Stat(ce.Path)
-				if err == nil && fi.IsDir() {
-					app.ReceiveFolderEventHandler(ce.Path)
-					continue
-				}
-				if !fi.Mode().IsRegular() {
-					continue
-				}
-				if !strings.HasPrefix(filepath.Base(ce.Path), "delete_") {
-					app.CreateEventHandler(ce.Path)
-					continue
-				}
-				if spath, dpath, at, ok := app.IsScheduleDelete(ce.Path); ok {
-					app.ScheduleDeleteRequests(at, spath, dpath, ce.Path)
-					continue
-				}
-				if app.IsImmediateDelete(filepath.Base(ce.Path)) {
-					app.DeleteRequestHandler(ce.Path)
-				}
-
-			case events.MODIFY:
-				if fi, err := os.Stat(ce.Path); err != nil || fi.IsDir() || !fi.Mode().IsRegular() {
-					continue
-				}
-				if app.IsImmediateDelete(filepath.Base(ce.Path)) {
-					continue
-				}
-				app.ModifyEventHandler(ce.Path)
+// handleEvent processes an event of the source folder. It only handles
+// directory or regular file associated to an event. WATCH and RDELETE
+// events do not trigger any actions. Those are added to avoid linters
+// warnings.
+func (app *App) handleEvent(ce *events.Change) {
+	for _, path := range []string{ce.Path, ce.From} {
+		if path != "" && filepath.Base(path) == ignore.FileName {
+			app.rules.Invalidate(filepath.Dir(path))
+		}
+	}
+	switch ce.Ops {
+	case events.CREATE:
+		fi, err := os.Stat(ce.Path)
+		if err != nil {
+			return
+		}
+		if fi.IsDir() {
+			if !app.isIgnored(ce, true) {
+				app.ReceiveFolderEventHandler(ce.Path)
+			}
+			return
+		}
+		if !fi.Mode().IsRegular() {
+			return
+		}
+		if !strings.HasPrefix(filepath.Base(ce.Path), app.opts.deletePrefix()) {
+			if !app.isIgnored(ce, false) {
+				app.CreateEventHandler(ce.Path)
+			}
+			return
+		}
+		if spath, dpath, at, ok := app.IsScheduleDelete(ce.Path); ok {
+			app.ScheduleDeleteRequests(at, spath, dpath, ce.Path)
+			return
+		}
+		if app.IsImmediateDelete(filepath.Base(ce.Path)) {
+			app.DeleteRequestHandler(ce.Path)
+		}
 
-			case events.RENAME:
-				app.RenameEventHandler(ce.Path)
+	case events.MODIFY:
+		if fi, err := os.Stat(ce.Path); err != nil || fi.IsDir() || !fi.Mode().IsRegular() {
+			return
+		}
+		if app.IsImmediateDelete(filepath.Base(ce.Path)) || app.isIgnored(ce, false) {
+			return
+		}
+		app.ModifyEventHandler(ce.Path)
 
-			case events.DELETE:
-				app.DeleteEventHandler(ce.Path)
+	case events.RENAME:
+		if app.isIgnored(ce, false) {
+			if ce.From != "" {
+				app.markStale(ce.From)
+			}
+			return
+		}
+		app.RenameEventHandler(ce.From, ce.Path)
 
-			case events.ATTRIBUTE:
-				app.AttributeEventHandler(ce.Path)
+	case events.DELETE:
+		app.DeleteEventHandler(ce.Path)
 
-			case events.WATCH, events.RDELETE:
-			}
-		case <-app.stop:
-			log.Println("stopped backup worker:", id)
+	case events.ATTRIBUTE:
+		fi, err := os.Stat(ce.Path)
+		if err != nil {
+			return
+		}
+		if !fi.IsDir() && (!fi.Mode().IsRegular() || app.IsImmediateDelete(filepath.Base(ce.Path)) || app.isIgnored(ce, false)) {
 			return
 		}
+		app.AttributeEventHandler(ce.Path)
+
+	case events.WATCH, events.RDELETE:
 	}
 }
 
-// startBackupWorkers pre-boots `maxWorkers` number of workers in charge
-// of consuming tasks queued on `jobs` and process them.
-func (app *App) startBackupWorkers(maxWorkers int) {
-	for i := 0; i < maxWorkers; i++ {
-		id := i
-		app.wg.Add(1)
-		go app.backupWorker(id)
+// isIgnored reports whether the path of the event `ce` is excluded
+// by the include and exclude rules. Such events are only logged at
+// debug level to keep the log file readable.
+func (app *App) isIgnored(ce *events.Change, isDir bool) bool {
+	if !app.rules.Ignored(ce.Path, isDir) {
+		return false
 	}
+	app.log.Debug("ignored: excluded by rules", string(ce.Ops), ce.Path)
+	return true
 }
 
 // startDeleteWorker starts a goroutine which checks the App store/map
 // and deletes files which were scheduled to be removed after a given
-// datetime. The chech happens every 500 ms.
+// datetime. The chech happens every 500 ms unless configured otherwise.
 func (app *App) startDeleteWorker() {
 	app.wg.Add(1)
 	go func() {
 		defer app.wg.Done()
 		for {
 			select {
-			case <-time.After(500 * time.Millisecond):
-				app.mutex.Lock()
-				for path, t := range app.store {
-					if time.Now().Before(t) {
-						continue
-					}
-					err := utils.DeleteFile(path)
-					if err != nil {
-						app.log.Error("failed: delete file", string(events.DELETE), path, err)
-					} else {
-						app.log.Info("success: delete file", string(events.DELETE), path)
-					}
-					delete(app.store, path)
-				}
-				app.mutex.Unlock()
+			case <-time.After(app.opts.deleteInterval()):
+				app.deleteDue(time.Now())
 			case <-app.stop:
 				log.Println("stopped delete worker")
 				return
@@ -110,3 +114,30 @@ func (app *App) startDeleteWorker() {
 		}
 	}()
 }
+
+// deleteDue deletes files whose scheduled time is reached at `now`. The
+// journal is reloaded first if it changed like after a cancellation and
+// saved back once overdue deletions got removed from the store.
+func (app *App) deleteDue(now time.Time) {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	defer app.lockSchedule()()
+	app.restoreSchedule()
+	deleted := false
+	for path, t := range app.store {
+		if now.Before(t) {
+			continue
+		}
+		err := app.deleteFile(path)
+		if err != nil {
+			app.log.Error("failed: delete file", string(events.DELETE), path, err)
+		} else {
+			app.log.Info("success: delete file", string(events.DELETE), path)
+		}
+		delete(app.store, path)
+		deleted = true
+	}
+	if deleted {
+		app.persistSchedule()
+	}
+}
@@ -1,34 +1,24 @@
 package app
 
 import (
+	"bytes"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/ignore"
+	"github.com/jeamon/gobackup/pkg/logger"
+	"github.com/jeamon/gobackup/pkg/storage"
 	"github.com/jeamon/gobackup/pkg/testhelpers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestStartBackupWorkers(t *testing.T) {
-	max := 5
-	app := New(1, 0, "", "", nil, nil)
-	app.startBackupWorkers(max)
-	done := false
-	go func() {
-		app.wg.Wait()
-		done = true
-	}()
-	for i := 0; i < max; i++ {
-		app.wg.Done()
-	}
-	time.Sleep(1 * time.Millisecond)
-	assert.Equal(t, true, done)
-}
-
 func TestStartDeleteWorker(t *testing.T) {
 	folder, err := os.MkdirTemp("", "folder")
 	require.NoError(t, err)
@@ -40,7 +30,7 @@ func TestStartDeleteWorker(t *testing.T) {
 		filePath := file.Name()
 		file.Close()
 
-		app := New(0, 0, "", "", nil, testhelpers.NewTestLogger(t, io.Discard))
+		app := New(0, 0, "", "", nil, nil, testhelpers.NewTestLogger(t, io.Discard))
 		app.store[filePath] = time.Now().Add(-500 * time.Millisecond)
 		app.startDeleteWorker()
 		time.Sleep(600 * time.Millisecond)
@@ -56,7 +46,7 @@ func TestStartDeleteWorker(t *testing.T) {
 		require.NoError(t, err)
 		filePath := file.Name()
 		file.Close()
-		app := New(0, 0, "", "", nil, testhelpers.NewTestLogger(t, io.Discard))
+		app := New(0, 0, "", "", nil, nil, testhelpers.NewTestLogger(t, io.Discard))
 		app.store[filePath] = time.Now().Add(1 * time.Minute)
 		app.startDeleteWorker()
 		// making sure at least first deletion round did run.
@@ -70,7 +60,7 @@ func TestStartDeleteWorker(t *testing.T) {
 	})
 
 	t.Run("fail to delete", func(t *testing.T) {
-		app := New(0, 0, "", "", nil, testhelpers.NewTestLogger(t, io.Discard))
+		app := New(0, 0, "", "", nil, nil, testhelpers.NewTestLogger(t, io.Discard))
 		app.store["filepath.noexist"] = time.Now().Add(-500 * time.Millisecond)
 		app.startDeleteWorker()
 		// making sure at least first deletion round did run.
@@ -83,7 +73,7 @@ func TestStartDeleteWorker(t *testing.T) {
 	})
 }
 
-func TestBackupWorker(t *testing.T) {
+func TestHandleEvent(t *testing.T) {
 	folder, err := os.MkdirTemp("", "folder")
 	require.NoError(t, err)
 	defer os.RemoveAll(folder)
@@ -98,13 +88,45 @@ func TestBackupWorker(t *testing.T) {
 		require.NoError(t, err)
 		sfilePath := sfile.Name()
 		sfile.Close()
-		app := New(1, 0, src, dst, nil, testhelpers.NewTestLogger(t, io.Discard))
-		go func() {
-			app.jobs <- &events.Change{Path: sfilePath, Ops: events.CREATE}
-			close(app.stop)
-		}()
-		app.wg.Add(1)
-		app.backupWorker(1)
+		app := New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+		app.handleEvent(&events.Change{Path: sfilePath, Ops: events.CREATE})
 		assert.FileExists(t, filepath.Join(dst, filepath.Base(sfilePath)+backupFileExtension))
 	})
+
+	t.Run("event:create of removed file", func(t *testing.T) {
+		app := New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+		app.handleEvent(&events.Change{Path: filepath.Join(src, "noexist"), Ops: events.CREATE})
+		assert.NoFileExists(t, filepath.Join(dst, "noexist"+backupFileExtension))
+	})
+
+	t.Run("event:create of ignored file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(src, ignore.FileName), []byte("*.swp\n"), 0o644))
+		sfilePath := filepath.Join(src, "file.swp")
+		require.NoError(t, os.WriteFile(sfilePath, nil, 0o644))
+		folderPath := filepath.Join(src, "build")
+		require.NoError(t, os.Mkdir(folderPath, 0o755))
+
+		out := bytes.NewBuffer(nil)
+		debugLogger := &logger.DefaultLogger{Log: slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+		app := New(1, 0, src, dst, nil, storage.NewLocal(dst), debugLogger)
+		app.rules, err = ignore.New(src, nil, []string{"build/"})
+		require.NoError(t, err)
+		app.handleEvent(&events.Change{Path: sfilePath, Ops: events.CREATE})
+		app.handleEvent(&events.Change{Path: folderPath, Ops: events.CREATE})
+		assert.NoFileExists(t, filepath.Join(dst, "file.swp"+backupFileExtension))
+		assert.NoDirExists(t, filepath.Join(dst, "build"))
+		assert.Equal(t, 2, strings.Count(out.String(), `"level":"DEBUG","msg":"ignored: excluded by rules"`))
+	})
+
+	t.Run("event:modify after ignore file change", func(t *testing.T) {
+		sfilePath := filepath.Join(src, "file.swp")
+		app := New(1, 0, src, dst, nil, storage.NewLocal(dst), testhelpers.NewTestLogger(t, io.Discard))
+		app.rules, err = ignore.New(src, nil, nil)
+		require.NoError(t, err)
+		require.True(t, app.rules.Ignored(sfilePath, false))
+		require.NoError(t, os.WriteFile(filepath.Join(src, ignore.FileName), nil, 0o644))
+		app.handleEvent(&events.Change{Path: filepath.Join(src, ignore.FileName), Ops: events.MODIFY})
+		app.handleEvent(&events.Change{Path: sfilePath, Ops: events.MODIFY})
+		assert.FileExists(t, filepath.Join(dst, "file.swp"+backupFileExtension))
+	})
 }
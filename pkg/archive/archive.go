@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zip, TarGzip and TarZstd are the names of the supported formats.
+const (
+	Zip     = "zip"
+	TarGzip = "tar.gz"
+	TarZstd = "tar.zst"
+)
+
+// extensions maps the extensions of archive files to their format.
+var extensions = []struct {
+	ext    string
+	format string
+}{
+	{".zip", Zip},
+	{".tar.gz", TarGzip},
+	{".tgz", TarGzip},
+	{".tar.zst", TarZstd},
+	{".tzst", TarZstd},
+}
+
+// Header describes a file of an archive.
+type Header struct {
+	Name    string // slash-separated path into the archive.
+	Mode    fs.FileMode
+	ModTime time.Time
+	Size    int64 // size of the content or -1 if unknown.
+}
+
+// Writer adds files to an archive.
+type Writer interface {
+	// Add writes the file described by `hdr` with the content read from `r`.
+	Add(hdr Header, r io.Reader) error
+	// Close completes the archive without closing the underlying writer.
+	Close() error
+}
+
+// Check ensures `format` is a supported format.
+func Check(format string) error {
+	switch format {
+	case Zip, TarGzip, TarZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown archive format %q: expected zip, tar.gz or tar.zst", format)
+}
+
+// Extension returns the extension of archive files of `format`.
+func Extension(format string) string {
+	return "." + format
+}
+
+// FormatOf returns the format of the archive file `path` from its extension.
+func FormatOf(path string) (string, bool) {
+	lower := strings.ToLower(path)
+	for _, e := range extensions {
+		if strings.HasSuffix(lower, e.ext) {
+			return e.format, true
+		}
+	}
+	return "", false
+}
+
+// NewWriter provides a writer of an archive of `format` into `w`. Tarballs
+// need the size of each file before its content so contents of unknown size
+// are first copied into a temporary file created into the folder `tmpDir`.
+func NewWriter(format string, w io.Writer, tmpDir string) (Writer, error) {
+	switch format {
+	case Zip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	case TarGzip:
+		cw := gzip.NewWriter(w)
+		return &tarWriter{tw: tar.NewWriter(cw), cw: cw, tmpDir: tmpDir}, nil
+	case TarZstd:
+		cw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(cw), cw: cw, tmpDir: tmpDir}, nil
+	}
+	return nil, Check(format)
+}
+
+// zipWriter writes a zip archive. Unix modes are kept into the headers.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+// Add implements Writer interface.
+func (z *zipWriter) Add(hdr Header, r io.Reader) error {
+	zh := &zip.FileHeader{Name: hdr.Name, Method: zip.Deflate, Modified: hdr.ModTime}
+	zh.SetMode(hdr.Mode)
+	w, err := z.zw.CreateHeader(zh)
+	if err != nil {
+		return err
+	}
+	if hdr.Size >= 0 {
+		_, err = io.CopyN(w, r, hdr.Size)
+	} else {
+		_, err = io.Copy(w, r)
+	}
+	return err
+}
+
+// Close implements Writer interface.
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// tarWriter writes a tarball compressed by `cw`.
+type tarWriter struct {
+	tw     *tar.Writer
+	cw     io.WriteCloser
+	tmpDir string
+}
+
+// Add implements Writer interface.
+func (t *tarWriter) Add(hdr Header, r io.Reader) error {
+	if hdr.Size < 0 {
+		f, err := os.CreateTemp(t.tmpDir, ".gobackup-tar-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if hdr.Size, err = io.Copy(f, r); err != nil {
+			return err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = f
+	}
+	th := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     hdr.Name,
+		Mode:     tarMode(hdr.Mode),
+		ModTime:  hdr.ModTime,
+		Size:     hdr.Size,
+		Format:   tar.FormatPAX,
+	}
+	if err := t.tw.WriteHeader(th); err != nil {
+		return err
+	}
+	_, err := io.CopyN(t.tw, r, hdr.Size)
+	return err
+}
+
+// Close implements Writer interface.
+func (t *tarWriter) Close() error {
+	err := t.tw.Close()
+	if cerr := t.cw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tarMode converts the mode `m` into the mode bits of a tar header.
+func tarMode(m fs.FileMode) int64 {
+	mode := int64(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	return mode
+}
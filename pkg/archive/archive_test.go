@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]string{
+		"backup.20230814.100000.1111.zip":     Zip,
+		"backup.20230814.100000.1111.ZIP":     Zip,
+		"backup.20230814.100000.1111.tar.gz":  TarGzip,
+		"backup.tgz":                          TarGzip,
+		"backup.20230814.100000.1111.tar.zst": TarZstd,
+		"backup.tzst":                         TarZstd,
+	}
+	for path, want := range tests {
+		got, ok := FormatOf(path)
+		assert.True(t, ok, path)
+		assert.Equal(t, want, got, path)
+	}
+	assert.Equal(t, ".tar.zst", Extension(TarZstd))
+	for _, path := range []string{"backup", "backup.gz", "backup.tar", "backup.7z"} {
+		_, ok := FormatOf(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, format := range []string{Zip, TarGzip, TarZstd} {
+		assert.NoError(t, Check(format))
+	}
+	assert.EqualError(t, Check("7z"), `unknown archive format "7z": expected zip, tar.gz or tar.zst`)
+	_, err := NewWriter("rar", io.Discard, "")
+	assert.Error(t, err)
+}
+
+// files are the files added to the archives of the tests.
+var files = []struct {
+	hdr     Header
+	content string
+}{
+	{Header{Name: "report.txt.bak", Mode: 0o640, ModTime: time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC), Size: -1}, "report"},
+	{Header{Name: "bin/run.sh.bak", Mode: os.ModeSetuid | 0o750, ModTime: time.Date(2023, 8, 13, 9, 30, 0, 0, time.UTC), Size: 9}, "#!/bin/sh"},
+	{Header{Name: "empty.bak", Mode: 0o644, ModTime: time.Date(2023, 8, 12, 8, 0, 0, 0, time.UTC), Size: -1}, ""},
+}
+
+// writeArchive writes the test files into an archive of `format`.
+func writeArchive(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, t.TempDir())
+	require.NoError(t, err)
+	for _, f := range files {
+		require.NoError(t, w.Add(f.hdr, strings.NewReader(f.content)))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestZipWriter(t *testing.T) {
+	data := writeArchive(t, Zip)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, len(files))
+	for i, zf := range zr.File {
+		want := files[i]
+		assert.Equal(t, want.hdr.Name, zf.Name)
+		assert.Equal(t, want.hdr.Mode, zf.Mode())
+		assert.True(t, want.hdr.ModTime.Equal(zf.Modified))
+		r, err := zf.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, want.content, string(content))
+	}
+}
+
+// saveArchive writes the test files into an archive file of `format` and returns its path.
+func saveArchive(t *testing.T, format string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "backup"+Extension(format))
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func TestTarReader(t *testing.T) {
+	for _, format := range []string{TarGzip, TarZstd} {
+		t.Run(format, func(t *testing.T) {
+			tr, err := OpenTar(saveArchive(t, format, writeArchive(t, format)))
+			require.NoError(t, err)
+			defer tr.Close()
+			var headers []Header
+			err = tr.Walk(func(hdr Header, r io.Reader) error {
+				content, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, files[len(headers)].content, string(content))
+				headers = append(headers, hdr)
+				return nil
+			})
+			require.NoError(t, err)
+			require.Len(t, headers, len(files))
+			for i, hdr := range headers {
+				want := files[i]
+				assert.Equal(t, want.hdr.Name, hdr.Name)
+				assert.Equal(t, want.hdr.Mode, hdr.Mode)
+				assert.True(t, want.hdr.ModTime.Equal(hdr.ModTime))
+				assert.Equal(t, int64(len(want.content)), hdr.Size)
+			}
+
+			// files are found in any order.
+			for i := len(files) - 1; i >= 0; i-- {
+				r, err := tr.Open(files[i].hdr.Name)
+				require.NoError(t, err)
+				content, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, files[i].content, string(content))
+			}
+			_, err = tr.Open("missing.bak")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+
+	t.Run("not a tarball", func(t *testing.T) {
+		_, err := OpenTar("backup.zip")
+		assert.EqualError(t, err, `not a tarball format "zip"`)
+	})
+}
+
+func TestTarReader_Escape(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"../evil.bak", "/etc/evil.bak", "docs/../../evil.bak", "docs/ok.bak"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: 2}))
+		_, err := tw.Write([]byte("ok"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link.bak", Linkname: "/etc/passwd"}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	tr, err := OpenTar(saveArchive(t, TarGzip, buf.Bytes()))
+	require.NoError(t, err)
+	defer tr.Close()
+	var names []string
+	require.NoError(t, tr.Walk(func(hdr Header, r io.Reader) error {
+		names = append(names, hdr.Name)
+		return nil
+	}))
+	assert.Equal(t, []string{"docs/ok.bak"}, names)
+	_, err = tr.Open("link.bak")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
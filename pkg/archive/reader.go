@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// IsTar reports whether `format` is a tarball format.
+func IsTar(format string) bool {
+	return format == TarGzip || format == TarZstd
+}
+
+// TarReader reads the regular files of a tarball file without extracting
+// them. Tarballs could only be read in order so opening a file behind the
+// current position reads the tarball again from its start. Files whose name
+// would escape the folder they are restored into are skipped.
+type TarReader struct {
+	format string
+	f      *os.File
+	dec    io.Closer // decompressor of the current pass.
+	tr     *tar.Reader
+}
+
+// OpenTar opens the tarball file at `path` whose format is
+// guessed from its extension.
+func OpenTar(path string) (*TarReader, error) {
+	format, _ := FormatOf(path)
+	if !IsTar(format) {
+		return nil, fmt.Errorf("not a tarball format %q", format)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TarReader{format: format, f: f}, nil
+}
+
+// rewind starts a new pass from the beginning of the tarball.
+func (t *TarReader) rewind() error {
+	t.release()
+	if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch t.format {
+	case TarGzip:
+		gr, err := gzip.NewReader(t.f)
+		if err != nil {
+			return err
+		}
+		t.dec, t.tr = gr, tar.NewReader(gr)
+	case TarZstd:
+		zr, err := zstd.NewReader(t.f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		t.dec, t.tr = zr.IOReadCloser(), tar.NewReader(zr)
+	}
+	return nil
+}
+
+// release drops the decompressor of the current pass if any.
+func (t *TarReader) release() {
+	if t.dec != nil {
+		t.dec.Close()
+	}
+	t.dec, t.tr = nil, nil
+}
+
+// next moves to the next regular file of the current pass and returns
+// its header. It returns io.EOF at the end of the tarball.
+func (t *TarReader) next() (Header, error) {
+	for {
+		th, err := t.tr.Next()
+		if err != nil {
+			return Header{}, err
+		}
+		name := path.Clean(th.Name)
+		if th.Typeflag != tar.TypeReg || name == ".." || path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			continue
+		}
+		return Header{Name: name, Mode: th.FileInfo().Mode(), ModTime: th.ModTime, Size: th.Size}, nil
+	}
+}
+
+// Walk calls `fn` with the header and the content of each regular file
+// from the start of the tarball. The content is only readable during
+// the call.
+func (t *TarReader) Walk(fn func(hdr Header, r io.Reader) error) error {
+	if err := t.rewind(); err != nil {
+		return err
+	}
+	for {
+		hdr, err := t.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(hdr, t.tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Open returns the content of the file `name`. It is readable until
+// the next call to Open or Walk. Opening files in the order of the
+// tarball reads it once.
+func (t *TarReader) Open(name string) (io.Reader, error) {
+	restarted := t.tr == nil
+	if restarted {
+		if err := t.rewind(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		hdr, err := t.next()
+		if err == io.EOF && !restarted {
+			if err = t.rewind(); err != nil {
+				return nil, err
+			}
+			restarted = true
+			continue
+		}
+		if err == io.EOF {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if err != nil {
+			// the next call starts again from the beginning.
+			t.release()
+			return nil, err
+		}
+		if hdr.Name == name {
+			return t.tr, nil
+		}
+	}
+}
+
+// Close releases the tarball file.
+func (t *TarReader) Close() error {
+	t.release()
+	return t.f.Close()
+}
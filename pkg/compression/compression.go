@@ -0,0 +1,143 @@
+package compression
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// Gzip and Zstd are the names of the supported algorithms.
+	Gzip = "gzip"
+	Zstd = "zstd"
+
+	// magic identifies compressed content. It is followed by the algorithm id.
+	magic          = "GOBAKCMP"
+	gzipID    byte = 1
+	zstdID    byte = 2
+	headerLen      = len(magic) + 1
+)
+
+// skipExtensions lists extensions of files whose content is already
+// compressed so compressing them again would only waste time.
+var skipExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".zst": true, ".zip": true, ".7z": true, ".rar": true,
+	".xz": true, ".bz2": true, ".lz4": true, ".br": true, ".jar": true, ".apk": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".mp4": true, ".mkv": true, ".avi": true, ".mov": true, ".webm": true,
+	".ogg": true, ".flac": true, ".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true,
+}
+
+// Compressor compresses content with a given algorithm and level.
+type Compressor struct {
+	id    byte
+	level int
+}
+
+// New provides a compressor for the `algorithm` (gzip or zstd) at `level`.
+// Zero level selects the default level of the algorithm. Gzip accepts levels
+// from 1 (fastest) to 9 (best) and zstd from 1 to 22.
+func New(algorithm string, level int) (*Compressor, error) {
+	switch strings.ToLower(algorithm) {
+	case Gzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		} else if level < gzip.BestSpeed || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d: expected 1 to 9", level)
+		}
+		return &Compressor{id: gzipID, level: level}, nil
+	case Zstd:
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d: expected 1 to 22", level)
+		}
+		return &Compressor{id: zstdID, level: level}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q: expected gzip or zstd", algorithm)
+	}
+}
+
+// Skip reports whether the file `path` should be kept as is
+// because its extension denotes already compressed content.
+func Skip(path string) bool {
+	return skipExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// newWriter returns a writer compressing into `w`.
+func (c *Compressor) newWriter(w io.Writer) (io.WriteCloser, error) {
+	if c.id == gzipID {
+		return gzip.NewWriterLevel(w, c.level)
+	}
+	level := zstd.SpeedDefault
+	if c.level > 0 {
+		level = zstd.EncoderLevelFromZstd(c.level)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+}
+
+// Compress returns a reader of the content of `r` compressed and prefixed
+// by a header naming the algorithm. It must be closed once done so the
+// compressing routine stops even if the content was not read entirely.
+func (c *Compressor) Compress(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := pw.Write(append([]byte(magic), c.id)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		zw, err := c.newWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(zw, r); err != nil {
+			zw.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(zw.Close())
+	}()
+	return pr
+}
+
+// IsCompressed reports whether `header` starts like compressed content.
+func IsCompressed(header []byte) bool {
+	return len(header) >= headerLen && string(header[:len(magic)]) == magic
+}
+
+// Open returns a reader of the decompressed content of `r`. Content
+// without compression header is returned as is. It guesses from the
+// first bytes so it only suits content whose encoding was not recorded.
+func Open(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(headerLen)
+	if !IsCompressed(head) {
+		return io.NopCloser(br), nil
+	}
+	return Decompress(br)
+}
+
+// Decompress returns a reader of the decompressed content of `r` which
+// must start with a compression header.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	head := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, head); err != nil || !IsCompressed(head) {
+		return nil, fmt.Errorf("not compressed content")
+	}
+	switch head[len(magic)] {
+	case gzipID:
+		return gzip.NewReader(r)
+	case zstdID:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm id %d", head[len(magic)])
+	}
+}
@@ -0,0 +1,93 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		algorithm string
+		level     int
+		fail      bool
+	}{
+		{"gzip", 0, false},
+		{"GZIP", 9, false},
+		{"gzip", 10, true},
+		{"zstd", 0, false},
+		{"zstd", 19, false},
+		{"zstd", 23, true},
+		{"lz4", 0, true},
+	}
+
+	for _, tc := range cases {
+		_, err := New(tc.algorithm, tc.level)
+		assert.Equal(t, tc.fail, err != nil, "%s level %d", tc.algorithm, tc.level)
+	}
+}
+
+func TestCompressOpen(t *testing.T) {
+	data := strings.Repeat("text-heavy source tree content\n", 10000)
+	for _, algorithm := range []string{Gzip, Zstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			c, err := New(algorithm, 0)
+			require.NoError(t, err)
+			cr := c.Compress(strings.NewReader(data))
+			compressed, err := io.ReadAll(cr)
+			require.NoError(t, err)
+			require.NoError(t, cr.Close())
+			assert.True(t, IsCompressed(compressed))
+			assert.Less(t, len(compressed), len(data)/10)
+
+			r, err := Open(bytes.NewReader(compressed))
+			require.NoError(t, err)
+			defer r.Close()
+			plain, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, string(plain))
+
+			r, err = Decompress(bytes.NewReader(compressed))
+			require.NoError(t, err)
+			defer r.Close()
+			plain, err = io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, string(plain))
+		})
+	}
+}
+
+func TestDecompress_Plain(t *testing.T) {
+	_, err := Decompress(strings.NewReader("plain content"))
+	assert.EqualError(t, err, "not compressed content")
+}
+
+func TestOpen_Plain(t *testing.T) {
+	// raw gzip files kept as is must not be decompressed.
+	raw := []byte{0x1f, 0x8b, 0x08, 0x00, 'x'}
+	r, err := Open(bytes.NewReader(raw))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, raw, data)
+}
+
+func TestCompress_CloseEarly(t *testing.T) {
+	c, err := New(Zstd, 1)
+	require.NoError(t, err)
+	cr := c.Compress(strings.NewReader(strings.Repeat("x", 1<<20)))
+	_, err = cr.Read(make([]byte, 4))
+	require.NoError(t, err)
+	assert.NoError(t, cr.Close())
+}
+
+func TestSkip(t *testing.T) {
+	assert.True(t, Skip("photos/IMG_001.JPG"))
+	assert.True(t, Skip("logs/app.log.gz"))
+	assert.False(t, Skip("docs/report.txt"))
+	assert.False(t, Skip("Makefile"))
+}
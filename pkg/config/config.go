@@ -0,0 +1,449 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/archive"
+	"github.com/jeamon/gobackup/pkg/compression"
+	"github.com/jeamon/gobackup/pkg/cron"
+	"github.com/jeamon/gobackup/pkg/ignore"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of the monitor command. It is loaded from a YAML
+// or JSON file whose keys are the yaml tags below. Keys missing from the file
+// keep their current value so defaults can be set before loading. The job
+// settings at the top level define the single job to run or the defaults of
+// each entry of `jobs` when several jobs run into the same process.
+type Config struct {
+	LogFile string `yaml:"log_file"`
+	Workers int    `yaml:"workers"` // zero means twice the number of CPUs minus one.
+	Debug   bool   `yaml:"debug"`   // log debug level entries like ignored events.
+	Job     `yaml:",inline"`
+	Jobs    []Job `yaml:"jobs"`
+	// nodes of the jobs entries to resolve them once all defaults are set.
+	jobNodes []*yaml.Node
+}
+
+// Job holds the settings of a source folder to monitor into its backup folder.
+type Job struct {
+	Name           string        `yaml:"name"` // identifies the job into log entries.
+	Source         string        `yaml:"source"`
+	Backup         string        `yaml:"backup"`
+	Extension      string        `yaml:"extension"`       // extension of backup files like `.bak`.
+	DeletePrefix   string        `yaml:"delete_prefix"`   // prefix of file names requesting a deletion.
+	DeleteInterval time.Duration `yaml:"delete_interval"` // delay between checks of scheduled deletions.
+	Dedup          bool          `yaml:"dedup"`
+	Versioning     Versioning    `yaml:"versioning"`
+	Compression    Compression   `yaml:"compression"`
+	Encryption     Encryption    `yaml:"encryption"`
+	S3             S3            `yaml:"s3"`
+	SFTP           SFTP          `yaml:"sftp"`
+	Include        []string      `yaml:"include"`       // gitignore-style patterns of the only files to back up.
+	Exclude        []string      `yaml:"exclude"`       // gitignore-style patterns of paths not to back up.
+	Debounce       time.Duration `yaml:"debounce"`      // quiet window to merge bursts of events of a path.
+	RenameWindow   time.Duration `yaml:"rename_window"` // delay to pair the deletion and creation of a renamed file.
+	Sync           Sync          `yaml:"sync"`
+	Deletion       Deletion      `yaml:"deletion"`
+	SafeCopy       SafeCopy      `yaml:"safe_copy"`
+	Archive        Archive       `yaml:"archive"`
+}
+
+// Versioning holds the settings of revisions.
+type Versioning struct {
+	Enabled      bool          `yaml:"enabled"`
+	MaxRevisions int           `yaml:"max_revisions"`
+	MaxAge       time.Duration `yaml:"max_age"`
+}
+
+// Sync holds the settings of the reconciliation of folders on startup.
+type Sync struct {
+	Enabled bool   `yaml:"enabled"`
+	Hash    bool   `yaml:"hash"`    // compare contents besides sizes and times.
+	Orphans string `yaml:"orphans"` // keep, flag or remove backup files without source file.
+}
+
+// Deletion holds the settings of backup files of files deleted from the source.
+type Deletion struct {
+	Policy    string        `yaml:"policy"`    // keep, trash or mirror the deletion.
+	Retention time.Duration `yaml:"retention"` // delay before trashed files get deleted.
+	Grace     time.Duration `yaml:"grace"`     // delay before a deletion gets mirrored.
+}
+
+// SafeCopy holds the settings of copies of files being written.
+type SafeCopy struct {
+	Enabled   bool          `yaml:"enabled"`
+	Retries   int           `yaml:"retries"`    // copies retried of a file changed meanwhile.
+	WaitClose time.Duration `yaml:"wait_close"` // maximum delay to wait for writers to close a file.
+}
+
+// Archive holds the settings of archives made while running and
+// of the number of archives to keep.
+type Archive struct {
+	Format     string        `yaml:"format"`   // zip, tar.gz or tar.zst.
+	Stream     bool          `yaml:"stream"`   // walk the backup folder instead of listing it first.
+	Interval   time.Duration `yaml:"interval"` // delay between two archives.
+	Cron       string        `yaml:"cron"`     // cron expression of the times to make archives.
+	KeepHourly int           `yaml:"keep_hourly"`
+	KeepDaily  int           `yaml:"keep_daily"`
+	KeepWeekly int           `yaml:"keep_weekly"`
+}
+
+// Compression holds the settings of backup files compression.
+type Compression struct {
+	Algorithm string `yaml:"algorithm"`
+	Level     int    `yaml:"level"`
+}
+
+// Encryption holds the settings of backup files encryption. The
+// passphrase is never read from the file but from the environment.
+type Encryption struct {
+	Enabled bool   `yaml:"enabled"`
+	KeyFile string `yaml:"key_file"`
+}
+
+// S3 holds the settings of the S3-compatible storage. Credentials
+// are never read from the file but from the environment.
+type S3 struct {
+	Endpoint      string `yaml:"endpoint"`
+	Bucket        string `yaml:"bucket"`
+	Region        string `yaml:"region"`
+	Prefix        string `yaml:"prefix"`
+	ArchivePrefix string `yaml:"archive_prefix"`
+	PartSize      int64  `yaml:"part_size"` // in MiB.
+	UploadArchive bool   `yaml:"upload_archive"`
+}
+
+// SFTP holds the settings of the SFTP storage.
+type SFTP struct {
+	Address        string `yaml:"address"`
+	User           string `yaml:"user"`
+	KeyFile        string `yaml:"key_file"`
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	Root           string `yaml:"root"`
+	SpoolFolder    string `yaml:"spool_folder"`
+}
+
+// Error is an error located at a line of the configuration file.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Errors lists all errors found into a configuration file.
+type Errors []*Error
+
+// Error implements the error interface with one error per line.
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// linePrefix matches the location prefix of yaml decoding errors.
+var linePrefix = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// toError converts a yaml error message into a located error.
+func toError(msg string) *Error {
+	m := linePrefix.FindStringSubmatch(msg)
+	if m == nil {
+		return &Error{Msg: strings.TrimPrefix(msg, "yaml: ")}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &Error{Line: line, Msg: msg[len(m[0]):]}
+}
+
+// Load reads the configuration file at `path` into `c`. Unknown keys, values
+// of wrong type and invalid values are all reported as Errors. TOML files
+// are rejected since only YAML and its JSON subset are supported.
+func Load(path string, c *Config) error {
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		return errors.New("toml files are not supported: use a yaml or json file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return Errors{toError(err.Error())}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		var terr *yaml.TypeError
+		if !errors.As(err, &terr) {
+			return Errors{toError(err.Error())}
+		}
+		var errs Errors
+		for _, msg := range terr.Errors {
+			errs = append(errs, toError(msg))
+		}
+		return errs
+	}
+
+	c.jobNodes = nil
+	if node := keyNode(&root, "jobs"); node != nil && node.Kind == yaml.SequenceNode {
+		c.jobNodes = node.Content
+	}
+	if errs := c.check(&root); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Resolve returns the jobs to run. Each entry of `jobs` inherits the top
+// level settings it does not define. Without entries, the top level
+// settings define the single job to run.
+func (c *Config) Resolve() []Job {
+	if len(c.jobNodes) == 0 {
+		return []Job{c.Job}
+	}
+	jobs := make([]Job, 0, len(c.jobNodes))
+	for _, node := range c.jobNodes {
+		jobs = append(jobs, c.inherit(node))
+	}
+	return jobs
+}
+
+// inherit decodes the job entry `node` over the top level settings.
+func (c *Config) inherit(node *yaml.Node) Job {
+	job := c.Job
+	job.Name = ""
+	// the entry was already decoded with the whole file so it is valid.
+	_ = node.Decode(&job)
+	return job
+}
+
+// rule is a check of the value of the key located at `path`.
+type rule struct {
+	path  []string
+	check func(j *Job) string
+}
+
+// rules lists the checks of job values which are valid for their type but
+// not for gobackup. Each one returns a message describing the problem if any.
+var rules = []rule{
+	{[]string{"extension"}, func(j *Job) string {
+		valid := len(j.Extension) > 1 && strings.HasPrefix(j.Extension, ".") && !strings.ContainsAny(j.Extension, `/\`)
+		return unless(valid, "extension must start with a dot and not contain separators")
+	}},
+	{[]string{"delete_prefix"}, func(j *Job) string {
+		return unless(j.DeletePrefix != "" && !strings.ContainsAny(j.DeletePrefix, `/\`), "delete_prefix must not be empty nor contain separators")
+	}},
+	{[]string{"delete_interval"}, func(j *Job) string {
+		return unless(j.DeleteInterval > 0, "delete_interval must be positive")
+	}},
+	{[]string{"debounce"}, func(j *Job) string {
+		return unless(j.Debounce >= 0, "debounce must not be negative")
+	}},
+	{[]string{"rename_window"}, func(j *Job) string {
+		return unless(j.RenameWindow >= 0, "rename_window must not be negative")
+	}},
+	{[]string{"sync", "orphans"}, func(j *Job) string {
+		valid := j.Sync.Orphans == "keep" || j.Sync.Orphans == "flag" || j.Sync.Orphans == "remove"
+		return unless(valid, "orphans must be keep, flag or remove")
+	}},
+	{[]string{"deletion", "policy"}, func(j *Job) string {
+		valid := j.Deletion.Policy == "keep" || j.Deletion.Policy == "trash" || j.Deletion.Policy == "mirror"
+		return unless(valid, "deletion policy must be keep, trash or mirror")
+	}},
+	{[]string{"deletion", "retention"}, func(j *Job) string {
+		return unless(j.Deletion.Retention >= 0, "retention must not be negative")
+	}},
+	{[]string{"deletion", "grace"}, func(j *Job) string {
+		return unless(j.Deletion.Grace >= 0, "grace must not be negative")
+	}},
+	{[]string{"safe_copy", "retries"}, func(j *Job) string {
+		return unless(j.SafeCopy.Retries >= 0, "retries must not be negative")
+	}},
+	{[]string{"safe_copy", "wait_close"}, func(j *Job) string {
+		return unless(j.SafeCopy.WaitClose >= 0, "wait_close must not be negative")
+	}},
+	{[]string{"archive", "format"}, func(j *Job) string {
+		if j.Archive.Format == "" {
+			return ""
+		}
+		if err := archive.Check(j.Archive.Format); err != nil {
+			return err.Error()
+		}
+		return ""
+	}},
+	{[]string{"archive", "interval"}, func(j *Job) string {
+		return unless(j.Archive.Interval >= 0, "interval must not be negative")
+	}},
+	{[]string{"archive", "cron"}, func(j *Job) string {
+		if j.Archive.Cron == "" {
+			return ""
+		}
+		if _, err := cron.Parse(j.Archive.Cron); err != nil {
+			return err.Error()
+		}
+		return unless(j.Archive.Interval == 0, "archive interval and cron cannot be combined")
+	}},
+	{[]string{"archive", "keep_hourly"}, func(j *Job) string {
+		return unless(j.Archive.KeepHourly >= 0, "keep_hourly must not be negative")
+	}},
+	{[]string{"archive", "keep_daily"}, func(j *Job) string {
+		return unless(j.Archive.KeepDaily >= 0, "keep_daily must not be negative")
+	}},
+	{[]string{"archive", "keep_weekly"}, func(j *Job) string {
+		return unless(j.Archive.KeepWeekly >= 0, "keep_weekly must not be negative")
+	}},
+	{[]string{"versioning", "max_revisions"}, func(j *Job) string {
+		return unless(j.Versioning.MaxRevisions >= 0, "max_revisions must not be negative")
+	}},
+	{[]string{"versioning", "max_age"}, func(j *Job) string {
+		return unless(j.Versioning.MaxAge >= 0, "max_age must not be negative")
+	}},
+	{[]string{"compression"}, func(j *Job) string {
+		if j.Compression.Algorithm == "" {
+			return ""
+		}
+		if _, err := compression.New(j.Compression.Algorithm, j.Compression.Level); err != nil {
+			return err.Error()
+		}
+		return ""
+	}},
+	{[]string{"s3", "part_size"}, func(j *Job) string {
+		return unless(j.S3.PartSize >= 5, "part_size must be at least 5 MiB")
+	}},
+	{[]string{"sftp"}, func(j *Job) string {
+		return unless(j.S3.Endpoint == "" || j.SFTP.Address == "", "s3 and sftp storages cannot be combined")
+	}},
+	{[]string{"include"}, func(j *Job) string {
+		return errorMsg(ignore.Validate(j.Include))
+	}},
+	{[]string{"exclude"}, func(j *Job) string {
+		return errorMsg(ignore.Validate(j.Exclude))
+	}},
+	{[]string{"dedup"}, func(j *Job) string {
+		if !j.Dedup {
+			return ""
+		}
+		if j.S3.Endpoint != "" || j.SFTP.Address != "" {
+			return "dedup store cannot be combined with remote storage"
+		}
+		return unless(!j.Encryption.Enabled, "dedup store cannot be combined with encryption")
+	}},
+}
+
+// unless returns `msg` if the condition `ok` is false.
+func unless(ok bool, msg string) string {
+	if ok {
+		return ""
+	}
+	return msg
+}
+
+// errorMsg returns the message of `err` if any.
+func errorMsg(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// check runs the rules of all keys defined into the file
+// at the top level and into each entry of `jobs`.
+func (c *Config) check(root *yaml.Node) Errors {
+	var errs Errors
+	if line := keyLine(root, "workers"); line > 0 && c.Workers < 0 {
+		errs = append(errs, &Error{Line: line, Msg: "workers must not be negative"})
+	}
+	errs = append(errs, checkJob(root, &c.Job)...)
+
+	names := make(map[string]bool, len(c.jobNodes))
+	for _, node := range c.jobNodes {
+		job := c.inherit(node)
+		errs = append(errs, checkJob(node, &job)...)
+		switch {
+		case job.Name == "":
+			errs = append(errs, &Error{Line: node.Line, Msg: "job must have a name"})
+		case names[job.Name]:
+			errs = append(errs, &Error{Line: keyLine(node, "name"), Msg: fmt.Sprintf("duplicate job name %s", job.Name)})
+		}
+		names[job.Name] = true
+		if job.Source == "" || job.Backup == "" {
+			errs = append(errs, &Error{Line: node.Line, Msg: "job must have a source and a backup folder"})
+		}
+	}
+	return errs
+}
+
+// checkJob runs the rules of all keys defined into the mapping `node`
+// against the settings `job`.
+func checkJob(node *yaml.Node, job *Job) Errors {
+	var errs Errors
+	for _, r := range rules {
+		line := keyLine(node, r.path...)
+		if line == 0 {
+			continue
+		}
+		if msg := r.check(job); msg != "" {
+			errs = append(errs, &Error{Line: line, Msg: msg})
+		}
+	}
+	return errs
+}
+
+// keyLine returns the line of the key located at `path` into
+// the document or mapping `root`. It returns zero if the key is missing.
+func keyLine(root *yaml.Node, path ...string) int {
+	key, _ := lookup(root, path...)
+	if key == nil {
+		return 0
+	}
+	return key.Line
+}
+
+// keyNode returns the value of the key located at `path` into
+// the document or mapping `root`. It returns nil if the key is missing.
+func keyNode(root *yaml.Node, path ...string) *yaml.Node {
+	_, value := lookup(root, path...)
+	return value
+}
+
+// lookup returns the key and value nodes located at `path` into
+// the document or mapping `root`.
+func lookup(root *yaml.Node, path ...string) (*yaml.Node, *yaml.Node) {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	var key *yaml.Node
+	for _, name := range path {
+		if node.Kind != yaml.MappingNode {
+			return nil, nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == name {
+				key, next = node.Content[i], node.Content[i+1]
+			}
+		}
+		if next == nil {
+			return nil, nil
+		}
+		node = next
+	}
+	return key, node
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes `content` into a temporary config file named `name`.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoad_YAML(t *testing.T) {
+	path := writeConfig(t, "gobackup.yaml", `
+source: /data/source
+backup: /data/backup
+workers: 4
+extension: .backup
+delete_prefix: remove_
+delete_interval: 2s
+versioning:
+  enabled: true
+  max_age: 72h
+compression:
+  algorithm: zstd
+  level: 3
+sftp:
+  address: backup.local:22
+  user: ops
+debug: true
+exclude: ["*.swp", ".git/"]
+debounce: 2s
+rename_window: 500ms
+sync:
+  enabled: true
+  orphans: flag
+deletion:
+  policy: trash
+  retention: 168h
+archive:
+  format: tar.zst
+  stream: true
+  cron: "0 */6 * * *"
+  keep_daily: 7
+  keep_weekly: 4
+`)
+	c := Config{LogFile: "file.log", Job: Job{Versioning: Versioning{MaxRevisions: 10}}}
+	require.NoError(t, Load(path, &c))
+
+	assert.Equal(t, "file.log", c.LogFile)
+	assert.Equal(t, "/data/source", c.Source)
+	assert.Equal(t, 4, c.Workers)
+	assert.Equal(t, ".backup", c.Extension)
+	assert.Equal(t, "remove_", c.DeletePrefix)
+	assert.Equal(t, 2*time.Second, c.DeleteInterval)
+	assert.Equal(t, Versioning{Enabled: true, MaxRevisions: 10, MaxAge: 72 * time.Hour}, c.Versioning)
+	assert.Equal(t, Compression{Algorithm: "zstd", Level: 3}, c.Compression)
+	assert.Equal(t, "ops", c.SFTP.User)
+	assert.Equal(t, true, c.Debug)
+	assert.Equal(t, []string{"*.swp", ".git/"}, c.Exclude)
+	assert.Equal(t, 2*time.Second, c.Debounce)
+	assert.Equal(t, 500*time.Millisecond, c.RenameWindow)
+	assert.Equal(t, Sync{Enabled: true, Orphans: "flag"}, c.Sync)
+	assert.Equal(t, Deletion{Policy: "trash", Retention: 168 * time.Hour}, c.Deletion)
+	assert.Equal(t, Archive{Format: "tar.zst", Stream: true, Cron: "0 */6 * * *", KeepDaily: 7, KeepWeekly: 4}, c.Archive)
+}
+
+func TestLoad_JSON(t *testing.T) {
+	path := writeConfig(t, "gobackup.json", `{
+  "source": "/data/source",
+  "delete_interval": "1s",
+  "s3": {"endpoint": "http://localhost:9000", "part_size": 16}
+}`)
+	var c Config
+	require.NoError(t, Load(path, &c))
+	assert.Equal(t, "/data/source", c.Source)
+	assert.Equal(t, time.Second, c.DeleteInterval)
+	assert.Equal(t, int64(16), c.S3.PartSize)
+}
+
+func TestLoad_TOML(t *testing.T) {
+	var c Config
+	err := Load(writeConfig(t, "gobackup.toml", "source = \"/data/source\"\n"), &c)
+	assert.EqualError(t, err, "toml files are not supported: use a yaml or json file")
+}
+
+func TestLoad_Empty(t *testing.T) {
+	c := Config{Workers: 3}
+	require.NoError(t, Load(writeConfig(t, "empty.yaml", ""), &c))
+	assert.Equal(t, 3, c.Workers)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		expected Errors
+	}{
+		{
+			"syntax",
+			"source: /data\n  backup: [\n",
+			Errors{{Line: 2, Msg: "mapping values are not allowed in this context"}},
+		},
+		{
+			"unknown key and wrong type",
+			"source: /data\nworkerz: 4\nworkers: many\n",
+			Errors{
+				{Line: 2, Msg: "field workerz not found in type config.Config"},
+				{Line: 3, Msg: "cannot unmarshal !!str `many` into int"},
+			},
+		},
+		{
+			"invalid values",
+			"workers: -1\nextension: bak\ndelete_interval: 0s\ncompression:\n  algorithm: lz4\ndebounce: -1s\nrename_window: -1s\n",
+			Errors{
+				{Line: 1, Msg: "workers must not be negative"},
+				{Line: 2, Msg: "extension must start with a dot and not contain separators"},
+				{Line: 3, Msg: "delete_interval must be positive"},
+				{Line: 6, Msg: "debounce must not be negative"},
+				{Line: 7, Msg: "rename_window must not be negative"},
+				{Line: 4, Msg: `unknown compression algorithm "lz4": expected gzip or zstd`},
+			},
+		},
+		{
+			"conflicting storages",
+			"dedup: true\ns3:\n  endpoint: http://localhost:9000\nsftp:\n  address: backup.local:22\n",
+			Errors{
+				{Line: 4, Msg: "s3 and sftp storages cannot be combined"},
+				{Line: 1, Msg: "dedup store cannot be combined with remote storage"},
+			},
+		},
+		{
+			"invalid patterns",
+			"exclude:\n  - \"*.swp\"\n  - \"[abc\"\n",
+			Errors{{Line: 1, Msg: `invalid pattern "[abc": unclosed character class`}},
+		},
+		{
+			"invalid orphans policy",
+			"sync:\n  enabled: true\n  orphans: drop\n",
+			Errors{{Line: 3, Msg: "orphans must be keep, flag or remove"}},
+		},
+		{
+			"invalid deletion settings",
+			"deletion:\n  policy: purge\n  grace: -1h\n",
+			Errors{
+				{Line: 2, Msg: "deletion policy must be keep, trash or mirror"},
+				{Line: 3, Msg: "grace must not be negative"},
+			},
+		},
+		{
+			"invalid safe copy settings",
+			"safe_copy:\n  enabled: true\n  retries: -1\n  wait_close: -1s\n",
+			Errors{
+				{Line: 3, Msg: "retries must not be negative"},
+				{Line: 4, Msg: "wait_close must not be negative"},
+			},
+		},
+		{
+			"invalid archive settings",
+			"archive:\n  interval: 1h\n  cron: \"@daily\"\n  keep_hourly: -1\n",
+			Errors{
+				{Line: 3, Msg: "archive interval and cron cannot be combined"},
+				{Line: 4, Msg: "keep_hourly must not be negative"},
+			},
+		},
+		{
+			"invalid archive format",
+			"archive:\n  format: 7z\n",
+			Errors{{Line: 2, Msg: `unknown archive format "7z": expected zip, tar.gz or tar.zst`}},
+		},
+		{
+			"invalid archive cron",
+			"archive:\n  cron: \"0 25 * * *\"\n",
+			Errors{{Line: 2, Msg: `invalid cron expression "0 25 * * *": value 25 out of range 0-23`}},
+		},
+		{
+			"invalid jobs",
+			"jobs:\n  - name: docs\n    source: /docs\n    backup: /backup/docs\n    extension: bak\n" +
+				"  - name: docs\n    source: /photos\n    backup: /backup/photos\n  - source: /music\n",
+			Errors{
+				{Line: 5, Msg: "extension must start with a dot and not contain separators"},
+				{Line: 6, Msg: "duplicate job name docs"},
+				{Line: 9, Msg: "job must have a name"},
+				{Line: 9, Msg: "job must have a source and a backup folder"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var c Config
+			err := Load(writeConfig(t, "gobackup.yaml", tc.content), &c)
+			var errs Errors
+			require.True(t, errors.As(err, &errs), "unexpected error: %v", err)
+			assert.Equal(t, tc.expected, errs)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Run("single job", func(t *testing.T) {
+		c := Config{Job: Job{Source: "/data/source", Backup: "/data/backup"}}
+		require.NoError(t, Load(writeConfig(t, "gobackup.yaml", "workers: 2\n"), &c))
+		assert.Equal(t, []Job{{Source: "/data/source", Backup: "/data/backup"}}, c.Resolve())
+	})
+
+	t.Run("jobs inherit top level settings", func(t *testing.T) {
+		path := writeConfig(t, "gobackup.yaml", `
+extension: .backup
+compression:
+  algorithm: gzip
+jobs:
+  - name: docs
+    source: /docs
+    backup: /backup/docs
+  - name: photos
+    source: /photos
+    backup: /backup/photos
+    compression:
+      algorithm: ""
+`)
+		c := Config{Job: Job{Extension: ".bak", DeletePrefix: "delete_"}}
+		require.NoError(t, Load(path, &c))
+		// flags parsed after loading override the inherited settings.
+		c.DeletePrefix = "remove_"
+
+		jobs := c.Resolve()
+		require.Len(t, jobs, 2)
+		assert.Equal(t, Job{
+			Name: "docs", Source: "/docs", Backup: "/backup/docs", Extension: ".backup",
+			DeletePrefix: "remove_", Compression: Compression{Algorithm: "gzip"},
+		}, jobs[0])
+		assert.Equal(t, Job{
+			Name: "photos", Source: "/photos", Backup: "/backup/photos", Extension: ".backup",
+			DeletePrefix: "remove_",
+		}, jobs[1])
+	})
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	var c Config
+	err := Load(filepath.Join(t.TempDir(), "none.yaml"), &c)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
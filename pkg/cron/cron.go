@@ -0,0 +1,158 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds the search of the next time of a schedule which
+// may never match like the 30th of February.
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// macros are the shortcuts of common expressions.
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// bounds are the minimum and maximum values of each field.
+var bounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// Schedule is a parsed cron expression of five fields: minute, hour,
+// day of month, month and day of week (0 or 7 for Sunday). Each field
+// is `*`, a value, a range `a-b` or a list of them with an optional
+// step like `*/15` or `1-5/2`.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// days of month and of week are only combined when both are restricted.
+	anyDom, anyDow bool
+}
+
+// Parse parses the cron expression `expr` or one of the macros
+// @hourly, @daily, @midnight, @weekly and @monthly.
+func Parse(expr string) (*Schedule, error) {
+	spec := expr
+	if m, ok := macros[spec]; ok {
+		spec = m
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != len(bounds) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields", expr, len(bounds))
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is either 0 or 7.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &Schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		anyDom: strings.HasPrefix(parts[2], "*"),
+		anyDow: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseField returns the set of values between `first` and `last`
+// matched by the field `field` as a bitmask.
+func parseField(field string, first, last int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(item, "/")
+		inc := 1
+		if hasStep {
+			n, err := strconv.Atoi(step)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+			inc = n
+		}
+		lo, hi := first, last
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseValue(from, first, last); err != nil {
+				return 0, err
+			}
+			switch {
+			case isRange:
+				if hi, err = parseValue(to, first, last); err != nil {
+					return 0, err
+				}
+			case !hasStep:
+				hi = lo
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += inc {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// parseValue parses the number `s` and ensures it is between `first` and `last`.
+func parseValue(s string, first, last int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < first || v > last {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, first, last)
+	}
+	return v, nil
+}
+
+// Next returns the first time matched by the schedule after `t` into
+// the location of `t`. It returns the zero time if none is found.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.Add(maxSearch)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay reports whether the day of `t` is matched. When both the day of
+// month and the day of week are restricted, matching either one is enough.
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// has reports whether the value `v` is into the set `set`.
+func has(set uint64, v int) bool {
+	return set&(1<<v) != 0
+}
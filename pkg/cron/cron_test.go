@@ -0,0 +1,69 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_Errors(t *testing.T) {
+	tests := map[string]string{
+		"* * * *":       "expected 5 fields",
+		"60 * * * *":    "value 60 out of range 0-59",
+		"* 24 * * *":    "value 24 out of range 0-23",
+		"* * 0 * *":     "value 0 out of range 1-31",
+		"* * * 13 *":    "value 13 out of range 1-12",
+		"* * * * 8":     "value 8 out of range 0-7",
+		"*/0 * * * *":   `invalid step "0"`,
+		"5-1 * * * *":   `invalid range "5-1"`,
+		"a * * * *":     `invalid value "a"`,
+		"@yearly":       "expected 5 fields",
+		"1,,2 * * * *":  `invalid value ""`,
+		"1-x/2 * * * *": `invalid value "x"`,
+	}
+	for expr, msg := range tests {
+		_, err := Parse(expr)
+		require.Error(t, err, expr)
+		assert.Contains(t, err.Error(), msg, expr)
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Monday.
+	from := time.Date(2023, 8, 14, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2023, 8, 14, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, 8, 14, 10, 15, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2023, 8, 14, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, 8, 14, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2023, 8, 15, 2, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 8, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2023, 8, 14, 13, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2023, 8, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, 8, 20, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// restricted day of month and day of week match either one.
+		{"0 0 20 * 3", time.Date(2023, 8, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range tests {
+		s, err := Parse(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.want, s.Next(from), tc.expr)
+	}
+}
+
+func TestNext_Location(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	s, err := Parse("0 2 * * *")
+	require.NoError(t, err)
+	got := s.Next(time.Date(2023, 8, 14, 10, 0, 0, 0, loc))
+	assert.Equal(t, time.Date(2023, 8, 15, 2, 0, 0, 0, loc), got)
+}
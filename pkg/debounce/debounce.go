@@ -0,0 +1,186 @@
+package debounce
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/jeamon/gobackup/pkg/fstypes"
+)
+
+// maxWaitFactor bounds the delay of a path which keeps changing to
+// this number of quiet windows so it still gets backed up.
+const maxWaitFactor = 10
+
+// Debouncer merges bursts of events of each path and only forwards them
+// once the path got no new event during the quiet window and its size and
+// modification time stopped changing.
+type Debouncer struct {
+	quiet   time.Duration
+	maxWait time.Duration
+	pending map[string]*burst
+	seq     int
+}
+
+// burst holds the merged events of a path not yet forwarded.
+type burst struct {
+	seq     int // order of the first event to forward bursts in order.
+	path    string
+	typ     fstypes.Type
+	ops     map[events.Event]bool
+	first   time.Time // time of the first event.
+	last    time.Time // time of the last event or change of the file.
+	size    int64
+	mtime   time.Time
+	existed bool // the burst did not open with the creation of the path.
+}
+
+// New provides a Debouncer with a `quiet` window.
+func New(quiet time.Duration) *Debouncer {
+	return &Debouncer{
+		quiet:   quiet,
+		maxWait: maxWaitFactor * quiet,
+		pending: make(map[string]*burst),
+	}
+}
+
+// Run reads changes from `in` until it is closed and writes merged
+// changes into `out`. Pending changes are forwarded before it returns.
+func (d *Debouncer) Run(in <-chan *events.Change, out chan<- *events.Change) {
+	tick := d.quiet / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case ce, ok := <-in:
+			if !ok {
+				d.flush(out, time.Now(), true)
+				return
+			}
+			d.add(ce, out, time.Now())
+		case now := <-ticker.C:
+			d.flush(out, now, false)
+		}
+	}
+}
+
+// add merges the change `ce` into the burst of its path. Events which
+// cannot be merged like RENAME are forwarded right away after the burst
+// of their path, and of the previous path of a renamed file, so the order
+// of the events of a path is kept.
+func (d *Debouncer) add(ce *events.Change, out chan<- *events.Change, now time.Time) {
+	if ce.Error != nil || !mergeable(ce.Ops) {
+		for _, path := range []string{ce.From, ce.Path} {
+			if b, ok := d.pending[path]; ok {
+				d.forward(b, out)
+			}
+		}
+		out <- ce
+		return
+	}
+	b, ok := d.pending[ce.Path]
+	if !ok {
+		d.seq++
+		b = &burst{seq: d.seq, path: ce.Path, ops: make(map[events.Event]bool), existed: ce.Ops != events.CREATE, first: now}
+		d.pending[ce.Path] = b
+	}
+	b.typ, b.last = ce.Type, now
+	b.size, b.mtime = stat(ce.Path)
+
+	switch ce.Ops {
+	case events.DELETE:
+		if b.ops[events.CREATE] && !b.existed {
+			// the path did not exist before the burst so nothing to do.
+			delete(d.pending, ce.Path)
+			return
+		}
+		b.ops = map[events.Event]bool{events.DELETE: true}
+	case events.CREATE:
+		if b.ops[events.DELETE] {
+			// the path got replaced so its content must be copied again.
+			b.ops = map[events.Event]bool{events.CREATE: true, events.MODIFY: true}
+			return
+		}
+		b.ops[events.CREATE] = true
+	default:
+		b.ops[ce.Ops] = true
+	}
+}
+
+// mergeable reports whether events of kind `ops` could be merged.
+func mergeable(ops events.Event) bool {
+	switch ops {
+	case events.CREATE, events.MODIFY, events.ATTRIBUTE, events.DELETE:
+		return true
+	}
+	return false
+}
+
+// flush forwards in order all bursts which are ready at `now`
+// or all of them when `all` is set.
+func (d *Debouncer) flush(out chan<- *events.Change, now time.Time, all bool) {
+	var ready []*burst
+	for _, b := range d.pending {
+		if all || d.isReady(b, now) {
+			ready = append(ready, b)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].seq < ready[j].seq })
+	for _, b := range ready {
+		d.forward(b, out)
+	}
+}
+
+// isReady reports whether the burst `b` got no event during the quiet
+// window and the file content is stable. A file still being written gets
+// another quiet window unless it already waited for the maximum delay.
+func (d *Debouncer) isReady(b *burst, now time.Time) bool {
+	if now.Sub(b.last) < d.quiet {
+		return false
+	}
+	if b.ops[events.DELETE] || now.Sub(b.first) >= d.maxWait {
+		return true
+	}
+	size, mtime := stat(b.path)
+	if size == b.size && mtime.Equal(b.mtime) {
+		return true
+	}
+	b.size, b.mtime, b.last = size, mtime, now
+	return false
+}
+
+// forward writes the merged events of the burst `b` into `out`
+// in the order the handlers expect them then drops the burst. A
+// created file already holding content also gets its content copied
+// since creating its backup file only makes it empty.
+func (d *Debouncer) forward(b *burst, out chan<- *events.Change) {
+	delete(d.pending, b.path)
+	if b.ops[events.CREATE] && !b.ops[events.MODIFY] && hasContent(b.path) {
+		b.ops[events.MODIFY] = true
+	}
+	for _, ops := range []events.Event{events.DELETE, events.CREATE, events.MODIFY, events.ATTRIBUTE} {
+		if b.ops[ops] {
+			out <- &events.Change{Path: b.path, Ops: ops, Type: b.typ}
+		}
+	}
+}
+
+// hasContent reports whether `path` is a regular file which is not empty.
+func hasContent(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() > 0
+}
+
+// stat returns the size and modification time of the file at `path`.
+// They are zero if the file does not exist anymore.
+func stat(path string) (int64, time.Time) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	return fi.Size(), fi.ModTime()
+}
@@ -0,0 +1,137 @@
+package debounce
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collect returns the events written into `out` until it gets closed.
+func collect(out <-chan *events.Change) []events.Change {
+	var got []events.Change
+	for ce := range out {
+		got = append(got, *ce)
+	}
+	return got
+}
+
+// run feeds the `changes` into a Debouncer with a `quiet` window and
+// returns the events it forwarded once all got processed.
+func run(quiet time.Duration, changes ...*events.Change) []events.Change {
+	in, out := make(chan *events.Change), make(chan *events.Change, 100)
+	go func() {
+		New(quiet).Run(in, out)
+		close(out)
+	}()
+	for _, ce := range changes {
+		in <- ce
+	}
+	time.Sleep(2 * quiet)
+	close(in)
+	return collect(out)
+}
+
+func TestDebouncer(t *testing.T) {
+	folder := t.TempDir()
+	file := filepath.Join(folder, "file.txt")
+	other := filepath.Join(folder, "other.txt")
+	require.NoError(t, os.WriteFile(file, []byte("content"), 0o644))
+	change := func(path string, ops events.Event) *events.Change {
+		return &events.Change{Path: path, Ops: ops}
+	}
+
+	t.Run("merge burst", func(t *testing.T) {
+		got := run(50*time.Millisecond,
+			change(file, events.CREATE), change(file, events.MODIFY), change(file, events.ATTRIBUTE),
+			change(file, events.MODIFY), change(file, events.MODIFY),
+		)
+		assert.Equal(t, []events.Change{
+			{Path: file, Ops: events.CREATE}, {Path: file, Ops: events.MODIFY}, {Path: file, Ops: events.ATTRIBUTE},
+		}, got)
+	})
+
+	t.Run("keep order of paths", func(t *testing.T) {
+		got := run(50*time.Millisecond,
+			change(folder, events.CREATE), change(other, events.MODIFY), change(file, events.MODIFY), change(other, events.MODIFY),
+		)
+		assert.Equal(t, []events.Change{
+			{Path: folder, Ops: events.CREATE}, {Path: other, Ops: events.MODIFY}, {Path: file, Ops: events.MODIFY},
+		}, got)
+	})
+
+	t.Run("copy created file content", func(t *testing.T) {
+		empty := filepath.Join(folder, "empty.txt")
+		require.NoError(t, os.WriteFile(empty, nil, 0o644))
+		got := run(50*time.Millisecond, change(file, events.CREATE), change(empty, events.CREATE))
+		assert.Equal(t, []events.Change{
+			{Path: file, Ops: events.CREATE}, {Path: file, Ops: events.MODIFY}, {Path: empty, Ops: events.CREATE},
+		}, got)
+	})
+
+	t.Run("drop created then deleted", func(t *testing.T) {
+		got := run(50*time.Millisecond, change(other, events.CREATE), change(other, events.MODIFY), change(other, events.DELETE))
+		assert.Empty(t, got)
+	})
+
+	t.Run("deleted then created", func(t *testing.T) {
+		got := run(50*time.Millisecond, change(file, events.MODIFY), change(file, events.DELETE), change(file, events.CREATE))
+		assert.Equal(t, []events.Change{{Path: file, Ops: events.CREATE}, {Path: file, Ops: events.MODIFY}}, got)
+	})
+
+	t.Run("deleted then created then deleted", func(t *testing.T) {
+		got := run(50*time.Millisecond, change(other, events.DELETE), change(other, events.CREATE), change(other, events.DELETE))
+		assert.Equal(t, []events.Change{{Path: other, Ops: events.DELETE}}, got)
+	})
+
+	t.Run("forward unmergeable events in order", func(t *testing.T) {
+		got := run(50*time.Millisecond, change(file, events.MODIFY), change(file, events.RENAME), change(file, events.MODIFY))
+		assert.Equal(t, []events.Change{
+			{Path: file, Ops: events.MODIFY}, {Path: file, Ops: events.RENAME}, {Path: file, Ops: events.MODIFY},
+		}, got)
+	})
+
+	t.Run("flush pending on close", func(t *testing.T) {
+		in, out := make(chan *events.Change), make(chan *events.Change, 10)
+		go func() {
+			New(time.Hour).Run(in, out)
+			close(out)
+		}()
+		in <- change(file, events.MODIFY)
+		close(in)
+		assert.Equal(t, []events.Change{{Path: file, Ops: events.MODIFY}}, collect(out))
+	})
+}
+
+func TestDebouncer_WaitStableFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	quiet := 100 * time.Millisecond
+	in, out := make(chan *events.Change), make(chan *events.Change, 10)
+	go New(quiet).Run(in, out)
+	defer close(in)
+
+	start := time.Now()
+	in <- &events.Change{Path: file, Ops: events.MODIFY}
+	// keep writing without events like a slow copy into the source.
+	for i := 1; i <= 4; i++ {
+		time.Sleep(quiet / 2)
+		require.NoError(t, os.WriteFile(file, make([]byte, i*1024), 0o644))
+	}
+
+	select {
+	case ce := <-out:
+		assert.Equal(t, events.MODIFY, ce.Ops)
+		assert.GreaterOrEqual(t, time.Since(start), 3*quiet)
+		fi, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4*1024), fi.Size())
+	case <-time.After(maxWaitFactor * quiet * 2):
+		t.Fatal("stable file was not forwarded")
+	}
+}
@@ -0,0 +1,293 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// magic identifies encrypted content. It is followed by the format version.
+	magic   = "GOBAKENC"
+	version = 1
+	// kinds of key the content was encrypted with.
+	kdfNone   byte = 0
+	kdfScrypt byte = 1
+
+	keySize     = 32
+	saltSize    = 16
+	prefixSize  = 7
+	segmentSize = 64 * 1024
+	// scrypt cost parameters recommended for interactive logins.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	// ErrKeyRequired is returned when reading encrypted content without key.
+	ErrKeyRequired = errors.New("content is encrypted: key file or passphrase required")
+	// ErrDecrypt is returned when content cannot be authenticated.
+	ErrDecrypt = errors.New("decryption failed: wrong key or corrupted content")
+)
+
+// Cipher encrypts and decrypts content with a key loaded from a key file
+// or derived from a passphrase. It is safe for concurrent use.
+//
+// Content is split into segments of 64 KiB each sealed with AES-256-GCM. The
+// nonce of a segment is made of a random per-stream prefix, the segment index
+// and a flag marking the final segment, so reordered, dropped or truncated
+// segments are all detected. Each stream starts with a header identifying the
+// format and, for passphrase keys, the scrypt salt the key was derived with.
+type Cipher struct {
+	kdf        byte   // kind of key.
+	key        []byte // key used to encrypt.
+	salt       []byte // salt the key was derived with.
+	passphrase []byte
+
+	mu   sync.Mutex
+	keys map[string][]byte // keys derived from the passphrase indexed by salt.
+}
+
+// LoadKeyFile loads a 32 bytes key from the file `path`. The key can be
+// stored raw or hex-encoded like the output of `openssl rand -hex 32`.
+func LoadKeyFile(path string) (*Cipher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := data
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 2*keySize {
+		if key, err = hex.DecodeString(string(trimmed)); err != nil {
+			return nil, fmt.Errorf("invalid hex key: %w", err)
+		}
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid key size: expected %d bytes", keySize)
+	}
+	return &Cipher{kdf: kdfNone, key: key}, nil
+}
+
+// NewPassphrase derives a key from `passphrase` with a random salt. The salt
+// is stored into each encrypted stream so content can be decrypted later with
+// the passphrase only.
+func NewPassphrase(passphrase string) (*Cipher, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	c := &Cipher{kdf: kdfScrypt, salt: salt, passphrase: []byte(passphrase), keys: make(map[string][]byte)}
+	key, err := c.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+	c.key = key
+	return c, nil
+}
+
+// derive returns the key derived from the passphrase and `salt`.
+// Derived keys are cached since scrypt is expensive on purpose.
+func (c *Cipher) derive(salt []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(c.passphrase, salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	c.keys[string(salt)] = key
+	return key, nil
+}
+
+// header returns the stream header announcing the kind of key.
+func (c *Cipher) header() []byte {
+	h := append([]byte(magic), version, c.kdf)
+	if c.kdf == kdfScrypt {
+		h = append(h, c.salt...)
+	}
+	return h
+}
+
+// IsEncrypted reports whether `header` starts like encrypted content.
+func IsEncrypted(header []byte) bool {
+	return len(header) > len(magic) && string(header[:len(magic)]) == magic && header[len(magic)] == version
+}
+
+// Encrypt returns a reader of the encrypted content read from `r`.
+func (c *Cipher) Encrypt(r io.Reader) io.Reader {
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return &stream{err: err}
+	}
+	aead, err := newAEAD(c.key)
+	if err != nil {
+		return &stream{err: err}
+	}
+	return &stream{
+		src:    r,
+		aead:   aead,
+		prefix: prefix,
+		seal:   true,
+		buf:    make([]byte, segmentSize+1),
+		out:    append(c.header(), prefix...),
+	}
+}
+
+// Decrypt reads the header from `r` then returns a reader of the decrypted
+// content. Authentication errors are reported as ErrDecrypt while reading.
+func (c *Cipher) Decrypt(r io.Reader) (io.Reader, error) {
+	head := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(r, head); err != nil || !IsEncrypted(head) {
+		return nil, fmt.Errorf("not encrypted content")
+	}
+
+	key := c.key
+	switch kdf := head[len(magic)+1]; {
+	case kdf == kdfScrypt && c.kdf == kdfScrypt:
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(r, salt); err != nil {
+			return nil, ErrDecrypt
+		}
+		var err error
+		if key, err = c.derive(salt); err != nil {
+			return nil, err
+		}
+	case kdf == kdfScrypt:
+		return nil, fmt.Errorf("content is encrypted with a passphrase")
+	case kdf == kdfNone && c.kdf != kdfNone:
+		return nil, fmt.Errorf("content is encrypted with a key file")
+	case kdf != kdfNone:
+		return nil, fmt.Errorf("unknown key kind %d", kdf)
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, ErrDecrypt
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{
+		src:    r,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, segmentSize+aead.Overhead()+1),
+	}, nil
+}
+
+// Open returns a reader of the plain content of `r` whose encryption was
+// not recorded. Encrypted content is decrypted with `c`, which may be nil
+// when no key was provided. Plain content is only returned as is without
+// key, or when empty, so content which lost its header is not taken for
+// plain content.
+func Open(c *Cipher, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(magic) + 1)
+	if !IsEncrypted(head) {
+		if c != nil && len(head) > 0 {
+			return nil, fmt.Errorf("not encrypted content")
+		}
+		return br, nil
+	}
+	if c == nil {
+		return nil, ErrKeyRequired
+	}
+	return c.Decrypt(br)
+}
+
+// newAEAD returns an AES-GCM instance for `key`.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// stream seals or opens the content read from `src` one segment at a time.
+// One byte more than a segment is read ahead to know if a segment is the
+// final one.
+type stream struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	seal    bool
+	counter uint32
+	buf     []byte // segment read from `src` plus a lookahead byte.
+	carried int    // lookahead bytes kept at the start of `buf`.
+	out     []byte // processed bytes not yet returned.
+	done    bool
+	err     error
+}
+
+// Read implements io.Reader.
+func (s *stream) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// next processes the following segment into `out`.
+func (s *stream) next() error {
+	n, err := io.ReadFull(s.src, s.buf[s.carried:])
+	n += s.carried
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	size := len(s.buf) - 1
+	last := n <= size
+	if !last {
+		n = size
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], s.counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+
+	if s.seal {
+		s.out = s.aead.Seal(nil, nonce, s.buf[:n], nil)
+	} else {
+		if s.out, err = s.aead.Open(nil, nonce, s.buf[:n], nil); err != nil {
+			return ErrDecrypt
+		}
+	}
+
+	if !last {
+		s.buf[0] = s.buf[size]
+		s.carried = 1
+	}
+	if s.counter++; s.counter == 0 {
+		return fmt.Errorf("content too large")
+	}
+	s.done = last
+	return nil
+}
@@ -0,0 +1,160 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestKeyFile writes a random hex-encoded key into a temporary file.
+func newTestKeyFile(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600))
+	return path
+}
+
+// encrypt returns the content encrypted by `c`.
+func encrypt(t *testing.T, c *Cipher, data []byte) []byte {
+	t.Helper()
+	sealed, err := io.ReadAll(c.Encrypt(bytes.NewReader(data)))
+	require.NoError(t, err)
+	return sealed
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw")
+	require.NoError(t, os.WriteFile(raw, bytes.Repeat([]byte{7}, keySize), 0o600))
+	_, err := LoadKeyFile(raw)
+	assert.NoError(t, err)
+
+	_, err = LoadKeyFile(newTestKeyFile(t))
+	assert.NoError(t, err)
+
+	short := filepath.Join(dir, "short")
+	require.NoError(t, os.WriteFile(short, []byte("abcd"), 0o600))
+	_, err = LoadKeyFile(short)
+	assert.Error(t, err)
+
+	_, err = LoadKeyFile(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c, err := LoadKeyFile(newTestKeyFile(t))
+	require.NoError(t, err)
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 10} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		sealed := encrypt(t, c, data)
+		assert.True(t, IsEncrypted(sealed))
+		if size > 16 {
+			assert.NotContains(t, string(sealed), string(data))
+		}
+
+		r, err := Open(c, bytes.NewReader(sealed))
+		require.NoError(t, err)
+		plain, err := io.ReadAll(r)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, data, plain, "size %d", size)
+	}
+}
+
+func TestDecrypt_Tampered(t *testing.T) {
+	c, err := LoadKeyFile(newTestKeyFile(t))
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("content"), segmentSize/3)
+	sealed := encrypt(t, c, data)
+
+	read := func(b []byte) error {
+		r, err := c.Decrypt(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	t.Run("flipped byte", func(t *testing.T) {
+		b := bytes.Clone(sealed)
+		b[len(b)/2] ^= 1
+		assert.ErrorIs(t, read(b), ErrDecrypt)
+	})
+
+	t.Run("truncated at segment boundary", func(t *testing.T) {
+		headerSize := len(magic) + 2 + prefixSize
+		b := sealed[:headerSize+segmentSize+16]
+		assert.ErrorIs(t, read(b), ErrDecrypt)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := LoadKeyFile(newTestKeyFile(t))
+		require.NoError(t, err)
+		r, err := other.Decrypt(bytes.NewReader(sealed))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrDecrypt)
+	})
+}
+
+func TestPassphrase(t *testing.T) {
+	_, err := NewPassphrase("")
+	assert.Error(t, err)
+
+	c, err := NewPassphrase("correct horse battery staple")
+	require.NoError(t, err)
+	sealed := encrypt(t, c, []byte("secret"))
+
+	// another run derives another key but still decrypts with the salt of the content.
+	other, err := NewPassphrase("correct horse battery staple")
+	require.NoError(t, err)
+	r, err := Open(other, bytes.NewReader(sealed))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plain))
+
+	keyed, err := LoadKeyFile(newTestKeyFile(t))
+	require.NoError(t, err)
+	_, err = Open(keyed, bytes.NewReader(sealed))
+	assert.Error(t, err)
+	_, err = Open(c, bytes.NewReader(encrypt(t, keyed, []byte("secret"))))
+	assert.Error(t, err)
+}
+
+func TestOpen(t *testing.T) {
+	r, err := Open(nil, strings.NewReader("plain content"))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "plain content", string(plain))
+
+	c, err := LoadKeyFile(newTestKeyFile(t))
+	require.NoError(t, err)
+	_, err = Open(nil, bytes.NewReader(encrypt(t, c, []byte("secret"))))
+	assert.ErrorIs(t, err, ErrKeyRequired)
+
+	// with a key, only empty content is taken for plain content.
+	_, err = Open(c, strings.NewReader("plain content"))
+	assert.EqualError(t, err, "not encrypted content")
+	r, err = Open(c, strings.NewReader(""))
+	require.NoError(t, err)
+	plain, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, plain)
+}
@@ -5,6 +5,7 @@ import "github.com/jeamon/gobackup/pkg/fstypes"
 // Change represents the object to be processed by backup workers.
 type Change struct {
 	Path  string
+	From  string // previous path of a renamed file.
 	Ops   Event
 	Type  fstypes.Type
 	Error error
@@ -0,0 +1,250 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// FileName is the name of the files holding the exclude rules of
+// the folder they belong to and of its sub-folders.
+const FileName = ".gobackupignore"
+
+// rule is a compiled gitignore-style pattern.
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool // re-includes paths excluded by previous rules.
+	dirOnly bool // only matches folders.
+}
+
+// match reports whether the rule applies to the slash-separated
+// path `rel` relative to the folder of the rule.
+func (r rule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return r.re.MatchString(rel)
+}
+
+// compile converts a gitignore-style pattern into a rule. Blank
+// patterns and comments starting with `#` provide no rule.
+func compile(pattern string) (*rule, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, nil
+	}
+	r := &rule{}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate, pattern = true, pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly, pattern = true, strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("invalid pattern: empty path")
+	}
+	// a pattern without inner separator matches at any depth.
+	prefix := "(?:.*/)?"
+	if strings.Contains(pattern, "/") {
+		prefix, pattern = "", strings.TrimPrefix(pattern, "/")
+	}
+	expr, err := translate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if r.re, err = regexp.Compile("^" + prefix + expr + "$"); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return r, nil
+}
+
+// translate converts the glob `pattern` into a regular expression. A `*`
+// matches any characters but separators, `**` matches across folders,
+// `?` a single character and `[...]` a class of characters.
+func translate(pattern string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("invalid pattern %q: unclosed character class", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// compileAll compiles all `patterns` into rules.
+func compileAll(patterns []string) ([]rule, error) {
+	var rules []rule
+	for _, p := range patterns {
+		r, err := compile(p)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			rules = append(rules, *r)
+		}
+	}
+	return rules, nil
+}
+
+// Validate checks that all `patterns` are valid.
+func Validate(patterns []string) error {
+	_, err := compileAll(patterns)
+	return err
+}
+
+// Rules decides which paths of a source folder must not be backed up.
+// Exclude rules apply first, then the rules of each `.gobackupignore`
+// file from the source folder down to the path so the last matching
+// rule wins. A path below an excluded folder is always excluded. When
+// include rules are set, only matching files are backed up.
+type Rules struct {
+	root    string
+	include []rule
+	exclude []rule
+	mu      sync.RWMutex
+	files   map[string][]rule // rules of `.gobackupignore` files per relative folder.
+}
+
+// New provides the rules of the source folder `root`.
+func New(root string, include, exclude []string) (*Rules, error) {
+	inc, err := compileAll(include)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := compileAll(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{root: root, include: inc, exclude: exc, files: make(map[string][]rule)}, nil
+}
+
+// Ignored reports whether the file or folder at `path` must not be
+// backed up. A nil Rules ignores nothing.
+func (r *Rules) Ignored(path string, isDir bool) bool {
+	if r == nil {
+		return false
+	}
+	rel, err := filepath.Rel(r.root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.excluded(parts[:i], true) {
+			return true
+		}
+	}
+	if r.excluded(parts, isDir) {
+		return true
+	}
+	return !isDir && len(r.include) > 0 && !apply(r.include, rel, false, false)
+}
+
+// excluded evaluates exclude rules against the path made of `parts`.
+func (r *Rules) excluded(parts []string, isDir bool) bool {
+	rel := strings.Join(parts, "/")
+	ignored := apply(r.exclude, rel, isDir, false)
+	for i := 0; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		ignored = apply(r.fileRules(dir), strings.Join(parts[i:], "/"), isDir, ignored)
+	}
+	return ignored
+}
+
+// apply returns the outcome of the last rule matching `rel` or `ignored`.
+func apply(rules []rule, rel string, isDir, ignored bool) bool {
+	for _, rl := range rules {
+		if rl.match(rel, isDir) {
+			ignored = !rl.negate
+		}
+	}
+	return ignored
+}
+
+// fileRules returns the rules of the `.gobackupignore` file of the
+// relative folder `dir`. They are loaded once then cached.
+func (r *Rules) fileRules(dir string) []rule {
+	r.mu.RLock()
+	rules, ok := r.files[dir]
+	r.mu.RUnlock()
+	if ok {
+		return rules
+	}
+	rules = load(filepath.Join(r.root, filepath.FromSlash(dir), FileName))
+	r.mu.Lock()
+	r.files[dir] = rules
+	r.mu.Unlock()
+	return rules
+}
+
+// Invalidate drops the cached rules of the `.gobackupignore` file
+// located into the folder `dir` so they get loaded again.
+func (r *Rules) Invalidate(dir string) {
+	if r == nil {
+		return
+	}
+	rel, err := filepath.Rel(r.root, dir)
+	if err != nil {
+		return
+	}
+	rel = path.Clean(filepath.ToSlash(rel))
+	if rel == "." {
+		rel = ""
+	}
+	r.mu.Lock()
+	delete(r.files, rel)
+	r.mu.Unlock()
+}
+
+// load reads the rules of the ignore file at `path`. Invalid
+// patterns are skipped so a typo does not stop the backup.
+func load(path string) []rule {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var rules []rule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if r, err := compile(scanner.Text()); err == nil && r != nil {
+			rules = append(rules, *r)
+		}
+	}
+	return rules
+}
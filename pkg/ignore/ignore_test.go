@@ -0,0 +1,135 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		match   bool
+	}{
+		{"*.swp", "file.swp", false, true},
+		{"*.swp", "docs/.file.swp", false, true},
+		{"*.swp", "file.swp.txt", false, false},
+		{".git/", ".git", true, true},
+		{".git/", ".git", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"docs/*.tmp", "docs/a.tmp", false, true},
+		{"docs/*.tmp", "docs/sub/a.tmp", false, false},
+		{"docs/**/*.tmp", "docs/sub/deep/a.tmp", false, true},
+		{"docs/**/*.tmp", "docs/a.tmp", false, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"logs/**", "logs/a/b.log", false, true},
+		{"file?.txt", "file1.txt", false, true},
+		{"file?.txt", "file10.txt", false, false},
+		{"file[0-9].txt", "file5.txt", false, true},
+		{"file[!0-9].txt", "file5.txt", false, false},
+		{`\#notes`, "#notes", false, true},
+		{"a+b(c).txt", "a+b(c).txt", false, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.pattern+" "+tc.path, func(t *testing.T) {
+			r, err := compile(tc.pattern)
+			require.NoError(t, err)
+			require.NotNil(t, r)
+			assert.Equal(t, tc.match, r.match(tc.path, tc.isDir))
+		})
+	}
+
+	t.Run("comments and blank lines", func(t *testing.T) {
+		for _, p := range []string{"", "   ", "# comment"} {
+			r, err := compile(p)
+			assert.NoError(t, err)
+			assert.Nil(t, r)
+		}
+	})
+
+	t.Run("negation", func(t *testing.T) {
+		r, err := compile("!keep.log")
+		require.NoError(t, err)
+		assert.True(t, r.negate)
+		assert.True(t, r.match("keep.log", false))
+	})
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate([]string{"*.tmp", "!keep.tmp", "build/"}))
+	assert.EqualError(t, Validate([]string{"*.tmp", "file[0-9.txt"}), `invalid pattern "file[0-9.txt": unclosed character class`)
+	assert.Error(t, Validate([]string{"!/"}))
+}
+
+func TestRules(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docs", "drafts"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, FileName), []byte("# root rules\n*.log\n!keep.log\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docs", FileName), []byte("drafts/\n/notes.txt\n"), 0o644))
+
+	rules, err := New(root, nil, []string{"*.swp", ".git/"})
+	require.NoError(t, err)
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"file.txt", false, false},
+		{"file.swp", false, true},
+		{"sub/.file.swp", false, true},
+		{".git", true, true},
+		{".git/objects/ab", false, true},
+		{"app.log", false, true},
+		{"docs/app.log", false, true},
+		{"keep.log", false, false},
+		{"docs/drafts", true, true},
+		{"docs/drafts/plan.txt", false, true},
+		{"docs/notes.txt", false, true},
+		{"docs/sub/notes.txt", false, false},
+		{"notes.txt", false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.path, func(t *testing.T) {
+			assert.Equal(t, tc.ignored, rules.Ignored(filepath.Join(root, filepath.FromSlash(tc.path)), tc.isDir))
+		})
+	}
+
+	t.Run("outside root", func(t *testing.T) {
+		assert.False(t, rules.Ignored(filepath.Join(filepath.Dir(root), "file.swp"), false))
+		assert.False(t, rules.Ignored(root, true))
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		path := filepath.Join(root, "docs", "report.tmp")
+		assert.False(t, rules.Ignored(path, false))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "docs", FileName), []byte("*.tmp\n"), 0o644))
+		assert.False(t, rules.Ignored(path, false))
+		rules.Invalidate(filepath.Join(root, "docs"))
+		assert.True(t, rules.Ignored(path, false))
+		assert.False(t, rules.Ignored(filepath.Join(root, "docs", "drafts"), true))
+	})
+
+	t.Run("include", func(t *testing.T) {
+		rules, err := New(root, []string{"*.txt", "*.md", "!secret.txt"}, []string{"tmp/"})
+		require.NoError(t, err)
+		assert.False(t, rules.Ignored(filepath.Join(root, "docs", "guide.md"), false))
+		assert.False(t, rules.Ignored(filepath.Join(root, "docs"), true))
+		assert.True(t, rules.Ignored(filepath.Join(root, "image.png"), false))
+		assert.True(t, rules.Ignored(filepath.Join(root, "secret.txt"), false))
+		assert.True(t, rules.Ignored(filepath.Join(root, "tmp", "file.txt"), false))
+	})
+
+	t.Run("nil rules", func(t *testing.T) {
+		var rules *Rules
+		assert.False(t, rules.Ignored(filepath.Join(root, "file.swp"), false))
+		rules.Invalidate(root)
+	})
+}
@@ -11,6 +11,8 @@ import (
 type Logger interface {
 	Error(msg, event, path string, err error)
 	Info(msg, event, path string)
+	Debug(msg, event, path string)
+	WithJob(name string) Logger
 }
 
 type DefaultLogger struct {
@@ -36,13 +38,33 @@ func (dl *DefaultLogger) Info(msg, event, path string) {
 	)
 }
 
+// Debug inserts debug level log entry. It cleans the
+// provided path by fixing the colon character if any.
+func (dl *DefaultLogger) Debug(msg, event, path string) {
+	dl.Log.Debug(msg,
+		slog.String("event", event),
+		slog.String("path", utils.FixColonCharacter(path)),
+	)
+}
+
+// WithJob provides a logger which adds the job `name`
+// to each log entry. It shares the same output.
+func (dl *DefaultLogger) WithJob(name string) Logger {
+	return &DefaultLogger{dl.Log.With(slog.String("job", name))}
+}
+
 // setupLogger creates or opens the app log file (default to`file.log`) and initialize
-// an instance of slog with some predefined attributes for app logging.
-func New(filename, commit, tag string, pid int) (*os.File, Logger, error) {
+// an instance of slog with some predefined attributes for app logging. Debug level
+// entries are only written when `debug` is set.
+func New(filename, commit, tag string, pid int, debug bool) (*os.File, Logger, error) {
 	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create log file: %v", err)
 	}
-	logger := slog.New(slog.NewJSONHandler(file, nil)).With(slog.String("commit", commit), slog.String("tag", tag), slog.Int("pid", pid))
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if debug {
+		opts.Level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewJSONHandler(file, opts)).With(slog.String("commit", commit), slog.String("tag", tag), slog.Int("pid", pid))
 	return file, &DefaultLogger{logger}, nil
 }
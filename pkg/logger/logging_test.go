@@ -1,6 +1,9 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"testing"
@@ -20,7 +23,7 @@ func TestSetupLogger(t *testing.T) {
 	file.Close()
 
 	t.Run("should open log file", func(t *testing.T) {
-		logfile, logger, err := New(filePath, "commit", "tag", 0)
+		logfile, logger, err := New(filePath, "commit", "tag", 0, false)
 		require.NoError(t, err)
 		require.FileExists(t, filePath)
 		assert.NotNil(t, logfile)
@@ -29,7 +32,7 @@ func TestSetupLogger(t *testing.T) {
 	})
 	t.Run("should create log file", func(t *testing.T) {
 		filePath := filepath.Join(folder, "log.file")
-		logfile, logger, err := New(filePath, "commit", "tag", 0)
+		logfile, logger, err := New(filePath, "commit", "tag", 0, false)
 		require.NoError(t, err)
 		require.FileExists(t, filePath)
 		assert.NotNil(t, logfile)
@@ -39,10 +42,38 @@ func TestSetupLogger(t *testing.T) {
 
 	t.Run("should fail", func(t *testing.T) {
 		filePath := filepath.Join(folder, "noexist.folder", "log.file")
-		logfile, logger, err := New(filePath, "commit", "tag", 0)
+		logfile, logger, err := New(filePath, "commit", "tag", 0, false)
 		require.Error(t, err)
 		require.NoFileExists(t, filePath)
 		assert.Nil(t, logfile)
 		assert.Nil(t, logger)
 	})
 }
+
+func TestWithJob(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	var l Logger = &DefaultLogger{slog.New(slog.NewJSONHandler(out, nil))}
+	l.WithJob("docs").Info("success: copy file", "CREATE", "file.txt")
+
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &data))
+	assert.Equal(t, "docs", data["job"])
+	assert.Equal(t, "file.txt", data["path"])
+}
+
+func TestDebug(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "log.file")
+	for _, debug := range []bool{false, true} {
+		logfile, logger, err := New(filePath, "commit", "tag", 0, debug)
+		require.NoError(t, err)
+		logger.Debug("ignored: excluded by rules", "CREATE", "file.swp")
+		logfile.Close()
+	}
+
+	data, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &entry), "only the debug logger should write the entry")
+	assert.Equal(t, "DEBUG", entry["level"])
+	assert.Equal(t, "file.swp", entry["path"])
+}
@@ -0,0 +1,70 @@
+package metadata
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// modeMask selects the mode bits worth preserving.
+const modeMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// Metadata holds the attributes of a file preserved along its content.
+type Metadata struct {
+	Mode    fs.FileMode       `json:"mode,omitempty"` // permission bits. Zero when unknown.
+	ModTime time.Time         `json:"mtime"`
+	Owner   *Owner            `json:"owner,omitempty"`  // owner and group on Linux.
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"` // extended attributes on Linux.
+}
+
+// Owner identifies the user and the group owning a file.
+type Owner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// FromInfo returns the metadata available from the details `fi`.
+func FromInfo(fi fs.FileInfo) Metadata {
+	return Metadata{
+		Mode:    fi.Mode() & modeMask,
+		ModTime: fi.ModTime().UTC(),
+		Owner:   ownerOf(fi),
+	}
+}
+
+// Read returns the metadata of the file at `path`. Extended attributes
+// which cannot be read with the current privileges are left out.
+func Read(path string) (Metadata, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	md := FromInfo(fi)
+	md.Xattrs, err = readXattrs(path)
+	return md, err
+}
+
+// Apply sets the metadata `md` on the file at `path`. The owner and
+// the extended attributes are skipped without enough privileges. The
+// modification time is set last since other changes could update it.
+func Apply(path string, md Metadata) error {
+	if md.Owner != nil {
+		// the owner goes first since changing it clears setuid and setgid bits.
+		if err := os.Chown(path, md.Owner.UID, md.Owner.GID); err != nil && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+	}
+	if md.Mode != 0 {
+		if err := os.Chmod(path, md.Mode); err != nil {
+			return err
+		}
+	}
+	if err := writeXattrs(path, md.Xattrs); err != nil {
+		return err
+	}
+	if md.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, md.ModTime, md.ModTime)
+}
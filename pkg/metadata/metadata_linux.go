@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// ownerOf returns the owner of the file described by `fi`.
+func ownerOf(fi fs.FileInfo) *Owner {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &Owner{UID: int(st.Uid), GID: int(st.Gid)}
+}
+
+// readXattrs returns the extended attributes of the file at `path`.
+// Those which cannot be read are skipped.
+func readXattrs(path string) (map[string][]byte, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreXattrError(err)
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, ignoreXattrError(err)
+	}
+	var xattrs map[string][]byte
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := getXattr(path, string(name))
+		if err != nil {
+			if err = ignoreXattrError(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if xattrs == nil {
+			xattrs = make(map[string][]byte)
+		}
+		xattrs[string(name)] = value
+	}
+	return xattrs, nil
+}
+
+// getXattr returns the value of the extended attribute `name` of the file at `path`.
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return []byte{}, err
+	}
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, value)
+	return value[:size], err
+}
+
+// writeXattrs sets the extended attributes `xattrs` on the file at `path`.
+// Those which cannot be set with the current privileges are skipped.
+func writeXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := syscall.Setxattr(path, name, value, 0); err != nil {
+			if err = ignoreXattrError(err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ignoreXattrError drops the errors of extended attributes which are not
+// supported by the filesystem or not allowed with the current privileges.
+func ignoreXattrError(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENODATA) ||
+		errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+		return nil
+	}
+	return err
+}
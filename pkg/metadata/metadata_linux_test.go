@@ -0,0 +1,31 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattrs(t *testing.T) {
+	folder := t.TempDir()
+	src := filepath.Join(folder, "source.txt")
+	dst := filepath.Join(folder, "target.txt")
+	require.NoError(t, os.WriteFile(src, nil, 0o644))
+	require.NoError(t, os.WriteFile(dst, nil, 0o644))
+	if err := syscall.Setxattr(src, "user.origin", []byte("gobackup"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	xattrs, err := readXattrs(src)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("gobackup"), xattrs["user.origin"])
+
+	require.NoError(t, writeXattrs(dst, xattrs))
+	got, err := readXattrs(dst)
+	require.NoError(t, err)
+	assert.Equal(t, xattrs, got)
+}
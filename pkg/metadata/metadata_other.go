@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package metadata
+
+import "io/fs"
+
+// ownerOf does nothing on non-linux machines.
+func ownerOf(fs.FileInfo) *Owner {
+	return nil
+}
+
+// readXattrs does nothing on non-linux machines.
+func readXattrs(string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs does nothing on non-linux machines.
+func writeXattrs(string, map[string][]byte) error {
+	return nil
+}
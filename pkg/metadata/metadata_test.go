@@ -0,0 +1,49 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadApply(t *testing.T) {
+	folder := t.TempDir()
+	src := filepath.Join(folder, "source.sh")
+	dst := filepath.Join(folder, "target.sh")
+	require.NoError(t, os.WriteFile(src, []byte("#!/bin/sh"), 0o644))
+	require.NoError(t, os.WriteFile(dst, nil, 0o644))
+	require.NoError(t, os.Chmod(src, 0o750))
+	mtime := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+
+	md, err := Read(src)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), md.Mode)
+	assert.Equal(t, mtime, md.ModTime)
+	if runtime.GOOS == "linux" {
+		require.NotNil(t, md.Owner)
+		assert.Equal(t, os.Getuid(), md.Owner.UID)
+	}
+
+	require.NoError(t, Apply(dst, md))
+	got, err := Read(dst)
+	require.NoError(t, err)
+	assert.Equal(t, md, got)
+}
+
+func TestApply_Partial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(path, nil, 0o640))
+	mtime := time.Date(2023, 8, 14, 10, 0, 0, 0, time.UTC)
+	// unknown mode is left as is.
+	require.NoError(t, Apply(path, Metadata{ModTime: mtime}))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	assert.True(t, mtime.Equal(fi.ModTime()))
+}
@@ -0,0 +1,277 @@
+package rename
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+)
+
+// Detector turns the deletion and the creation of a same file into a single
+// RENAME event holding both paths. A file is identified by its size, its
+// modification time and the file it points to on the filesystem (device
+// and inode on Unix) recorded from the previous events of its path or
+// from the walk of the watched folder when seeded.
+type Detector struct {
+	window  time.Duration
+	limit   int                    // maximum number of known files.
+	files   map[string]fs.FileInfo // known files by path.
+	index   map[identity][]string  // paths of known files by identity.
+	pending map[string]*deletion   // deleted files waiting for their new path.
+	moved   map[string]time.Time   // previous paths of renamed files whose deletion to drop.
+}
+
+// maxFiles bounds the number of known files so watching a huge folder
+// does not use unbounded memory. Beyond it, arbitrary files are forgotten
+// and their renames show up as a deletion followed by a creation.
+const maxFiles = 100000
+
+// identity narrows the files which could be the same.
+type identity struct {
+	size  int64
+	mtime int64
+}
+
+// deletion is a DELETE event held during the window.
+type deletion struct {
+	change *events.Change
+	info   fs.FileInfo
+	at     time.Time
+}
+
+// New provides a Detector holding deletions of known files
+// during `window` in case their new path shows up.
+func New(window time.Duration) *Detector {
+	return &Detector{
+		window:  window,
+		limit:   maxFiles,
+		files:   make(map[string]fs.FileInfo),
+		index:   make(map[identity][]string),
+		pending: make(map[string]*deletion),
+		moved:   make(map[string]time.Time),
+	}
+}
+
+// Seed records the files found under `root` so the renames of files which
+// existed before watching started are detected too. It must be called
+// before Run. Entries which cannot be read are skipped.
+func (d *Detector) Seed(root string) error {
+	return filepath.WalkDir(root, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !de.Type().IsRegular() {
+			return nil
+		}
+		if fi, err := de.Info(); err == nil {
+			d.remember(path, fi)
+		}
+		return nil
+	})
+}
+
+// Run reads changes from `in` until it is closed and writes them into `out`
+// with renames paired. Held deletions are forwarded before it returns.
+func (d *Detector) Run(in <-chan *events.Change, out chan<- *events.Change) {
+	tick := d.window / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case ce, ok := <-in:
+			if !ok {
+				d.flush(out, time.Time{})
+				return
+			}
+			d.add(ce, out, time.Now())
+		case now := <-ticker.C:
+			d.flush(out, now.Add(-d.window))
+		}
+	}
+}
+
+// add handles the change `ce` based on its kind.
+func (d *Detector) add(ce *events.Change, out chan<- *events.Change, now time.Time) {
+	switch {
+	case ce.Error != nil:
+		out <- ce
+	case ce.Ops == events.DELETE:
+		d.deleted(ce, out, now)
+	case ce.Ops == events.CREATE:
+		d.created(ce, out, now)
+	case ce.Ops == events.MODIFY || ce.Ops == events.ATTRIBUTE:
+		d.record(ce.Path)
+		out <- ce
+	default:
+		out <- ce
+	}
+}
+
+// deleted holds the deletion of a known file during the window. The
+// deletion of a path already reported as renamed is dropped and the
+// deletion of a folder drops the known files it held.
+func (d *Detector) deleted(ce *events.Change, out chan<- *events.Change, now time.Time) {
+	if _, ok := d.moved[ce.Path]; ok {
+		delete(d.moved, ce.Path)
+		return
+	}
+	fi, ok := d.files[ce.Path]
+	if !ok {
+		d.forgetUnder(ce.Path)
+		out <- ce
+		return
+	}
+	d.forget(ce.Path)
+	d.pending[ce.Path] = &deletion{change: ce, info: fi, at: now}
+}
+
+// created forwards the creation of a file as a RENAME event if it is
+// a known file whose previous path does not exist anymore. Otherwise a
+// created file already holding content also gets its content copied
+// since creating its backup file only makes it empty.
+func (d *Detector) created(ce *events.Change, out chan<- *events.Change, now time.Time) {
+	delete(d.moved, ce.Path)
+	fi, err := os.Lstat(ce.Path)
+	if err != nil || !fi.Mode().IsRegular() {
+		out <- ce
+		return
+	}
+	from, ok := d.match(ce.Path, fi, now)
+	d.remember(ce.Path, fi)
+	if !ok {
+		out <- ce
+		if fi.Size() > 0 {
+			out <- &events.Change{Path: ce.Path, Ops: events.MODIFY, Type: ce.Type}
+		}
+		return
+	}
+	out <- &events.Change{Path: ce.Path, From: from, Ops: events.RENAME, Type: ce.Type}
+}
+
+// match returns the previous path of the file `fi` created at `path`
+// among held deletions then among known files which vanished.
+func (d *Detector) match(path string, fi fs.FileInfo, now time.Time) (string, bool) {
+	for from, del := range d.pending {
+		if same(del.info, fi) {
+			delete(d.pending, from)
+			return from, true
+		}
+	}
+	for _, from := range d.index[identityOf(fi)] {
+		if from == path || !same(d.files[from], fi) {
+			continue
+		}
+		if _, err := os.Lstat(from); !errors.Is(err, fs.ErrNotExist) {
+			// another link to the same file.
+			continue
+		}
+		d.forget(from)
+		d.moved[from] = now
+		return from, true
+	}
+	return "", false
+}
+
+// record updates the known state of the file at `path`. The previous
+// state is kept if the file vanished since it could have been renamed.
+func (d *Detector) record(path string) {
+	fi, err := os.Lstat(path)
+	switch {
+	case err != nil:
+	case !fi.Mode().IsRegular():
+		d.forget(path)
+	default:
+		d.remember(path, fi)
+	}
+}
+
+// remember saves `fi` as the known state of the file at `path`. An
+// arbitrary known file is forgotten first when the limit is reached.
+func (d *Detector) remember(path string, fi fs.FileInfo) {
+	d.forget(path)
+	if len(d.files) >= d.limit {
+		for known := range d.files {
+			d.forget(known)
+			break
+		}
+	}
+	d.files[path] = fi
+	key := identityOf(fi)
+	d.index[key] = append(d.index[key], path)
+}
+
+// forget drops the known state of the file at `path`.
+func (d *Detector) forget(path string) {
+	fi, ok := d.files[path]
+	if !ok {
+		return
+	}
+	delete(d.files, path)
+	key := identityOf(fi)
+	paths := d.index[key]
+	for i, p := range paths {
+		if p == path {
+			paths = append(paths[:i], paths[i+1:]...)
+			break
+		}
+	}
+	if len(paths) == 0 {
+		delete(d.index, key)
+		return
+	}
+	d.index[key] = paths
+}
+
+// forgetUnder drops the known state of the files under the folder `path`.
+func (d *Detector) forgetUnder(path string) {
+	prefix := path + string(filepath.Separator)
+	for known := range d.files {
+		if strings.HasPrefix(known, prefix) {
+			d.forget(known)
+		}
+	}
+}
+
+// flush forwards held deletions older than `before` or all of them
+// when `before` is zero since no rename could pair them anymore. The
+// renamed paths whose deletion did not show up during the window are
+// dropped too.
+func (d *Detector) flush(out chan<- *events.Change, before time.Time) {
+	for path, at := range d.moved {
+		if before.IsZero() || !at.After(before) {
+			delete(d.moved, path)
+		}
+	}
+	var expired []*deletion
+	for path, del := range d.pending {
+		if before.IsZero() || !del.at.After(before) {
+			delete(d.pending, path)
+			expired = append(expired, del)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].at.Before(expired[j].at) })
+	for _, del := range expired {
+		out <- del.change
+	}
+}
+
+// identityOf returns the identity of the file `fi`.
+func identityOf(fi fs.FileInfo) identity {
+	return identity{size: fi.Size(), mtime: fi.ModTime().UnixNano()}
+}
+
+// same reports whether `a` and `b` describe the same unchanged file.
+func same(a, b fs.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime()) && os.SameFile(a, b)
+}
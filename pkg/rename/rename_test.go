@@ -0,0 +1,203 @@
+package rename
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// start runs a Detector with a `window` and returns its input and output.
+func start(window time.Duration) (chan *events.Change, chan *events.Change) {
+	return run(New(window))
+}
+
+// run runs the Detector `d` and returns its input and output.
+func run(d *Detector) (chan *events.Change, chan *events.Change) {
+	in, out := make(chan *events.Change), make(chan *events.Change, 100)
+	go func() {
+		d.Run(in, out)
+		close(out)
+	}()
+	return in, out
+}
+
+// pass sends the change `ce` which must be forwarded as is and waits for it
+// so the Detector is done with it before the filesystem changes again.
+func pass(t *testing.T, in, out chan *events.Change, ce *events.Change) {
+	t.Helper()
+	in <- ce
+	select {
+	case got := <-out:
+		assert.Equal(t, *ce, *got)
+	case <-time.After(time.Second):
+		t.Fatal("change was not forwarded")
+	}
+}
+
+// created sends the creation of the file `path` holding content which must
+// be forwarded along with its modification so its content gets copied.
+func created(t *testing.T, in, out chan *events.Change, path string) {
+	t.Helper()
+	in <- &events.Change{Path: path, Ops: events.CREATE}
+	for _, ops := range []events.Event{events.CREATE, events.MODIFY} {
+		select {
+		case got := <-out:
+			assert.Equal(t, events.Change{Path: path, Ops: ops}, *got)
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not forwarded", ops)
+		}
+	}
+}
+
+// collect closes `in` and returns the events written into `out`.
+func collect(in, out chan *events.Change) []events.Change {
+	close(in)
+	var got []events.Change
+	for ce := range out {
+		got = append(got, *ce)
+	}
+	return got
+}
+
+func TestDetector(t *testing.T) {
+	folder := t.TempDir()
+	change := func(path string, ops events.Event) *events.Change {
+		return &events.Change{Path: path, Ops: ops}
+	}
+	write := func(name string) string {
+		path := filepath.Join(folder, name)
+		require.NoError(t, os.WriteFile(path, []byte(name), 0o644))
+		return path
+	}
+
+	t.Run("creation before deletion", func(t *testing.T) {
+		old := write("old.txt")
+		in, out := start(time.Hour)
+		created(t, in, out, old)
+		renamed := filepath.Join(folder, "renamed.txt")
+		require.NoError(t, os.Rename(old, renamed))
+		in <- change(renamed, events.CREATE)
+		in <- change(old, events.DELETE)
+		assert.Equal(t, []events.Change{{Path: renamed, From: old, Ops: events.RENAME}}, collect(in, out))
+	})
+
+	t.Run("deletion before creation", func(t *testing.T) {
+		old := write("first.txt")
+		in, out := start(time.Hour)
+		created(t, in, out, old)
+		renamed := filepath.Join(folder, "second.txt")
+		require.NoError(t, os.Rename(old, renamed))
+		in <- change(old, events.DELETE)
+		in <- change(renamed, events.CREATE)
+		assert.Equal(t, []events.Change{{Path: renamed, From: old, Ops: events.RENAME}}, collect(in, out))
+	})
+
+	t.Run("modified file", func(t *testing.T) {
+		old := write("draft.txt")
+		in, out := start(time.Hour)
+		created(t, in, out, old)
+		require.NoError(t, os.WriteFile(old, []byte("longer content"), 0o644))
+		pass(t, in, out, change(old, events.MODIFY))
+		renamed := filepath.Join(folder, "final.txt")
+		require.NoError(t, os.Rename(old, renamed))
+		in <- change(renamed, events.CREATE)
+		in <- change(old, events.DELETE)
+		assert.Equal(t, []events.Change{{Path: renamed, From: old, Ops: events.RENAME}}, collect(in, out))
+	})
+
+	t.Run("unknown deletion", func(t *testing.T) {
+		in, out := start(time.Hour)
+		gone := filepath.Join(folder, "unknown.txt")
+		in <- change(gone, events.DELETE)
+		select {
+		case ce := <-out:
+			assert.Equal(t, events.Change{Path: gone, Ops: events.DELETE}, *ce)
+		case <-time.After(time.Second):
+			t.Fatal("unknown deletion was held")
+		}
+		collect(in, out)
+	})
+
+	t.Run("deletion after window", func(t *testing.T) {
+		path := write("deleted.txt")
+		in, out := start(50 * time.Millisecond)
+		created(t, in, out, path)
+		require.NoError(t, os.Remove(path))
+		in <- change(path, events.DELETE)
+		select {
+		case ce := <-out:
+			assert.Equal(t, events.Change{Path: path, Ops: events.DELETE}, *ce)
+		case <-time.After(time.Second):
+			t.Fatal("deletion was not forwarded after the window")
+		}
+		collect(in, out)
+	})
+
+	t.Run("seeded file", func(t *testing.T) {
+		seeded := t.TempDir()
+		old := filepath.Join(seeded, "docs", "existing.txt")
+		require.NoError(t, os.MkdirAll(filepath.Dir(old), 0o755))
+		require.NoError(t, os.WriteFile(old, []byte("existing"), 0o644))
+		d := New(time.Hour)
+		require.NoError(t, d.Seed(seeded))
+		in, out := run(d)
+		renamed := filepath.Join(seeded, "moved.txt")
+		require.NoError(t, os.Rename(old, renamed))
+		in <- change(old, events.DELETE)
+		in <- change(renamed, events.CREATE)
+		assert.Equal(t, []events.Change{{Path: renamed, From: old, Ops: events.RENAME}}, collect(in, out))
+	})
+
+	t.Run("empty creation", func(t *testing.T) {
+		path := filepath.Join(folder, "empty.txt")
+		require.NoError(t, os.WriteFile(path, nil, 0o644))
+		in, out := start(time.Hour)
+		pass(t, in, out, change(path, events.CREATE))
+		assert.Empty(t, collect(in, out))
+	})
+
+	t.Run("deleted folder", func(t *testing.T) {
+		d := New(time.Hour)
+		require.NoError(t, d.Seed(folder))
+		require.NotEmpty(t, d.files)
+		in, out := run(d)
+		pass(t, in, out, change(folder, events.DELETE))
+		collect(in, out)
+		assert.Empty(t, d.files)
+		assert.Empty(t, d.index)
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		d := New(time.Hour)
+		d.limit = 2
+		require.NoError(t, d.Seed(folder))
+		assert.Len(t, d.files, 2)
+		n := 0
+		for _, paths := range d.index {
+			n += len(paths)
+		}
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("expired rename", func(t *testing.T) {
+		d := New(time.Hour)
+		d.moved["gone.txt"] = time.Now().Add(-2 * time.Hour)
+		d.flush(make(chan *events.Change), time.Now().Add(-time.Hour))
+		assert.Empty(t, d.moved)
+	})
+
+	t.Run("another link", func(t *testing.T) {
+		path := write("linked.txt")
+		link := filepath.Join(folder, "link.txt")
+		require.NoError(t, os.Link(path, link))
+		in, out := start(time.Hour)
+		created(t, in, out, path)
+		created(t, in, out, link)
+		collect(in, out)
+	})
+}
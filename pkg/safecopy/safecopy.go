@@ -0,0 +1,104 @@
+package safecopy
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrChanged means the file was modified while being copied.
+var ErrChanged = errors.New("file changed during copy")
+
+// closeCheckInterval is the delay between two checks of writers of a file.
+const closeCheckInterval = 100 * time.Millisecond
+
+// Reader reads a file to the end then ensures it was not modified
+// meanwhile. Otherwise the last read fails with ErrChanged so the
+// partial copy could be discarded and retried.
+type Reader struct {
+	f      *os.File
+	before fs.FileInfo
+	read   int64
+}
+
+// NewReader provides a Reader of the opened file `f` from its current offset.
+func NewReader(f *os.File) (*Reader, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{f: f, before: fi, read: offset}, nil
+}
+
+// Read implements io.Reader interface.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.read += int64(n)
+	if r.read > r.before.Size() {
+		return n, ErrChanged
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	after, serr := r.f.Stat()
+	if serr != nil {
+		return n, serr
+	}
+	if r.read != r.before.Size() || Changed(r.before, after) {
+		return n, ErrChanged
+	}
+	return n, io.EOF
+}
+
+// Changed reports whether the file described by `before` got another
+// size or modification time as described by `after`.
+func Changed(before, after fs.FileInfo) bool {
+	return before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime())
+}
+
+// WaitClosed waits up to `timeout` for the file at `path` to be closed by
+// the processes writing into it. It reports whether no writer was left.
+// Writers are only known on Linux, elsewhere it returns true at once.
+func WaitClosed(path string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !openForWriting(path) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(closeCheckInterval)
+	}
+}
+
+// Copy copies the file `src` into the file `dst`. The content is shared
+// (reflink) when the filesystem supports it which makes a cheap point in
+// time copy. Otherwise it is copied (through copy_file_range on Linux) and
+// ErrChanged is returned if `src` was modified meanwhile.
+func Copy(dst, src *os.File) error {
+	if err := clone(dst, src); err == nil {
+		return nil
+	}
+	before, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		return err
+	}
+	after, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if Changed(before, after) {
+		return ErrChanged
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build linux
+// +build linux
+
+package safecopy
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ficlone is the ioctl request sharing the content of a file with another.
+const ficlone = 0x40049409
+
+// clone makes `dst` share the content of `src` on filesystems supporting
+// reflinks like btrfs or xfs.
+func clone(dst, src *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openForWriting reports whether a process holds the file at `path` open
+// for writing. Processes whose descriptors cannot be read are skipped.
+func openForWriting(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/[0-9]*")
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err != nil || target != path {
+			continue
+		}
+		dir, num := filepath.Split(fd)
+		if writable(filepath.Join(filepath.Dir(filepath.Clean(dir)), "fdinfo", num)) {
+			return true
+		}
+	}
+	return false
+}
+
+// writable reports whether the descriptor described by the fdinfo file
+// at `path` was opened for writing.
+func writable(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "flags:")
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseInt(strings.TrimSpace(value), 8, 64)
+		return err == nil && flags&syscall.O_ACCMODE != syscall.O_RDONLY
+	}
+	return false
+}
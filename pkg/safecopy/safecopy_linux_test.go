@@ -0,0 +1,32 @@
+package safecopy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+
+	// readers do not prevent the copy.
+	r, err := os.Open(path)
+	require.NoError(t, err)
+	defer r.Close()
+	assert.True(t, WaitClosed(path, 0))
+
+	w, err := os.OpenFile(path, os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	assert.False(t, WaitClosed(path, 150*time.Millisecond))
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		w.Close()
+	}()
+	assert.True(t, WaitClosed(path, 5*time.Second))
+}
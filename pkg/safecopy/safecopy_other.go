@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package safecopy
+
+import (
+	"errors"
+	"os"
+)
+
+// clone is not supported on non-linux machines.
+func clone(_, _ *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// openForWriting cannot tell writers on non-linux machines.
+func openForWriting(string) bool {
+	return false
+}
@@ -0,0 +1,64 @@
+package safecopy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, []byte("first line\n"), 0o644))
+
+	t.Run("unchanged file", func(t *testing.T) {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		r, err := NewReader(f)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "first line\n", string(data))
+	})
+
+	t.Run("appended during copy", func(t *testing.T) {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer f.Close()
+		r, err := NewReader(f)
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = r.Read(buf)
+		require.NoError(t, err)
+
+		w, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = w.WriteString("second line\n")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrChanged)
+	})
+}
+
+func TestCopy(t *testing.T) {
+	folder := t.TempDir()
+	path := filepath.Join(folder, "data.db")
+	require.NoError(t, os.WriteFile(path, []byte("records"), 0o644))
+	src, err := os.Open(path)
+	require.NoError(t, err)
+	defer src.Close()
+	dst, err := os.Create(filepath.Join(folder, "data.db.bak"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	require.NoError(t, Copy(dst, src))
+	data, err := os.ReadFile(dst.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "records", string(data))
+}
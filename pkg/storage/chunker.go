@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	// minimal size of a chunk, no boundary is looked for before.
+	minChunkSize = 16 * 1024
+	// maximal size of a chunk, a boundary is forced when reached.
+	maxChunkSize = 256 * 1024
+	// mask applied on the rolling hash to find boundaries. It uses the
+	// 16 highest bits which depend on the last 64 bytes read. Chunks are
+	// 64 KiB on average (after the minimum).
+	chunkMask = uint64(0xFFFF) << 48
+)
+
+// gearTable holds a pseudo-random value for each byte value.
+// It is built once with a fixed seed so that boundaries are
+// stable across runs and machines.
+var gearTable = newGearTable(0x9E3779B97F4A7C15)
+
+// newGearTable builds the gear table with splitmix64 generator.
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		seed += 0x9E3779B97F4A7C15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// chunker splits a stream into content-defined chunks using a gear
+// rolling hash. Boundaries depend only on the content around them,
+// so an insertion inside a file only changes the chunks around it.
+type chunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+// newChunker provides a chunker reading from `r`.
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: bufio.NewReaderSize(r, maxChunkSize), buf: make([]byte, 0, maxChunkSize)}
+}
+
+// Next returns the next chunk. The returned slice is only valid until
+// the next call. It returns io.EOF once the stream is fully consumed.
+func (c *chunker) Next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(c.buf) > 0 {
+				return c.buf, nil
+			}
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		hash = (hash << 1) + gearTable[b]
+		size := len(c.buf)
+		if (size >= minChunkSize && hash&chunkMask == 0) || size >= maxChunkSize {
+			return c.buf, nil
+		}
+	}
+}
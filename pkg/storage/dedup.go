@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// name of the folder holding all chunks inside the store root.
+	ChunksFolder = ".chunks"
+	// format identifier written into each manifest.
+	manifestFormat = "gobackup-dedup/1"
+)
+
+// ErrNotManifest is returned when a file is not a dedup manifest.
+var ErrNotManifest = errors.New("not a dedup manifest")
+
+// Manifest describes the content of a stored file as the ordered
+// list of the SHA-256 hashes of its chunks.
+type Manifest struct {
+	Format string   `json:"format"`
+	Size   int64    `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+// Dedup is a content-addressable store. Files are split into content-defined
+// chunks and each chunk is stored once under its SHA-256 hash. Each file is
+// saved as a small manifest at its usual path so identical or mostly-identical
+// files share their chunks.
+type Dedup struct {
+	root string
+}
+
+// NewDedup provides a dedup store located in the `root` folder.
+func NewDedup(root string) *Dedup {
+	return &Dedup{root: root}
+}
+
+// IsDedup checks if the folder `root` holds a dedup store.
+func IsDedup(root string) bool {
+	fi, err := os.Stat(filepath.Join(root, ChunksFolder))
+	return err == nil && fi.IsDir()
+}
+
+// chunkPath returns the path of a chunk based on its hash. Chunks are
+// spread into sub-folders named by the first two hex characters.
+func (d *Dedup) chunkPath(hash string) string {
+	return filepath.Join(d.root, ChunksFolder, hash[:2], hash)
+}
+
+// putChunk saves a chunk unless it already exists. It returns the chunk hash.
+func (d *Dedup) putChunk(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	cpath := d.chunkPath(hash)
+	if _, err := os.Stat(cpath); err == nil {
+		return hash, nil
+	}
+	return hash, WriteFileAtomic(cpath, data)
+}
+
+// path converts a name into the absolute path of its manifest.
+func (d *Dedup) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+// Put stores the content read from `r` as the file `name`. The name is
+// the slash-separated path of the file relative to the store root.
+func (d *Dedup) Put(name string, r io.Reader) error {
+	if err := checkRoot(d.root); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(d.root, ChunksFolder), 0o755); err != nil {
+		return err
+	}
+
+	m := Manifest{Format: manifestFormat, Chunks: []string{}}
+	c := newChunker(r)
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		hash, err := d.putChunk(data)
+		if err != nil {
+			return err
+		}
+		m.Chunks = append(m.Chunks, hash)
+		m.Size += int64(len(data))
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(d.path(name), data)
+}
+
+// ReadManifest loads the manifest located at `path`.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil || m.Format != manifestFormat {
+		return nil, ErrNotManifest
+	}
+	return &m, nil
+}
+
+// Get returns a reader of the original content of the file `name`.
+func (d *Dedup) Get(name string) (io.ReadCloser, error) {
+	m, err := ReadManifest(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &chunksReader{store: d, chunks: m.Chunks}, nil
+}
+
+// Stat returns the details of the file `name`. The size is the
+// one of the original content and not the one of its manifest.
+func (d *Dedup) Stat(name string) (fs.FileInfo, error) {
+	fi, err := os.Stat(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+	m, err := ReadManifest(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &objectInfo{name: fi.Name(), size: m.Size, modTime: fi.ModTime()}, nil
+}
+
+// Delete removes the manifest of the file `name`. Chunks are left
+// in place since they may be shared. Use `GC` to reclaim them.
+func (d *Dedup) Delete(name string) error {
+	return os.Remove(d.path(name))
+}
+
+// List returns the names of all files whose name starts with `prefix`.
+func (d *Dedup) List(prefix string) ([]string, error) {
+	return listFiles(d.root, prefix, map[string]bool{ChunksFolder: true})
+}
+
+// Walk calls `fn` with the name of each backup file starting with
+// `prefix` without listing them all at once. It stops at the first error.
+func (d *Dedup) Walk(prefix string, fn func(name string) error) error {
+	return walkFiles(d.root, prefix, map[string]bool{ChunksFolder: true}, fn)
+}
+
+// Rename moves the manifest of the file `from` to `to`.
+func (d *Dedup) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(d.path(to)), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(d.path(from), d.path(to))
+}
+
+// CreateFolder creates the folder `name` and its parents.
+func (d *Dedup) CreateFolder(name string) error {
+	if err := checkRoot(d.root); err != nil {
+		return err
+	}
+	return os.MkdirAll(d.path(name), 0o755)
+}
+
+// GC removes all chunks which are not referenced by any manifest. It must
+// not run concurrently with `Put` which may write chunks before its manifest.
+// It returns the number of chunks removed.
+func (d *Dedup) GC() (int, error) {
+	used := make(map[string]struct{})
+	chunks := filepath.Join(d.root, ChunksFolder)
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == chunks {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		m, err := ReadManifest(path)
+		if err != nil {
+			return nil
+		}
+		for _, hash := range m.Chunks {
+			used[hash] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	err = filepath.WalkDir(chunks, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if _, ok := used[entry.Name()]; ok {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	return removed, err
+}
+
+// chunksReader reads sequentially the chunks of a file.
+type chunksReader struct {
+	store   *Dedup
+	chunks  []string
+	current *os.File
+}
+
+// Read implements io.Reader by reading each chunk file in order.
+func (cr *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if cr.current == nil {
+			if len(cr.chunks) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(cr.store.chunkPath(cr.chunks[0]))
+			if err != nil {
+				return 0, err
+			}
+			cr.current = f
+			cr.chunks = cr.chunks[1:]
+		}
+		n, err := cr.current.Read(p)
+		if err == io.EOF {
+			cr.current.Close()
+			cr.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close releases the chunk file being read if any.
+func (cr *chunksReader) Close() error {
+	if cr.current == nil {
+		return nil
+	}
+	return cr.current.Close()
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// randomData returns `size` pseudo-random bytes generated from `seed`.
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// countChunks returns the number of chunks saved into the store.
+func countChunks(t *testing.T, root string) int {
+	t.Helper()
+	count := 0
+	err := filepath.Walk(filepath.Join(root, ChunksFolder), func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			count++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return count
+}
+
+func TestChunker(t *testing.T) {
+	data := randomData(1, 2*1024*1024)
+	c := newChunker(bytes.NewReader(data))
+	var total int
+	var rebuilt []byte
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if total+len(chunk) < len(data) {
+			assert.GreaterOrEqual(t, len(chunk), minChunkSize)
+		}
+		assert.LessOrEqual(t, len(chunk), maxChunkSize)
+		total += len(chunk)
+		rebuilt = append(rebuilt, chunk...)
+	}
+	assert.Equal(t, data, rebuilt)
+}
+
+func TestDedup_PutGet(t *testing.T) {
+	root, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	store := NewDedup(root)
+	data := randomData(2, 1024*1024)
+	require.NoError(t, store.Put("a/file.bak", bytes.NewReader(data)))
+	assert.Equal(t, true, IsDedup(root))
+
+	r, err := store.Get("a/file.bak")
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, data, got)
+
+	m, err := ReadManifest(filepath.Join(root, "a", "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), m.Size)
+
+	t.Run("empty file", func(t *testing.T) {
+		require.NoError(t, store.Put("empty.bak", bytes.NewReader(nil)))
+		r, err := store.Get("empty.bak")
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, 0, len(got))
+	})
+
+	t.Run("not a manifest", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(root, "raw.bak"), []byte("raw"), 0o644))
+		_, err := store.Get("raw.bak")
+		assert.ErrorIs(t, err, ErrNotManifest)
+	})
+}
+
+func TestDedup_SharedChunks(t *testing.T) {
+	root, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	store := NewDedup(root)
+	data := randomData(3, 2*1024*1024)
+	require.NoError(t, store.Put("one.bak", bytes.NewReader(data)))
+	chunks := countChunks(t, root)
+	require.Greater(t, chunks, 1)
+
+	// same content under another name costs no new chunk.
+	require.NoError(t, store.Put("two.bak", bytes.NewReader(data)))
+	assert.Equal(t, chunks, countChunks(t, root))
+
+	// a small insertion only adds the few chunks around it.
+	edited := append(append(append([]byte{}, data[:1024*1024]...), []byte("inserted")...), data[1024*1024:]...)
+	require.NoError(t, store.Put("three.bak", bytes.NewReader(edited)))
+	assert.LessOrEqual(t, countChunks(t, root), chunks+2)
+
+	t.Run("gc", func(t *testing.T) {
+		require.NoError(t, store.Delete("three.bak"))
+		removed, err := store.GC()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, removed, 2)
+		assert.Equal(t, chunks, countChunks(t, root))
+
+		require.NoError(t, store.Delete("one.bak"))
+		removed, err = store.GC()
+		require.NoError(t, err)
+		assert.Equal(t, 0, removed)
+
+		require.NoError(t, store.Delete("two.bak"))
+		removed, err = store.GC()
+		require.NoError(t, err)
+		assert.Equal(t, chunks, removed)
+	})
+}
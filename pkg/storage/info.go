@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"io/fs"
+	"time"
+)
+
+// objectInfo implements fs.FileInfo for stored objects which
+// are not plain files (manifests, remote objects).
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (oi *objectInfo) Name() string       { return oi.name }
+func (oi *objectInfo) Size() int64        { return oi.size }
+func (oi *objectInfo) Mode() fs.FileMode  { return 0o644 }
+func (oi *objectInfo) ModTime() time.Time { return oi.modTime }
+func (oi *objectInfo) IsDir() bool        { return false }
+func (oi *objectInfo) Sys() any           { return nil }
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeamon/gobackup/pkg/metadata"
+	"github.com/jeamon/gobackup/pkg/safecopy"
+)
+
+// prefix of temporary files written before being renamed.
+const tempFilePrefix = ".tmp-"
+
+// Local stores backup files as plain copies into a local folder.
+// Names are slash-separated paths relative to the `root` folder.
+type Local struct {
+	root string
+}
+
+// NewLocal provides a local filesystem store located in the `root` folder.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// path converts a name into its absolute path inside the root folder.
+func (l *Local) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(name))
+}
+
+// Put writes the content read from `r` into the file `name`. The file
+// is either its previous or its new complete version, even on crash.
+// Missing intermediate folders are created.
+func (l *Local) Put(name string, r io.Reader) error {
+	if err := checkRoot(l.root); err != nil {
+		return err
+	}
+	return writeAtomic(l.path(name), r)
+}
+
+// CopyFile copies the local file at `src` into the file `name` like Put. The
+// content is shared with `src` when the filesystem supports reflinks which
+// makes a cheap point in time copy. Otherwise safecopy.ErrChanged reports
+// a modification of `src` during the copy.
+func (l *Local) CopyFile(name, src string) error {
+	if err := checkRoot(l.root); err != nil {
+		return err
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return fillAtomic(l.path(name), func(f *os.File) error {
+		return safecopy.Copy(f, r)
+	})
+}
+
+// Get opens the file `name` for reading.
+func (l *Local) Get(name string) (io.ReadCloser, error) {
+	return os.Open(l.path(name))
+}
+
+// Stat returns the details of the file `name`.
+func (l *Local) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(l.path(name))
+}
+
+// Delete removes the file `name`.
+func (l *Local) Delete(name string) error {
+	return os.Remove(l.path(name))
+}
+
+// List returns the names of all files whose name starts with `prefix`.
+func (l *Local) List(prefix string) ([]string, error) {
+	return listFiles(l.root, prefix, nil)
+}
+
+// Walk calls `fn` with the name of each file starting with `prefix`
+// without listing them all at once. It stops at the first error.
+func (l *Local) Walk(prefix string, fn func(name string) error) error {
+	return walkFiles(l.root, prefix, nil, fn)
+}
+
+// Rename moves the file `from` to `to` without copying its content.
+func (l *Local) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(l.path(to)), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(l.path(from), l.path(to))
+}
+
+// SetMetadata sets the metadata `md` on the file `name`.
+func (l *Local) SetMetadata(name string, md metadata.Metadata) error {
+	return metadata.Apply(l.path(name), md)
+}
+
+// CreateFolder creates the folder `name` and its parents.
+func (l *Local) CreateFolder(name string) error {
+	if err := checkRoot(l.root); err != nil {
+		return err
+	}
+	return os.MkdirAll(l.path(name), 0o755)
+}
+
+// writeAtomic streams the content read from `r` into a temporary file next
+// to `path`, flushes it to disk then renames it over `path` so readers never
+// see a partially written file. The temporary file is removed on failure.
+func writeAtomic(path string, r io.Reader) error {
+	return fillAtomic(path, func(f *os.File) error {
+		_, err := io.Copy(f, r)
+		return err
+	})
+}
+
+// WriteFileAtomic writes `data` into the file at `path` like writeAtomic.
+// It is shared by all journals so they survive a crash the same way.
+func WriteFileAtomic(path string, data []byte) error {
+	return writeAtomic(path, bytes.NewReader(data))
+}
+
+// fillAtomic writes into a temporary file next to `path` with `fill` then
+// replaces `path` with it the same way as writeAtomic.
+func fillAtomic(path string, fill func(f *os.File) error) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = fill(f); err != nil {
+		return err
+	}
+	if err = f.Chmod(0o644); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes the entries of the folder `dir` so a rename survives a
+// crash. It is best effort since some platforms cannot sync folders.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// checkRoot ensures the store root folder exists. It is never re-created
+// so an unmounted or removed backup location is reported instead of hidden.
+func checkRoot(root string) error {
+	if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+		return fmt.Errorf("backup folder %q is not accessible", root)
+	}
+	return nil
+}
+
+// listFiles walks the `root` folder and returns the slash-separated names of
+// regular files starting with `prefix`. Folders named into `skip` and
+// temporary files are ignored.
+func listFiles(root, prefix string, skip map[string]bool) ([]string, error) {
+	names := []string{}
+	err := walkFiles(root, prefix, skip, func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	return names, err
+}
+
+// walkFiles walks the `root` folder like listFiles but calls `fn` with
+// the name of each file as soon as it is found. The walk starts from the
+// folder of `prefix` and skips folders whose files cannot match it.
+func walkFiles(root, prefix string, skip map[string]bool, fn func(name string) error) error {
+	start := root
+	if dir := path.Dir(prefix); dir != "." {
+		start = filepath.Join(root, filepath.FromSlash(dir))
+	}
+	return filepath.WalkDir(start, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if fpath == start && start != root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if fpath == root {
+				return nil
+			}
+			if skip[d.Name()] {
+				return filepath.SkipDir
+			}
+			if name += "/"; !strings.HasPrefix(name, prefix) && !strings.HasPrefix(prefix, name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+		if strings.HasPrefix(name, prefix) {
+			return fn(name)
+		}
+		return nil
+	})
+}
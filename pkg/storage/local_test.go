@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	root, err := os.MkdirTemp("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	store := NewLocal(root)
+	require.NoError(t, store.Put("a/b/report.txt.bak", strings.NewReader("report")))
+	require.NoError(t, store.Put("notes.txt.bak", strings.NewReader("notes")))
+	assert.FileExists(t, filepath.Join(root, "a", "b", "report.txt.bak"))
+
+	t.Run("get", func(t *testing.T) {
+		r, err := store.Get("a/b/report.txt.bak")
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "report", string(data))
+	})
+
+	t.Run("stat", func(t *testing.T) {
+		fi, err := store.Stat("notes.txt.bak")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), fi.Size())
+	})
+
+	t.Run("list", func(t *testing.T) {
+		names, err := store.List("")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"a/b/report.txt.bak", "notes.txt.bak"}, names)
+		names, err = store.List("a/")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a/b/report.txt.bak"}, names)
+
+		// the walk starts from the folder of the prefix.
+		for prefix, expected := range map[string][]string{
+			"a/b/rep":   {"a/b/report.txt.bak"},
+			"a/b":       {"a/b/report.txt.bak"},
+			"a/c":       {},
+			"missing/x": {},
+			"notes":     {"notes.txt.bak"},
+		} {
+			names, err = store.List(prefix)
+			require.NoError(t, err)
+			assert.Equal(t, expected, names, prefix)
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		var names []string
+		require.NoError(t, store.Walk("", func(name string) error {
+			names = append(names, name)
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{"a/b/report.txt.bak", "notes.txt.bak"}, names)
+		stop := errors.New("stop")
+		assert.ErrorIs(t, store.Walk("", func(string) error { return stop }), stop)
+	})
+
+	t.Run("rename and delete", func(t *testing.T) {
+		require.NoError(t, store.Rename("notes.txt.bak", "c/notes.txt.bak"))
+		assert.FileExists(t, filepath.Join(root, "c", "notes.txt.bak"))
+		require.NoError(t, store.Delete("c/notes.txt.bak"))
+		assert.NoFileExists(t, filepath.Join(root, "c", "notes.txt.bak"))
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		require.NoError(t, store.Put("long.txt.bak", strings.NewReader("a longer content")))
+		require.NoError(t, store.Put("long.txt.bak", strings.NewReader("short")))
+		data, err := os.ReadFile(filepath.Join(root, "long.txt.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "short", string(data))
+
+		// a failed write keeps the previous complete version.
+		r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("read failure")))
+		assert.EqualError(t, store.Put("long.txt.bak", r), "read failure")
+		data, err = os.ReadFile(filepath.Join(root, "long.txt.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "short", string(data))
+		entries, err := os.ReadDir(root)
+		require.NoError(t, err)
+		for _, e := range entries {
+			assert.False(t, strings.HasPrefix(e.Name(), tempFilePrefix), "temporary file left: %s", e.Name())
+		}
+	})
+
+	t.Run("copy file", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "data.db")
+		require.NoError(t, os.WriteFile(src, []byte("records"), 0o644))
+		require.NoError(t, store.CopyFile("d/data.db.bak", src))
+		data, err := os.ReadFile(filepath.Join(root, "d", "data.db.bak"))
+		require.NoError(t, err)
+		assert.Equal(t, "records", string(data))
+	})
+
+	t.Run("missing root folder", func(t *testing.T) {
+		store := NewLocal(filepath.Join(root, "noexist.folderpath"))
+		assert.Error(t, store.Put("file.bak", strings.NewReader("")))
+		assert.Error(t, store.CopyFile("file.bak", filepath.Join(root, "notes.txt.bak")))
+		assert.Error(t, store.CreateFolder("folder"))
+		_, err := store.List("")
+		assert.Error(t, err)
+	})
+}
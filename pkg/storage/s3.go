@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// default region used to sign requests.
+	defaultS3Region = "us-east-1"
+	// default size of each part of a multipart upload. Files smaller
+	// than this size are uploaded with a single request.
+	defaultS3PartSize = 8 * 1024 * 1024
+	// maximum delay of each step of a request: dial, TLS handshake
+	// and wait of the response headers once the body is sent.
+	defaultS3Timeout = 30 * time.Second
+)
+
+// S3Options holds the settings to reach an S3-compatible bucket.
+type S3Options struct {
+	Endpoint      string // base URL of the service (e.g. `http://localhost:9000`).
+	Region        string // region used to sign requests. Default to `us-east-1`.
+	Bucket        string // name of the bucket.
+	AccessKey     string // access key id.
+	SecretKey     string // secret access key.
+	Prefix        string // key prefix of backup files into the bucket.
+	ArchivePrefix string // key prefix of uploaded zip archives.
+	PartSize      int64  // size of multipart upload parts. Default to 8 MiB.
+}
+
+// S3 stores backup files as objects into an S3-compatible bucket. It uses
+// path-style requests signed with AWS signature version 4 so that it works
+// with AWS S3 as well as self-hosted services like MinIO.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 validates the options and provides an S3 store. The backup
+// files prefix and the archives prefix must not overlap so archives
+// never end up listed as backup files.
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("missing s3 bucket name")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, fmt.Errorf("missing s3 credentials")
+	}
+	if opts.Prefix == "" || opts.ArchivePrefix == "" ||
+		strings.HasPrefix(opts.Prefix, opts.ArchivePrefix) || strings.HasPrefix(opts.ArchivePrefix, opts.Prefix) {
+		return nil, fmt.Errorf("s3 backup prefix %q and archive prefix %q must be set and not overlap", opts.Prefix, opts.ArchivePrefix)
+	}
+	if opts.Region == "" {
+		opts.Region = defaultS3Region
+	}
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultS3PartSize
+	}
+	return &S3{opts: opts, endpoint: endpoint, client: newS3Client(defaultS3Timeout)}, nil
+}
+
+// newS3Client provides an HTTP client whose transport bounds each step of
+// a request by `timeout` so a stalled connection never blocks a worker.
+// Responses bodies of large objects are streamed so they have no deadline.
+func newS3Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	}}
+}
+
+// objectURL builds the path-style URL of the object `key`. An empty
+// key targets the bucket itself.
+func (s *S3) objectURL(key string, query url.Values) string {
+	u := *s.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	u.RawPath = base + "/" + awsURIEncode(s.opts.Bucket, true)
+	u.Path = base + "/" + s.opts.Bucket
+	if key != "" {
+		u.RawPath += "/" + awsURIEncode(key, false)
+		u.Path += "/" + key
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	return u.String()
+}
+
+// s3Error is the error document returned by the service.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do signs and sends a request. Non successful responses are turned into
+// errors. Missing objects are reported with an error wrapping fs.ErrNotExist.
+func (s *S3) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, s.objectURL(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	signV4(req, hashSHA256(body), s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var serr s3Error
+	data, _ := io.ReadAll(resp.Body)
+	_ = xml.Unmarshal(data, &serr)
+	if resp.StatusCode == http.StatusNotFound && serr.Code != "NoSuchBucket" {
+		return nil, &fs.PathError{Op: strings.ToLower(method), Path: key, Err: fs.ErrNotExist}
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s %s (%s)", method, key, serr.Code, serr.Message, resp.Status)
+}
+
+// key converts a backup file name into its object key.
+func (s *S3) key(name string) string {
+	return s.opts.Prefix + name
+}
+
+// Put uploads the content read from `r` as the file `name`. Content larger
+// than the part size is sent with a multipart upload.
+func (s *S3) Put(name string, r io.Reader) error {
+	return s.upload(s.key(name), r)
+}
+
+// UploadArchive uploads a zip archive under the archive prefix.
+func (s *S3) UploadArchive(filename string, r io.Reader) error {
+	return s.upload(s.opts.ArchivePrefix+path.Base(filename), r)
+}
+
+// upload sends the content read from `r` to the object `key`.
+func (s *S3) upload(key string, r io.Reader) error {
+	first, err := readPart(r, s.opts.PartSize)
+	if err != nil {
+		return err
+	}
+	if int64(len(first)) < s.opts.PartSize {
+		resp, err := s.do(http.MethodPut, key, nil, first)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	return s.multipartUpload(key, first, r)
+}
+
+// readPart reads up to `size` bytes from `r`.
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	_, err := io.CopyN(buf, r, size)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// multipart upload documents exchanged with the service.
+type (
+	initiateMultipartResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	completeMultipartUpload struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+)
+
+// multipartUpload sends `first` then the remaining content of `r` as parts
+// of a multipart upload. The upload is aborted on failure so no orphan
+// parts are left into the bucket.
+func (s *S3) multipartUpload(key string, first []byte, r io.Reader) error {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var initiated initiateMultipartResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("s3: invalid multipart upload response: %w", err)
+	}
+
+	uploadID := initiated.UploadID
+	if err = s.uploadParts(key, uploadID, first, r); err != nil {
+		if resp, aerr := s.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil); aerr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts sends each part of a multipart upload and completes it.
+func (s *S3) uploadParts(key, uploadID string, part []byte, r io.Reader) error {
+	var completed completeMultipartUpload
+	for number := 1; len(part) > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := s.do(http.MethodPut, key, query, part)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		completed.Parts = append(completed.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		if part, err = readPart(r, s.opts.PartSize); err != nil {
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// the service may report a failure into a successful response.
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var serr s3Error
+	if xml.Unmarshal(data, &serr) == nil && serr.Code != "" {
+		return fmt.Errorf("s3: complete %s: %s %s", key, serr.Code, serr.Message)
+	}
+	return nil
+}
+
+// Get downloads the file `name`.
+func (s *S3) Get(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat returns the size and last modification time of the file `name`.
+func (s *S3) Stat(name string) (fs.FileInfo, error) {
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &objectInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+// Delete removes the file `name`.
+func (s *S3) Delete(name string) error {
+	resp, err := s.do(http.MethodDelete, s.key(name), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// listBucketResult is the document returned by a `ListObjectsV2` request.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the names of all files starting with `prefix`.
+func (s *S3) List(prefix string) ([]string, error) {
+	names := []string{}
+	query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid list response: %w", err)
+		}
+		for _, object := range result.Contents {
+			names = append(names, strings.TrimPrefix(object.Key, s.opts.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
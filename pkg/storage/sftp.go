@@ -0,0 +1,603 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// kinds of operations kept into the spool folder.
+	opPut    = "put"
+	opDelete = "delete"
+	// extensions of spool files describing an operation and its content.
+	opExtension   = ".op"
+	dataExtension = ".data"
+	// journal of operations given up and folder keeping their content.
+	deadLetterFile   = "dead.jsonl"
+	deadLetterFolder = "dead"
+	// default delays between two reconnection attempts.
+	defaultMinBackoff = 1 * time.Second
+	defaultMaxBackoff = 1 * time.Minute
+	// default number of failures of an operation once connected.
+	defaultMaxAttempts = 5
+)
+
+// ErrUnreachable is returned when the remote host cannot be reached.
+var ErrUnreachable = errors.New("remote host unreachable")
+
+// SFTPOptions holds the settings to reach the remote backup host over SSH.
+type SFTPOptions struct {
+	Address        string        // host:port of the SSH server.
+	User           string        // user to login with.
+	KeyFile        string        // private key file used for authentication.
+	KnownHostsFile string        // known_hosts file used to verify the server host key.
+	Root           string        // remote folder where to store backup files.
+	SpoolFolder    string        // local folder keeping operations not yet sent.
+	MinBackoff     time.Duration // first delay before reconnecting. Default to 1s.
+	MaxBackoff     time.Duration // maximum delay before reconnecting. Default to 1m.
+	MaxAttempts    int           // failures of an operation once connected before giving up. Default to 5.
+}
+
+// spoolOp is an operation waiting into the spool folder to be sent.
+type spoolOp struct {
+	Seq  uint64 `json:"seq"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// ready reports whether the operation is fully journaled.
+	ready bool
+}
+
+// deadOp is an operation given up after failing too many times. It is
+// appended to the dead-letter journal and its content is kept aside.
+type deadOp struct {
+	spoolOp
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+// SFTP stores backup files on a remote host over SFTP. Each write or delete is
+// first journaled into a local spool folder then sent in order by a background
+// uploader. While the link is down operations keep piling up into the spool
+// and the uploader reconnects with an exponential backoff. The spool survives
+// restarts so no change is lost. An operation failing `MaxAttempts` times while
+// connected is moved into the dead-letter journal so it does not block others.
+type SFTP struct {
+	opts   SFTPOptions
+	config *ssh.ClientConfig
+
+	mu      sync.Mutex // protects the connection.
+	conn    *ssh.Client
+	client  *sftp.Client
+	down    bool               // the last dial failed.
+	smu     sync.Mutex         // protects the spool state below.
+	seq     uint64             // sequence number of the last spooled operation.
+	queue   []spoolOp          // operations not yet sent, in order.
+	pending map[string]spoolOp // latest operation not yet sent for each name.
+	wake    chan struct{}      // signals the uploader about new operations.
+	stop    chan struct{}      // stops the uploader.
+	once    sync.Once          // closes the stop channel once.
+	done    chan struct{}      // closed once the uploader exited.
+}
+
+// NewSFTP validates the options, loads the authentication key and the known
+// hosts, reloads operations left into the spool folder and starts the uploader.
+// The remote host does not need to be reachable at that time.
+func NewSFTP(opts SFTPOptions) (*SFTP, error) {
+	if opts.Address == "" || opts.User == "" || opts.Root == "" || opts.SpoolFolder == "" {
+		return nil, fmt.Errorf("sftp address, user, remote root and spool folder are required")
+	}
+	key, err := os.ReadFile(opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sftp key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sftp key: %w", err)
+	}
+	hostKeyCallback, err := knownhosts.New(opts.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts: %w", err)
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+
+	s := &SFTP{
+		opts: opts,
+		config: &ssh.ClientConfig{
+			User:            opts.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         10 * time.Second,
+		},
+		pending: make(map[string]spoolOp),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := s.loadSpool(); err != nil {
+		return nil, err
+	}
+	go s.uploader()
+	return s, nil
+}
+
+// spoolPath returns the path of a spool file of the operation `seq`.
+func (s *SFTP) spoolPath(seq uint64, ext string) string {
+	return filepath.Join(s.opts.SpoolFolder, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+// loadSpool reloads the operations left into the spool folder.
+func (s *SFTP) loadSpool() error {
+	if err := os.MkdirAll(s.opts.SpoolFolder, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.opts.SpoolFolder)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != opExtension {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.opts.SpoolFolder, entry.Name()))
+		if err != nil {
+			return err
+		}
+		op := spoolOp{ready: true}
+		if err := json.Unmarshal(data, &op); err != nil {
+			return fmt.Errorf("invalid spool entry %s: %w", entry.Name(), err)
+		}
+		s.queue = append(s.queue, op)
+	}
+	sort.Slice(s.queue, func(i, j int) bool { return s.queue[i].Seq < s.queue[j].Seq })
+	for _, op := range s.queue {
+		s.pending[op.Name] = op
+		s.seq = op.Seq
+	}
+	return nil
+}
+
+// enqueue journals an operation into the spool folder. Its number is
+// assigned and it is queued at once so operations keep their order, then
+// it is only sent once journaled. For a put, the content read from `r` is
+// flushed to disk first so the operation is complete once its descriptor
+// exists.
+func (s *SFTP) enqueue(kind, name string, r io.Reader) error {
+	s.smu.Lock()
+	s.seq++
+	op := spoolOp{Seq: s.seq, Kind: kind, Name: name}
+	s.queue = append(s.queue, op)
+	s.smu.Unlock()
+
+	if err := s.journal(op, r); err != nil {
+		s.smu.Lock()
+		s.unqueue(op.Seq)
+		s.smu.Unlock()
+		s.notify()
+		return err
+	}
+
+	s.smu.Lock()
+	for i := range s.queue {
+		if s.queue[i].Seq == op.Seq {
+			s.queue[i].ready = true
+			op = s.queue[i]
+			break
+		}
+	}
+	if latest, ok := s.pending[name]; !ok || latest.Seq < op.Seq {
+		s.pending[name] = op
+	}
+	s.smu.Unlock()
+	s.notify()
+	return nil
+}
+
+// journal writes the spool files of the operation `op` with the content
+// read from `r` for a put.
+func (s *SFTP) journal(op spoolOp, r io.Reader) error {
+	if op.Kind == opPut {
+		f, err := os.OpenFile(s.spoolPath(op.Seq, dataExtension), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+	}
+
+	data, err := json.Marshal(op)
+	if err == nil {
+		err = WriteFileAtomic(s.spoolPath(op.Seq, opExtension), data)
+	}
+	if err != nil {
+		os.Remove(s.spoolPath(op.Seq, dataExtension))
+	}
+	return err
+}
+
+// unqueue removes the operation `seq` from the queue. The caller must hold smu.
+func (s *SFTP) unqueue(seq uint64) {
+	for i := range s.queue {
+		if s.queue[i].Seq == seq {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify wakes the uploader up if it waits for operations.
+func (s *SFTP) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// uploader sends spooled operations in order once journaled. On failure it
+// drops the connection and retries the same operation after an increasing
+// delay. Only failures once connected count toward giving up an operation
+// so an outage never sends operations to the dead-letter journal.
+func (s *SFTP) uploader() {
+	defer close(s.done)
+	backoff := s.opts.MinBackoff
+	attempts := 0
+	for {
+		s.smu.Lock()
+		var op spoolOp
+		if len(s.queue) > 0 {
+			op = s.queue[0]
+		}
+		s.smu.Unlock()
+
+		if !op.ready {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		client, err := s.connect()
+		if err == nil {
+			if err = s.send(client, op); err != nil {
+				attempts++
+			}
+		}
+		if err != nil && attempts >= s.opts.MaxAttempts {
+			log.Printf("sftp: failed to %s %s: %v. giving up after %d attempts", op.Kind, op.Name, err, attempts)
+			s.deadLetter(op, err)
+			attempts = 0
+			continue
+		}
+		if err != nil {
+			log.Printf("sftp: failed to %s %s: %v. retrying in %s", op.Kind, op.Name, err, backoff)
+			s.disconnect()
+			select {
+			case <-time.After(backoff):
+			case <-s.stop:
+				return
+			}
+			if backoff *= 2; backoff > s.opts.MaxBackoff {
+				backoff = s.opts.MaxBackoff
+			}
+			continue
+		}
+		backoff, attempts = s.opts.MinBackoff, 0
+		s.complete(op)
+	}
+}
+
+// complete removes a sent operation from the queue and the spool folder.
+func (s *SFTP) complete(op spoolOp) {
+	s.smu.Lock()
+	s.queue = s.queue[1:]
+	if latest, ok := s.pending[op.Name]; ok && latest.Seq == op.Seq {
+		delete(s.pending, op.Name)
+	}
+	s.smu.Unlock()
+	os.Remove(s.spoolPath(op.Seq, opExtension))
+	os.Remove(s.spoolPath(op.Seq, dataExtension))
+}
+
+// deadLetter gives up the operation `op` which failed with `err`. It is
+// appended to the dead-letter journal of the spool folder and its content
+// is moved into the dead-letter folder before being removed from the queue.
+func (s *SFTP) deadLetter(op spoolOp, err error) {
+	if op.Kind == opPut {
+		data := s.spoolPath(op.Seq, dataExtension)
+		dir := filepath.Join(s.opts.SpoolFolder, deadLetterFolder)
+		merr := os.MkdirAll(dir, 0o700)
+		if merr == nil {
+			merr = os.Rename(data, filepath.Join(dir, filepath.Base(data)))
+		}
+		if merr != nil {
+			log.Printf("sftp: failed to keep the content of %s: %v", op.Name, merr)
+		}
+	}
+	if jerr := s.appendDeadLetter(deadOp{spoolOp: op, Error: err.Error(), At: time.Now().UTC()}); jerr != nil {
+		log.Printf("sftp: failed to record the dead letter of %s: %v", op.Name, jerr)
+	}
+	s.complete(op)
+}
+
+// appendDeadLetter writes the operation `d` into the dead-letter journal.
+func (s *SFTP) appendDeadLetter(d deadOp) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.opts.SpoolFolder, deadLetterFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// send applies a spooled operation on the remote host with `client`. Content
+// is uploaded into a temporary file then renamed so the remote copy is never
+// partial.
+func (s *SFTP) send(client *sftp.Client, op spoolOp) error {
+	remote := s.remotePath(op.Name)
+	if op.Kind == opDelete {
+		if err := client.Remove(remote); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	r, err := os.Open(s.spoolPath(op.Seq, dataExtension))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := client.MkdirAll(path.Dir(remote)); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(remote), tempFilePrefix+path.Base(remote))
+	w, err := client.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return client.PosixRename(tmp, remote)
+}
+
+// connect returns the SFTP client, dialing the remote host if needed. The
+// dial happens without the lock so callers having a connection never wait
+// for it. The first connection set is kept if several callers dialed.
+func (s *SFTP) connect() (*sftp.Client, error) {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", s.opts.Address, s.config)
+	if err == nil {
+		if client, err = sftp.NewClient(conn); err != nil {
+			conn.Close()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = err != nil
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	if s.client != nil {
+		client.Close()
+		conn.Close()
+		return s.client, nil
+	}
+	s.conn, s.client = conn, client
+	return client, nil
+}
+
+// reach returns the SFTP client like connect but fails at once while the
+// link is known to be down and the uploader keeps retrying to send the
+// operations waiting, so reads do not wait for a dial bound to fail.
+func (s *SFTP) reach() (*sftp.Client, error) {
+	s.mu.Lock()
+	down := s.down
+	s.mu.Unlock()
+	if down && s.Pending() > 0 {
+		return nil, ErrUnreachable
+	}
+	return s.connect()
+}
+
+// lost drops the connection when `err` reports it was lost so the link is
+// known to be down. The error is then reported as ErrUnreachable.
+func (s *SFTP) lost(err error) error {
+	if !errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return err
+	}
+	s.disconnect()
+	s.mu.Lock()
+	s.down = true
+	s.mu.Unlock()
+	return fmt.Errorf("%w: %v", ErrUnreachable, err)
+}
+
+// disconnect drops the current connection if any.
+func (s *SFTP) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		s.client.Close()
+		s.conn.Close()
+		s.client, s.conn = nil, nil
+	}
+}
+
+// remotePath converts a name into its path on the remote host.
+func (s *SFTP) remotePath(name string) string {
+	return path.Join(s.opts.Root, name)
+}
+
+// latest returns the operation not yet sent for the file `name` if any.
+func (s *SFTP) latest(name string) (spoolOp, bool) {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	op, ok := s.pending[name]
+	return op, ok
+}
+
+// Put journals the content read from `r` to be uploaded as the file `name`.
+func (s *SFTP) Put(name string, r io.Reader) error {
+	return s.enqueue(opPut, name, r)
+}
+
+// Delete journals the removal of the file `name`.
+func (s *SFTP) Delete(name string) error {
+	return s.enqueue(opDelete, name, nil)
+}
+
+// Get returns a reader of the file `name`. Content not yet uploaded
+// is read from the spool folder.
+func (s *SFTP) Get(name string) (io.ReadCloser, error) {
+	if op, ok := s.latest(name); ok {
+		if op.Kind == opDelete {
+			return nil, &fs.PathError{Op: "get", Path: name, Err: fs.ErrNotExist}
+		}
+		return os.Open(s.spoolPath(op.Seq, dataExtension))
+	}
+	client, err := s.reach()
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.Open(s.remotePath(name))
+	if err != nil {
+		return nil, s.lost(err)
+	}
+	return f, nil
+}
+
+// Stat returns the details of the file `name`. Content not yet uploaded
+// is described from the spool folder.
+func (s *SFTP) Stat(name string) (fs.FileInfo, error) {
+	if op, ok := s.latest(name); ok {
+		if op.Kind == opDelete {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+		}
+		fi, err := os.Stat(s.spoolPath(op.Seq, dataExtension))
+		if err != nil {
+			return nil, err
+		}
+		return &objectInfo{name: path.Base(name), size: fi.Size(), modTime: fi.ModTime()}, nil
+	}
+	client, err := s.reach()
+	if err != nil {
+		return nil, err
+	}
+	fi, err := client.Stat(s.remotePath(name))
+	if err != nil {
+		return nil, s.lost(err)
+	}
+	return fi, nil
+}
+
+// List returns the names of all files starting with `prefix`, including
+// the ones not yet uploaded and excluding the ones waiting for deletion.
+func (s *SFTP) List(prefix string) ([]string, error) {
+	client, err := s.reach()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	walker := client.Walk(s.opts.Root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) && walker.Path() == s.opts.Root {
+				break
+			}
+			return nil, s.lost(err)
+		}
+		fi := walker.Stat()
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), tempFilePrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), s.opts.Root), "/")
+		found[name] = true
+	}
+
+	s.smu.Lock()
+	for name, op := range s.pending {
+		found[name] = op.Kind == opPut
+	}
+	s.smu.Unlock()
+
+	names := []string{}
+	for name, exists := range found {
+		if exists && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Pending returns the number of operations not yet sent.
+func (s *SFTP) Pending() int {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	return len(s.queue)
+}
+
+// Close stops the uploader and drops the connection. Operations
+// not yet sent stay into the spool folder for the next run.
+func (s *SFTP) Close() error {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+	s.disconnect()
+	return nil
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeamon/gobackup/pkg/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSFTP starts an in-process SSH server and provides an SFTP store
+// pointing to it with its remote and spool folders.
+func newTestSFTP(t *testing.T) (*SFTP, *testhelpers.SSHServer, SFTPOptions) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "sftp")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	server, err := testhelpers.NewSSHServer(dir)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	opts := SFTPOptions{
+		Address:        server.Addr,
+		User:           server.User,
+		KeyFile:        server.KeyFile,
+		KnownHostsFile: server.KnownHostsFile,
+		Root:           filepath.ToSlash(filepath.Join(dir, "remote")),
+		SpoolFolder:    filepath.Join(dir, "spool"),
+		MinBackoff:     10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}
+	store, err := NewSFTP(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store, server, opts
+}
+
+// waitSent waits until all spooled operations were sent.
+func waitSent(t *testing.T, store *SFTP) {
+	t.Helper()
+	require.Eventually(t, func() bool { return store.Pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewSFTP(t *testing.T) {
+	_, _, opts := newTestSFTP(t)
+
+	cases := map[string]func(o *SFTPOptions){
+		"missing address":     func(o *SFTPOptions) { o.Address = "" },
+		"missing root":        func(o *SFTPOptions) { o.Root = "" },
+		"missing key":         func(o *SFTPOptions) { o.KeyFile = filepath.Join(o.SpoolFolder, "none") },
+		"missing known hosts": func(o *SFTPOptions) { o.KnownHostsFile = filepath.Join(o.SpoolFolder, "none") },
+	}
+	for name, change := range cases {
+		t.Run(name, func(t *testing.T) {
+			o := opts
+			change(&o)
+			_, err := NewSFTP(o)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	store, _, opts := newTestSFTP(t)
+
+	require.NoError(t, store.Put("a/report.txt.bak", strings.NewReader("hello")))
+	require.NoError(t, store.Put("b.bak", strings.NewReader("world")))
+	waitSent(t, store)
+
+	data, err := os.ReadFile(filepath.Join(filepath.FromSlash(opts.Root), "a", "report.txt.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	r, err := store.Get("b.bak")
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	fi, err := store.Stat("a/report.txt.bak")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), fi.Size())
+
+	names, err := store.List("")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/report.txt.bak", "b.bak"}, names)
+
+	require.NoError(t, store.Delete("a/report.txt.bak"))
+	waitSent(t, store)
+	_, err = store.Stat("a/report.txt.bak")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	// deleting a missing file is not an error.
+	require.NoError(t, store.Delete("a/report.txt.bak"))
+	waitSent(t, store)
+}
+
+func TestSFTP_UnknownHostKey(t *testing.T) {
+	_, server, opts := newTestSFTP(t)
+	require.NoError(t, os.WriteFile(server.KnownHostsFile, nil, 0o600))
+
+	store, err := NewSFTP(opts)
+	require.NoError(t, err)
+	defer store.Close()
+	_, err = store.List("")
+	assert.Error(t, err)
+}
+
+func TestSFTP_Outage(t *testing.T) {
+	store, server, opts := newTestSFTP(t)
+	server.Stop()
+
+	require.NoError(t, store.Put("file.bak", strings.NewReader("first")))
+	require.NoError(t, store.Put("file.bak", strings.NewReader("second")))
+	require.NoError(t, store.Put("gone.bak", strings.NewReader("x")))
+	require.NoError(t, store.Delete("gone.bak"))
+
+	// spooled content is visible while the link is down.
+	r, err := store.Get("file.bak")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	_, err = store.Stat("gone.bak")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 4, store.Pending())
+	// files not spooled are not looked for while the link is known to be down.
+	_, err = store.Stat("other.bak")
+	assert.ErrorIs(t, err, ErrUnreachable)
+
+	require.NoError(t, server.Start())
+	waitSent(t, store)
+	data, err = os.ReadFile(filepath.Join(filepath.FromSlash(opts.Root), "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	assert.NoFileExists(t, filepath.Join(filepath.FromSlash(opts.Root), "gone.bak"))
+}
+
+func TestSFTP_SpoolReplay(t *testing.T) {
+	store, server, opts := newTestSFTP(t)
+	server.Stop()
+	require.NoError(t, store.Put("file.bak", strings.NewReader("content")))
+	require.NoError(t, store.Close())
+
+	require.NoError(t, server.Start())
+	store, err := NewSFTP(opts)
+	require.NoError(t, err)
+	defer store.Close()
+	waitSent(t, store)
+
+	data, err := os.ReadFile(filepath.Join(filepath.FromSlash(opts.Root), "file.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+	entries, err := os.ReadDir(opts.SpoolFolder)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSFTP_DeadLetter(t *testing.T) {
+	store, _, opts := newTestSFTP(t)
+	require.NoError(t, store.Put("blocker", strings.NewReader("file")))
+	// the remote folder cannot be created over a file so it never succeeds.
+	require.NoError(t, store.Put("blocker/file.bak", strings.NewReader("lost")))
+	require.NoError(t, store.Put("after.bak", strings.NewReader("sent")))
+	waitSent(t, store)
+
+	data, err := os.ReadFile(filepath.Join(filepath.FromSlash(opts.Root), "after.bak"))
+	require.NoError(t, err)
+	assert.Equal(t, "sent", string(data))
+
+	data, err = os.ReadFile(filepath.Join(opts.SpoolFolder, deadLetterFile))
+	require.NoError(t, err)
+	var dead deadOp
+	require.NoError(t, json.Unmarshal(data, &dead))
+	assert.Equal(t, "blocker/file.bak", dead.Name)
+	assert.Equal(t, opPut, dead.Kind)
+	assert.NotEmpty(t, dead.Error)
+	data, err = os.ReadFile(filepath.Join(opts.SpoolFolder, deadLetterFolder, fmt.Sprintf("%020d%s", dead.Seq, dataExtension)))
+	require.NoError(t, err)
+	assert.Equal(t, "lost", string(data))
+}
+
+func TestSFTP_ConcurrentPuts(t *testing.T) {
+	store, server, opts := newTestSFTP(t)
+	server.Stop()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, store.Put(fmt.Sprintf("file%d.bak", i), strings.NewReader("content")))
+		}(i)
+	}
+	wg.Wait()
+
+	// the queue keeps the order of the numbers given to operations.
+	store.smu.Lock()
+	for i := 1; i < len(store.queue); i++ {
+		assert.Less(t, store.queue[i-1].Seq, store.queue[i].Seq)
+	}
+	store.smu.Unlock()
+
+	require.NoError(t, server.Start())
+	waitSent(t, store)
+	entries, err := os.ReadDir(filepath.FromSlash(opts.Root))
+	require.NoError(t, err)
+	assert.Len(t, entries, 20)
+}
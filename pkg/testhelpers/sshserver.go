@@ -0,0 +1,186 @@
+package testhelpers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHServer is an in-process SSH server serving the SFTP subsystem over
+// the local filesystem. Only the client key written into `KeyFile` is
+// accepted and its host key is listed into `KnownHostsFile`. It can be
+// stopped and started again on the same address to simulate outages.
+type SSHServer struct {
+	Addr           string
+	User           string
+	KeyFile        string // private key of the accepted client.
+	KnownHostsFile string // known_hosts file listing the server host key.
+
+	config   *ssh.ServerConfig
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewSSHServer generates the host and client keys into the folder `dir`
+// then starts the server on a random local port. Call `Close` once done.
+func NewSSHServer(dir string) (*SSHServer, error) {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		return nil, err
+	}
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SSHServer{
+		User:           "backup",
+		KeyFile:        filepath.Join(dir, "id_ed25519"),
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		conns:          make(map[net.Conn]struct{}),
+	}
+	if err := os.WriteFile(s.KeyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+
+	clientKey := string(clientSigner.PublicKey().Marshal())
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == s.User && string(key.Marshal()) == clientKey {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %q", meta.User())
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+
+	if err := s.listen("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(s.KnownHostsFile, []byte(line+"\n"), 0o600); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// listen starts accepting connections on the address `addr`.
+func (s *SSHServer) listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.Addr = l.Addr().String()
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = struct{}{}
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// serve handles the SSH connection `conn` until it ends.
+func (s *SSHServer) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// payload is the length-prefixed subsystem name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				server.Close()
+			}
+		}()
+	}
+}
+
+// Stop closes the listener and drops every active connection.
+func (s *SSHServer) Stop() {
+	s.mu.Lock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Start accepts connections again on the same address after a `Stop`.
+func (s *SSHServer) Start() error {
+	return s.listen(s.Addr)
+}
+
+// Close stops the server.
+func (s *SSHServer) Close() {
+	s.Stop()
+}
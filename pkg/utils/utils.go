@@ -10,7 +10,7 @@ func IsDirPath(path string) bool {
 
 // CreateFolder creates complete absolute path.
 func CreateFolder(path string) error {
-	return os.MkdirAll(path, 0o644)
+	return os.MkdirAll(path, 0o755)
 }
 
 // DeleteFile removes a file based on its absolute path.
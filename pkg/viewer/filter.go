@@ -12,9 +12,10 @@ import (
 
 // IsEntryMatches returns wether the timestamp and filename specified
 // into a given log entry matches the date and regex respectively.
+// When `job` is set, the entry must also be produced by that job.
 // It decodes the log entry into a map so that each log entry line
 // could have any set of fields.
-func IsEntryMatches(logEntry, date, reg string) bool {
+func IsEntryMatches(logEntry, date, reg, job string) bool {
 	data := make(map[string]interface{})
 	err := json.Unmarshal([]byte(logEntry), &data)
 	if err != nil {
@@ -24,6 +25,9 @@ func IsEntryMatches(logEntry, date, reg string) bool {
 	if !ok || !strings.HasPrefix(ts.(string), date) {
 		return false
 	}
+	if name, _ := data["job"].(string); job != "" && name != job {
+		return false
+	}
 	path, ok := data["path"]
 	if !ok {
 		return false
@@ -37,8 +41,9 @@ func IsEntryMatches(logEntry, date, reg string) bool {
 
 // Filter process the content defined into `file` variable and displays
 // all entries produced at the date `date` involving the filename matching
-// the regex `reg`. The log file must be into the same folder as the program.
-func Filter(file io.Reader, date, reg string) (int, error) {
+// the regex `reg`. Entries could be restricted to the job `job` if set.
+// The log file must be into the same folder as the program.
+func Filter(file io.Reader, date, reg, job string) (int, error) {
 	scanner := bufio.NewScanner(file)
 	var logEntry string
 	var match bool
@@ -48,7 +53,7 @@ func Filter(file io.Reader, date, reg string) (int, error) {
 		if logEntry == "" {
 			continue
 		}
-		match = IsEntryMatches(logEntry, date, reg)
+		match = IsEntryMatches(logEntry, date, reg, job)
 		if match {
 			Print(os.Stdout, logEntry)
 		}
@@ -14,21 +14,25 @@ func TestIsEntryMatches(t *testing.T) {
 		log      string
 		date     string
 		reg      string
+		job      string
 		expected bool
 	}{
-		{"match exact path", `{"time":"2023-08-14T15:47:12.7081903Z", "path":".bak"}`, "2023-08-14", ".bak", true},
-		{"does not match exact path", `{"time":"2023-08-15T15:47:12.7081903Z", "path":"file.bak"}`, "2023-08-15", ".bak", false},
-		{"match all paths", `{"time":"2023-08-16T15:47:12.7081903Z", "path":"file.bak"}`, "2023-08-16", "*.*", true},
-		{"does not match path", `{"time":"2023-08-17T15:47:12.7081903Z", "path":"file.txt"}`, "2023-08-17", "*.bak", false},
-		{"match time and path", `{"time":"2023-08-18T15:47:12.7081903Z", "path":"_file_"}`, "2023-08-18", "*file*", true},
-		{"invalid json", `{time:"2023-08-18T15:47:12.7081903Z", "path":"_file_"}`, "2023-08-18", "*file*", false},
-		{"missing time json", `{"path":"_file_"}`, "2023-08-18", "*file*", false},
-		{"missing path field", `{"time":"2023-08-18T15:47:12.7081903Z"}`, "2023-08-18", "*file*", false},
+		{"match exact path", `{"time":"2023-08-14T15:47:12.7081903Z", "path":".bak"}`, "2023-08-14", ".bak", "", true},
+		{"does not match exact path", `{"time":"2023-08-15T15:47:12.7081903Z", "path":"file.bak"}`, "2023-08-15", ".bak", "", false},
+		{"match all paths", `{"time":"2023-08-16T15:47:12.7081903Z", "path":"file.bak"}`, "2023-08-16", "*.*", "", true},
+		{"does not match path", `{"time":"2023-08-17T15:47:12.7081903Z", "path":"file.txt"}`, "2023-08-17", "*.bak", "", false},
+		{"match time and path", `{"time":"2023-08-18T15:47:12.7081903Z", "path":"_file_"}`, "2023-08-18", "*file*", "", true},
+		{"invalid json", `{time:"2023-08-18T15:47:12.7081903Z", "path":"_file_"}`, "2023-08-18", "*file*", "", false},
+		{"missing time json", `{"path":"_file_"}`, "2023-08-18", "*file*", "", false},
+		{"missing path field", `{"time":"2023-08-18T15:47:12.7081903Z"}`, "2023-08-18", "*file*", "", false},
+		{"match job", `{"time":"2023-08-19T15:47:12.7081903Z", "job":"docs", "path":"file.bak"}`, "2023-08-19", "*.bak", "docs", true},
+		{"does not match job", `{"time":"2023-08-20T15:47:12.7081903Z", "job":"photos", "path":"file.bak"}`, "2023-08-20", "*.bak", "docs", false},
+		{"missing job field", `{"time":"2023-08-21T15:47:12.7081903Z", "path":"file.bak"}`, "2023-08-21", "*.bak", "docs", false},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.date, func(t *testing.T) {
-			got := IsEntryMatches(tc.log, tc.date, tc.reg)
+			got := IsEntryMatches(tc.log, tc.date, tc.reg, tc.job)
 			assert.Equal(t, tc.expected, got)
 		})
 	}
@@ -44,7 +48,7 @@ func TestFilter(t *testing.T) {
 	require.NoError(t, err)
 	defer file.Close()
 
-	code, err := Filter(file, "2023-08-14", "file*")
+	code, err := Filter(file, "2023-08-14", "file*", "")
 	assert.NoError(t, err)
 	assert.Equal(t, 0, code)
 }